@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/cho45/xatena-go/pkg/xatena"
+)
+
+// go test -bench . -benchmem ./bench で ToHTML と Render の割り当てを比較する
+
+func loadSample(b *testing.B, repeat int) string {
+	b.Helper()
+	data, err := os.ReadFile("../sample.txt")
+	if err != nil {
+		b.Fatal(err)
+	}
+	return strings.Repeat(string(data)+"\n", repeat)
+}
+
+// plainProse: 記法を含まない段落だけの長い文書 (ブロックパーサの振り分けが効く入力)
+func plainProse(paragraphs int) string {
+	var sb strings.Builder
+	for i := 0; i < paragraphs; i++ {
+		sb.WriteString("This is a paragraph of a long article without any notation.\n")
+		sb.WriteString("Most lines in real documents are plain prose like this one.\n")
+		sb.WriteString("日本語の文章もそのまま段落になり、ブロック記法のパーサは反応しない。\n\n")
+	}
+	return sb.String()
+}
+
+func benchmarkToHTML(b *testing.B, repeat int) {
+	benchmarkToHTMLInput(b, loadSample(b, repeat))
+}
+
+func benchmarkToHTMLInput(b *testing.B, input string) {
+	x := xatena.NewXatena()
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = x.ToHTML(ctx, input)
+	}
+}
+
+func benchmarkRender(b *testing.B, repeat int) {
+	input := loadSample(b, repeat)
+	x := xatena.NewXatena()
+	ctx := context.Background()
+	w := bufio.NewWriter(io.Discard)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := x.Render(ctx, w, input); err != nil {
+			b.Fatal(err)
+		}
+		w.Flush()
+	}
+}
+
+func BenchmarkToHTML(b *testing.B)           { benchmarkToHTML(b, 1) }
+func BenchmarkRender(b *testing.B)           { benchmarkRender(b, 1) }
+func BenchmarkToHTMLArchive(b *testing.B)    { benchmarkToHTML(b, 2000) }
+func BenchmarkRenderArchive(b *testing.B)    { benchmarkRender(b, 2000) }
+func BenchmarkToHTMLPlainProse(b *testing.B) { benchmarkToHTMLInput(b, plainProse(2000)) }
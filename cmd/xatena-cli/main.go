@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"io"
 	"net/http"
@@ -9,70 +10,62 @@ import (
 	"strings"
 	"time"
 
-	"golang.org/x/net/html"
-
+	"github.com/cho45/xatena-go/pkg/pagetitle"
 	"github.com/cho45/xatena-go/pkg/xatena"
 )
 
-var httpClient = &http.Client{Timeout: 5 * time.Second}
-
-func getTitle(ctx context.Context, uri string) string {
-	req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "[getTitle] failed to create request for %s: %v\n", uri, err)
-		return uri
-	}
-	req.Header.Set("User-Agent", "xatena-cli/1.0 (+https://github.com/cho45/xatena-go)")
+var fetcher = &pagetitle.Fetcher{
+	Client:    &http.Client{Timeout: 5 * time.Second},
+	UserAgent: "xatena-cli/1.0 (+https://github.com/cho45/xatena-go)",
+}
 
-	resp, err := httpClient.Do(req)
+// logTitleErrors: 取得に失敗した URL を標準エラーに出す (出力ではタイトルの代わりに URL を使う)
+func logTitleErrors(ctx context.Context, uri string) (string, error) {
+	title, err := fetcher.Fetch(ctx, uri)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "[getTitle] failed to GET %s: %v\n", uri, err)
-		return uri // 失敗時はURLを返す
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		fmt.Fprintf(os.Stderr, "[getTitle] non-2xx status for %s: %d\n", uri, resp.StatusCode)
-		return uri
-	}
-	// レスポンスボディのサイズ制限
-	const maxBodySize = 2 * 1024 * 1024 // 2MB
-	limitedBody := io.LimitReader(resp.Body, maxBodySize)
-	z := html.NewTokenizer(limitedBody)
-	for {
-		tt := z.Next()
-		switch tt {
-		case html.ErrorToken:
-			err := z.Err()
-			if err != io.EOF {
-				fmt.Fprintf(os.Stderr, "[getTitle] HTML parse error for %s: %v\n", uri, err)
-			}
-			return uri
-		case html.StartTagToken, html.SelfClosingTagToken:
-			t := z.Token()
-			if t.Data == "title" {
-				if z.Next() == html.TextToken {
-					title := strings.TrimSpace(z.Token().Data)
-					if title != "" {
-						return html.UnescapeString(title)
-					}
-				}
-			}
-		}
+		fmt.Fprintf(os.Stderr, "[title] %s: %v\n", uri, err)
 	}
+	return title, err
 }
 
+var (
+	markdown     = flag.Bool("markdown", false, "HTML の代わりに Markdown (CommonMark/GFM) を出力する")
+	titleCache   = flag.String("title-cache", "", ":title で取得したタイトルをキャッシュするディレクトリ")
+	titleTTL     = flag.Duration("title-cache-ttl", 24*time.Hour, "タイトルのキャッシュの有効期間")
+	titleWorkers = flag.Int("title-workers", 8, ":title のタイトルを同時に取得する数")
+	highlight    = flag.Bool("highlight", false, "super pre のコードを色分けする")
+	handlers     = flag.Bool("handlers", false, ">|csv| などの super pre を表・数式・図の <div> にする")
+)
+
 func main() {
+	flag.Parse()
 	input, err := io.ReadAll(os.Stdin)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to read stdin: %v\n", err)
 		os.Exit(1)
 	}
 
-	formatter := xatena.NewInlineFormatter(func(f *xatena.InlineFormatter) {
-		f.SetTitleHandler(getTitle)
-	})
-
-	x := xatena.NewXatenaWithInline(formatter)
-	output := x.ToHTML(context.Background(), string(input))
+	var resolver xatena.TitleResolver = xatena.TitleResolverFunc(logTitleErrors)
+	if *titleCache != "" {
+		resolver = &xatena.CachedTitleResolver{Resolver: resolver, Cache: xatena.NewDiskTitleCache(*titleCache, *titleTTL)}
+	}
+	opts := []xatena.Option{xatena.WithTitleResolver(resolver, *titleWorkers)}
+	if *highlight {
+		opts = append(opts, xatena.WithHighlighter(xatena.BuiltinHighlighter))
+	}
+	if *handlers {
+		opts = append(opts, xatena.WithSuperPreHandlers(xatena.BuiltinSuperPreHandlers()))
+	}
+	x, err := xatena.NewXatenaE(opts...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to initialize: %v\n", err)
+		os.Exit(1)
+	}
+	var output string
+	if *markdown {
+		output = x.ToMarkdown(context.Background(), string(input))
+	} else {
+		output = x.ToHTML(context.Background(), string(input))
+	}
 	fmt.Print(strings.TrimRight(output, "\n"))
 }
@@ -4,4 +4,7 @@ go 1.23.0
 
 toolchain go1.24.3
 
-require golang.org/x/net v0.40.0
+require (
+	golang.org/x/net v0.40.0
+	golang.org/x/text v0.25.0
+)
@@ -2,13 +2,15 @@ package syntax
 
 import (
 	"context"
+	"html"
 	htmltpl "html/template"
+	"io"
 	"regexp"
 	"strings"
 )
 
 var BlockquoteTemplate = htmltpl.Must(htmltpl.New("blockquote").Parse(`
-<blockquote{{if .Cite}} cite="{{.Cite}}"{{end}}>
+<blockquote{{if .Cite}} cite="{{.Cite}}"{{end}}{{with .SourcePos}} data-sourcepos="{{.}}"{{end}}>
 {{.Content}}
 {{if .Title}}<cite>{{.Title}}</cite>{{end}}
 </blockquote>
@@ -16,11 +18,18 @@ var BlockquoteTemplate = htmltpl.Must(htmltpl.New("blockquote").Parse(`
 
 // BlockquoteNode represents a blockquote block.
 type BlockquoteNode struct {
+	Position
 	Cite    string // cite URL (optional)
 	Content []Node // nested block nodes
 }
 
 func (b *BlockquoteNode) ToHTML(ctx context.Context, xatena XatenaContext, options CallerOptions) string {
+	return renderString(func(w io.Writer) error {
+		return b.WriteHTML(ctx, w, xatena, options)
+	})
+}
+
+func (b *BlockquoteNode) WriteHTML(ctx context.Context, w io.Writer, xatena XatenaContext, options CallerOptions) error {
 	citeText := b.Cite
 	title := ""
 	uri := ""
@@ -30,10 +39,10 @@ func (b *BlockquoteNode) ToHTML(ctx context.Context, xatena XatenaContext, optio
 				parts := strings.SplitN(citeText, ":title=", 2)
 				uri = parts[0]
 				titleText := parts[1]
-				title = `<a href="` + uri + `">` + htmltpl.HTMLEscapeString(titleText) + `</a>`
+				title = `<a href="` + html.EscapeString(uri) + `">` + htmltpl.HTMLEscapeString(titleText) + `</a>`
 			} else if strings.Contains(citeText, ":title") {
 				uri = strings.SplitN(citeText, ":title", 2)[0]
-				title = `<a href="` + uri + `">Example Web Page</a>`
+				title = `<a href="` + html.EscapeString(uri) + `">Example Web Page</a>`
 			} else {
 				uri = citeText
 				title = xatena.GetInline().Format(ctx, "["+citeText+"]")
@@ -42,19 +51,16 @@ func (b *BlockquoteNode) ToHTML(ctx context.Context, xatena XatenaContext, optio
 			title = xatena.GetInline().Format(ctx, citeText)
 		}
 		if m := reHref.FindStringSubmatch(title); m != nil {
-			uri = m[1]
+			uri = html.UnescapeString(m[1]) // テンプレート側で再度エスケープされる
 		} else if isURL(citeText) {
 			uri = citeText
 		}
 	}
-	content := ContentToHTML(b, ctx, xatena, options)
-
-	html := xatena.ExecuteTemplate("blockquote", map[string]interface{}{
-		"Cite":    uri,
-		"Title":   htmltpl.HTML(title),
-		"Content": htmltpl.HTML(content),
+	return writeContentTemplate(b, ctx, w, xatena, options, "blockquote", map[string]interface{}{
+		"Cite":      uri,
+		"Title":     htmltpl.HTML(title),
+		"SourcePos": SourcePos(xatena, b.Position),
 	})
-	return html
 }
 
 func (b *BlockquoteNode) GetContent() []Node {
@@ -79,10 +85,15 @@ func (p *BlockquoteParser) CanHandle(line string) bool {
 	return strings.HasPrefix(line, ">") || strings.HasPrefix(line, "<<")
 }
 
+func (p *BlockquoteParser) Triggers() BlockTriggers {
+	return BlockTriggers{Prefixes: []string{">", "<<"}}
+}
+
 func (p *BlockquoteParser) Parse(scanner *LineScanner, parent HasContent, stack *[]HasContent) bool {
 	// BEGINNING: ^>(.*?)>$
 	if scanner.Scan(reBlockquote) {
 		node := &BlockquoteNode{}
+		node.SetPosition(Position{StartLine: scanner.Pos(), EndLine: scanner.Pos()})
 		m := scanner.Matched()
 		if len(m) > 1 {
 			txt := strings.TrimSpace(m[1])
@@ -94,18 +105,27 @@ func (p *BlockquoteParser) Parse(scanner *LineScanner, parent HasContent, stack
 	}
 	// ENDOFNODE: ^<<$
 	if scanner.Scan(reBlockquoteEnd) {
-		// Sectionノードを飛ばしてpop
-		for len(*stack) > 0 {
-			if _, ok := (*stack)[len(*stack)-1].(*SectionNode); ok {
-				*stack = (*stack)[:len(*stack)-1]
-			} else {
+		if lastIndexOfStack(*stack, func(n HasContent) bool {
+			_, ok := n.(*BlockquoteNode)
+			return ok
+		}) < 0 {
+			scanner.Report(SeverityWarning, "stray << without matching >>")
+		}
+		// Sectionノードを飛ばしてpop (ルートは pop しない)
+		i := len(*stack) - 1
+		for i > 0 {
+			if _, ok := (*stack)[i].(*SectionNode); !ok {
 				break
 			}
+			i--
+		}
+		if i <= 0 {
+			return true
 		}
-		if len(*stack) == 0 {
-			return false
+		if b, ok := (*stack)[i].(*BlockquoteNode); ok {
+			b.EndLine = scanner.Pos()
 		}
-		*stack = (*stack)[:len(*stack)-1]
+		*stack = (*stack)[:i]
 		return true
 	}
 	return false
@@ -3,20 +3,29 @@ package syntax
 import (
 	"context"
 	htmltpl "html/template"
+	"io"
 	"regexp"
+	"strings"
 )
 
 var CommentTemplate = htmltpl.Must(htmltpl.New("comment").Parse(`
 {{.Content}}
 `))
 
-type CommentNode struct{}
+type CommentNode struct {
+	Position
+}
 
 func (c *CommentNode) ToHTML(ctx context.Context, xatena XatenaContext, options CallerOptions) string {
-	html := xatena.ExecuteTemplate("comment", map[string]interface{}{
+	return renderString(func(w io.Writer) error {
+		return c.WriteHTML(ctx, w, xatena, options)
+	})
+}
+
+func (c *CommentNode) WriteHTML(ctx context.Context, w io.Writer, xatena XatenaContext, options CallerOptions) error {
+	return xatena.WriteTemplate(w, "comment", map[string]interface{}{
 		"Content": htmltpl.HTML("<!-- -->"),
 	})
-	return html
 }
 func (c *CommentNode) AddChild(n Node)    {}
 func (c *CommentNode) GetContent() []Node { return nil }
@@ -27,20 +36,28 @@ var reBegin = regexp.MustCompile(`^(.*)<!--.*?(-->)?$`)
 var reEnd = regexp.MustCompile(`^-->$`)
 
 func (p *CommentParser) CanHandle(line string) bool {
-	return true
+	return strings.Contains(line, "<!--")
+}
+
+func (p *CommentParser) Triggers() BlockTriggers {
+	return BlockTriggers{Substrings: []string{"<!--"}}
 }
 
 func (p *CommentParser) Parse(scanner *LineScanner, parent HasContent, stack *[]HasContent) bool {
 	if scanner.Scan(reBegin) {
 		m := scanner.Matched()
+		start := scanner.Pos()
 		pre := m[1]
 		if pre != "" {
-			parent.AddChild(&TextNode{Text: pre})
+			parent.AddChild(&TextNode{Text: pre, Position: Position{StartLine: start, EndLine: start}, Offset: scanner.LineOffset(start)})
 		}
 		if m[2] == "" {
-			scanner.ScanUntil(reEnd)
+			lines := scanner.ScanUntil(reEnd)
+			if len(lines) == 0 || !reEnd.MatchString(lines[len(lines)-1]) {
+				scanner.ReportAt(start, SeverityError, "unclosed comment: missing -->")
+			}
 		}
-		parent.AddChild(&CommentNode{})
+		parent.AddChild(&CommentNode{Position: Position{StartLine: start, EndLine: scanner.Pos()}})
 		return true
 	}
 	return false
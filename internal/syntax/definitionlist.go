@@ -3,12 +3,13 @@ package syntax
 import (
 	"context"
 	htmltpl "html/template"
+	"io"
 	"regexp"
 	"strings"
 )
 
 var DefinitionListTemplate = htmltpl.Must(htmltpl.New("definitionlist").Parse(`
-<dl>
+<dl{{with .SourcePos}} data-sourcepos="{{.}}"{{end}}>
 {{- range .Items}}
   <dt>{{.Term}}</dt>
   {{- range .Descs}}
@@ -25,36 +26,51 @@ func (p *DefinitionListParser) CanHandle(line string) bool {
 	return strings.HasPrefix(line, ":")
 }
 
+func (p *DefinitionListParser) Triggers() BlockTriggers {
+	return BlockTriggers{Prefixes: []string{":"}}
+}
+
 // DefinitionListNode represents a definition list block
 type DefinitionListNode struct {
+	Position
 	Items []DefinitionItemNode
 }
 
 type DefinitionItemNode struct {
-	Term  string
-	Descs []string // 複数のddを保持
+	Term        string
+	Descs       []string       // 複数のddを保持
+	TermInlines []InlineNode   // Term をパースしたインライン要素 (パースしていなければ nil)
+	DescInlines [][]InlineNode // Descs をそれぞれパースしたもの (パースしていなければ nil)
 }
 
 func (d *DefinitionListNode) ToHTML(ctx context.Context, xatena XatenaContext, options CallerOptions) string {
+	return renderString(func(w io.Writer) error {
+		return d.WriteHTML(ctx, w, xatena, options)
+	})
+}
+
+func (d *DefinitionListNode) WriteHTML(ctx context.Context, w io.Writer, xatena XatenaContext, options CallerOptions) error {
 	type item struct {
 		Term  htmltpl.HTML
 		Descs []htmltpl.HTML
 	}
 	var items []item
-	inline := xatena.GetInline()
 	for _, it := range d.Items {
 		var descs []htmltpl.HTML
-		for _, desc := range it.Descs {
-			descs = append(descs, htmltpl.HTML(inline.Format(ctx, desc)))
+		for i, desc := range it.Descs {
+			var inlines []InlineNode
+			if i < len(it.DescInlines) {
+				inlines = it.DescInlines[i]
+			}
+			descs = append(descs, htmltpl.HTML(formatInline(ctx, xatena, desc, inlines)))
 		}
 		items = append(items, item{
-			Term:  htmltpl.HTML(inline.Format(ctx, it.Term)),
+			Term:  htmltpl.HTML(formatInline(ctx, xatena, it.Term, it.TermInlines)),
 			Descs: descs,
 		})
 	}
-	params := map[string]interface{}{"Items": items}
-	html := xatena.ExecuteTemplate("definitionlist", params)
-	return html
+	params := map[string]interface{}{"Items": items, "SourcePos": SourcePos(xatena, d.Position)}
+	return xatena.WriteTemplate(w, "definitionlist", params)
 }
 
 func (d *DefinitionListNode) AddChild(n Node) {
@@ -71,6 +87,7 @@ func (d *DefinitionListNode) GetContent() []Node {
 type DefinitionListParser struct{}
 
 func (p *DefinitionListParser) Parse(scanner *LineScanner, parent HasContent, stack *[]HasContent) bool {
+	start := scanner.Pos() + 1
 	var lines []string
 	matched := false
 	for !scanner.EOF() {
@@ -112,6 +129,7 @@ func (p *DefinitionListParser) Parse(scanner *LineScanner, parent HasContent, st
 		items = append(items, DefinitionItemNode{Term: currentTerm, Descs: currentDescs})
 	}
 	node := &DefinitionListNode{Items: items}
+	node.SetPosition(Position{StartLine: start, EndLine: scanner.Pos()})
 	parent.AddChild(node)
 	return true
 }
@@ -0,0 +1,69 @@
+package syntax
+
+import (
+	"context"
+	"fmt"
+)
+
+// Severity は診断の重要度
+type Severity int
+
+const (
+	SeverityError   Severity = iota // 出力が意図と大きく異なる (閉じられていないブロックなど)
+	SeverityWarning                 // 出力はされるが記法の誤りと思われるもの
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// Diagnostic はパース・レンダリング中に見つかった問題
+type Diagnostic struct {
+	Line     int // 1 始まりの行番号。0 は位置不明
+	Severity Severity
+	Message  string
+}
+
+func (d Diagnostic) String() string {
+	if d.Line == 0 {
+		return fmt.Sprintf("%s: %s", d.Severity, d.Message)
+	}
+	return fmt.Sprintf("%d: %s: %s", d.Line, d.Severity, d.Message)
+}
+
+type diagnosticReporterKey struct{}
+
+// WithDiagnosticReporter: レンダリング中の診断 (インライン記法など) の報告先を ctx に設定する
+func WithDiagnosticReporter(ctx context.Context, report func(Diagnostic)) context.Context {
+	return context.WithValue(ctx, diagnosticReporterKey{}, report)
+}
+
+type diagnosticLineKey struct{}
+
+// WithDiagnosticLine: ctx の下で報告される行番号なしの診断に line を補う
+func WithDiagnosticLine(ctx context.Context, line int) context.Context {
+	return context.WithValue(ctx, diagnosticLineKey{}, line)
+}
+
+// DiagnosticReporter: ctx に設定された報告先を返す。なければ nil
+func DiagnosticReporter(ctx context.Context) func(Diagnostic) {
+	report, _ := ctx.Value(diagnosticReporterKey{}).(func(Diagnostic))
+	if report == nil {
+		return nil
+	}
+	if line, ok := ctx.Value(diagnosticLineKey{}).(int); ok {
+		return func(d Diagnostic) {
+			if d.Line == 0 {
+				d.Line = line
+			}
+			report(d)
+		}
+	}
+	return report
+}
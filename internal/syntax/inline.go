@@ -5,3 +5,101 @@ import "context"
 type Inline interface {
 	Format(ctx context.Context, s string) string
 }
+
+// InlineNode はインライン記法をパースした結果の要素
+type InlineNode interface {
+	inlineNode()
+	GetInlinePos() InlinePos
+	SetInlinePos(pos InlinePos)
+}
+
+// InlinePos はインライン要素の、パースしたテキスト中のバイト範囲 [Start, End)
+// 段落のテキストは各行を \n でつないだものなので、入力全体でのオフセットは
+// 該当する行の TextNode.Offset に行内の位置を足して求める
+type InlinePos struct {
+	Start int
+	End   int
+}
+
+func (p InlinePos) GetInlinePos() InlinePos {
+	return p
+}
+
+func (p *InlinePos) SetInlinePos(pos InlinePos) {
+	*p = pos
+}
+
+// InlineTextNode は記法ではない文字列 ([]...[] で囲んだ部分なども含む)
+type InlineTextNode struct {
+	InlinePos
+	Text string
+}
+
+// LinkNode は [url], [url:title], [url:title=...] と括弧のない URL
+type LinkNode struct {
+	InlinePos
+	URL        string
+	Title      string // [url:title=...] で指定したタイトル
+	FetchTitle bool   // [url:title] のようにタイトルを取得して表示する
+	Auto       bool   // 括弧で囲まれていない URL
+}
+
+// FootnoteRefNode は ((...)) の脚注。番号は出力するときに振る
+type FootnoteRefNode struct {
+	InlinePos
+	Note string
+}
+
+// RawHTMLNode はそのまま出力する HTML (入力中のタグや、追加したルールの出力)
+type RawHTMLNode struct {
+	InlinePos
+	HTML string
+}
+
+// HTMLCommentNode は <!-- ... -->。中身は出力しない
+type HTMLCommentNode struct {
+	InlinePos
+}
+
+// TexNode は [tex:...]
+type TexNode struct {
+	InlinePos
+	Expr string
+}
+
+// MailToNode は [mailto:...]
+type MailToNode struct {
+	InlinePos
+	Address string
+}
+
+// BarcodeNode は [url:barcode]
+type BarcodeNode struct {
+	InlinePos
+	URL string
+}
+
+func (*InlineTextNode) inlineNode()  {}
+func (*LinkNode) inlineNode()        {}
+func (*FootnoteRefNode) inlineNode() {}
+func (*RawHTMLNode) inlineNode()     {}
+func (*HTMLCommentNode) inlineNode() {}
+func (*TexNode) inlineNode()         {}
+func (*MailToNode) inlineNode()      {}
+func (*BarcodeNode) inlineNode()     {}
+
+// InlineRenderer はパース済みのインライン要素を出力できる Inline 実装が満たすインターフェース
+type InlineRenderer interface {
+	Render(ctx context.Context, nodes []InlineNode) string
+}
+
+// formatInline: パース済みの要素があればそれを、なければ text を整形する
+func formatInline(ctx context.Context, xatena XatenaContext, text string, nodes []InlineNode) string {
+	inline := xatena.GetInline()
+	if nodes != nil {
+		if r, ok := inline.(InlineRenderer); ok {
+			return r.Render(ctx, nodes)
+		}
+	}
+	return inline.Format(ctx, text)
+}
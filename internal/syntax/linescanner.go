@@ -6,14 +6,59 @@ import (
 )
 
 type LineScanner struct {
-	lines   []string
-	pos     int
-	matched []string
+	lines       []string
+	offsets     []int // 各行の先頭のバイトオフセット
+	pos         int
+	matched     []string
+	diagnostics []Diagnostic
 }
 
 func NewLineScanner(input string) *LineScanner {
 	lines := strings.Split(input, "\n")
-	return &LineScanner{lines: lines, pos: 0}
+	return &LineScanner{lines: lines, offsets: lineOffsets(lines), pos: 0}
+}
+
+func lineOffsets(lines []string) []int {
+	offsets := make([]int, len(lines))
+	off := 0
+	for i, line := range lines {
+		offsets[i] = off
+		off += len(line) + 1
+	}
+	return offsets
+}
+
+// SourceLineOffsets: 改行を正規化する前の input の各行 (\r\n, \r, \n で区切る) の先頭のバイトオフセット
+func SourceLineOffsets(input string) []int {
+	offsets := []int{0}
+	for i := 0; i < len(input); i++ {
+		switch input[i] {
+		case '\r':
+			if i+1 < len(input) && input[i+1] == '\n' {
+				i++
+			}
+			offsets = append(offsets, i+1)
+		case '\n':
+			offsets = append(offsets, i+1)
+		}
+	}
+	return offsets
+}
+
+// SetLineOffsets: LineOffset が返す各行の先頭のオフセットを offsets にする
+// 改行を正規化した入力を読むときに、元の入力でのオフセットを返すために使う
+func (s *LineScanner) SetLineOffsets(offsets []int) {
+	if len(offsets) == len(s.lines) {
+		s.offsets = offsets
+	}
+}
+
+// LineOffset: 1 始まりの行番号 line の先頭の、入力全体でのバイトオフセット
+func (s *LineScanner) LineOffset(line int) int {
+	if line < 1 || line > len(s.offsets) {
+		return -1
+	}
+	return s.offsets[line-1]
 }
 
 func (s *LineScanner) Next() string {
@@ -46,6 +91,7 @@ func (s *LineScanner) Pos() int {
 
 func (s *LineScanner) SetLines(lines []string) {
 	s.lines = lines
+	s.offsets = lineOffsets(lines)
 	s.pos = 0
 }
 
@@ -64,6 +110,20 @@ func (s *LineScanner) Scan(re *regexp.Regexp) bool {
 	return false
 }
 
+// Report: 直前に読んだ行の位置で診断を記録する
+func (s *LineScanner) Report(severity Severity, message string) {
+	s.ReportAt(s.pos, severity, message)
+}
+
+// ReportAt: 1 始まりの行番号 line の位置で診断を記録する
+func (s *LineScanner) ReportAt(line int, severity Severity, message string) {
+	s.diagnostics = append(s.diagnostics, Diagnostic{Line: line, Severity: severity, Message: message})
+}
+
+func (s *LineScanner) Diagnostics() []Diagnostic {
+	return s.diagnostics
+}
+
 func (s *LineScanner) Matched() []string {
 	return s.matched
 }
@@ -455,3 +455,25 @@ func TestLineScannerEdgeCases(t *testing.T) {
 		}
 	})
 }
+
+func TestLineScanner_Report(t *testing.T) {
+	scanner := NewLineScanner("line1\nline2\nline3")
+	scanner.Next()
+	scanner.Next()
+	scanner.Report(SeverityWarning, "on line2")
+	scanner.ReportAt(1, SeverityError, "on line1")
+
+	expected := []Diagnostic{
+		{Line: 2, Severity: SeverityWarning, Message: "on line2"},
+		{Line: 1, Severity: SeverityError, Message: "on line1"},
+	}
+	got := scanner.Diagnostics()
+	if len(got) != len(expected) {
+		t.Fatalf("Diagnostics() = %v, want %v", got, expected)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("Diagnostics()[%d] = %v, want %v", i, got[i], expected[i])
+		}
+	}
+}
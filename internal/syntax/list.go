@@ -3,6 +3,7 @@ package syntax
 import (
 	"context"
 	htmltpl "html/template"
+	"io"
 	"regexp"
 	"strings"
 )
@@ -10,54 +11,67 @@ import (
 var ListTemplate = htmltpl.Must(htmltpl.New("list").Parse(`
 {{.OpenTag}}
 {{range .Items}}
-  <li>{{range .Content}}{{.}}{{end}}</li>
+  <li{{with .SourcePos}} data-sourcepos="{{.}}"{{end}}>{{range .Content}}{{.}}{{end}}</li>
 {{end}}
 {{.CloseTag}}
 `))
 
 // ListNode represents a list block (ordered or unordered)
 type ListNode struct {
+	Position
 	Items []*ListStructNode
 }
 
 type ListStructNode struct {
+	Position
 	Name  string // "ul" or "ol"
 	Items []*ListItemNode
 }
 
 type ListItemNode struct {
+	Position
 	Content []interface{} // string or *ListStructNode
+	Inlines []InlineNode  // Content の文字列をパースしたインライン要素 (パースしていなければ nil)
 }
 
 func (l *ListNode) ToHTML(ctx context.Context, xatena XatenaContext, options CallerOptions) string {
-	var html string
+	return renderString(func(w io.Writer) error {
+		return l.WriteHTML(ctx, w, xatena, options)
+	})
+}
+
+func (l *ListNode) WriteHTML(ctx context.Context, w io.Writer, xatena XatenaContext, options CallerOptions) error {
 	for _, list := range l.Items {
-		html += listStructToHTML(list, ctx, xatena)
+		if err := writeListStruct(list, ctx, w, xatena); err != nil {
+			return err
+		}
 	}
-	return html
+	return nil
 }
 
-// 1つのListStructNode（ul/ol）を再帰的にHTML化
-func listStructToHTML(list *ListStructNode, ctx context.Context, xatena XatenaContext) string {
+// 1つのListStructNode（ul/ol）を再帰的にHTML化する。入れ子のリストも w に直接書き出す
+func writeListStruct(list *ListStructNode, ctx context.Context, w io.Writer, xatena XatenaContext) error {
+	slots := newContentSlots()
 	var items []map[string]interface{}
 	for _, item := range list.Items {
 		var content []interface{}
 		for _, child := range item.Content {
 			switch v := child.(type) {
 			case string:
-				content = append(content, htmltpl.HTML(xatena.GetInline().Format(ctx, v)))
+				content = append(content, htmltpl.HTML(formatInline(ctx, xatena, v, item.Inlines)))
 			case *ListStructNode:
-				content = append(content, htmltpl.HTML(listStructToHTML(v, ctx, xatena)))
+				content = append(content, slots.add(func(w io.Writer) error {
+					return writeListStruct(v, ctx, w, xatena)
+				}))
 			}
 		}
-		items = append(items, map[string]interface{}{"Content": content})
+		items = append(items, map[string]interface{}{"Content": content, "SourcePos": SourcePos(xatena, item.Position)})
 	}
-	html := xatena.ExecuteTemplate("list", map[string]interface{}{
-		"OpenTag":  htmltpl.HTML("<" + list.Name + ">"),
+	return slots.writeTemplate(w, xatena, "list", map[string]interface{}{
+		"OpenTag":  htmltpl.HTML("<" + list.Name + sourcePosAttr(xatena, list.Position) + ">"),
 		"CloseTag": htmltpl.HTML("</" + list.Name + ">"),
 		"Items":    items,
 	})
-	return html
 }
 
 func (l *ListNode) AddChild(n Node)    {}
@@ -73,28 +87,36 @@ func (p *ListParser) CanHandle(line string) bool {
 	return strings.HasPrefix(line, "-") || strings.HasPrefix(line, "+")
 }
 
+func (p *ListParser) Triggers() BlockTriggers {
+	return BlockTriggers{Prefixes: []string{"-", "+"}}
+}
+
 func (p *ListParser) Parse(scanner *LineScanner, parent HasContent, stack *[]HasContent) bool {
 	if !scanner.Scan(reList) {
 		return false
 	}
 	var lines [][]string
+	var lineNos []int
 	m := scanner.Matched()
 	lines = append(lines, []string{m[0], m[1], m[2]})
+	lineNos = append(lineNos, scanner.Pos())
 	for !scanner.EOF() {
 		if !scanner.Scan(reList) {
 			break
 		}
 		m := scanner.Matched()
 		lines = append(lines, []string{m[0], m[1], m[2]})
+		lineNos = append(lineNos, scanner.Pos())
 	}
 	if len(lines) == 0 {
 		return false
 	}
 	var ret []*ListStructNode
 	var listStack []*ListStructNode
-	for _, row := range lines {
+	for i, row := range lines {
 		// row[0]: line, row[1]: marks, row[2]: text
 		marks, text := row[1], row[2]
+		lineNo := lineNos[i]
 		level := len(marks)
 		// ul/ol 判定: 記号列の最後が + なら ol, それ以外は ul
 		var typ string
@@ -110,11 +132,19 @@ func (p *ListParser) Parse(scanner *LineScanner, parent HasContent, stack *[]Has
 		for level < len(listStack) {
 			listStack = listStack[:len(listStack)-1]
 		}
+		// 祖先のリスト・項目の終了行をこの行まで広げる
+		for d, l := range listStack {
+			l.EndLine = lineNo
+			if d < level-1 && len(l.Items) > 0 {
+				l.Items[len(l.Items)-1].EndLine = lineNo
+			}
+		}
 		if level == len(listStack) && len(listStack) > 0 && listStack[len(listStack)-1].Name != typ {
 			listStack = listStack[:len(listStack)-1]
 		}
 		for len(listStack) < level {
 			container := &ListStructNode{Name: typ, Items: []*ListItemNode{}}
+			container.SetPosition(Position{StartLine: lineNo, EndLine: lineNo})
 			if len(listStack) > 0 {
 				lastItems := listStack[len(listStack)-1].Items
 				if len(lastItems) > 0 {
@@ -122,6 +152,7 @@ func (p *ListParser) Parse(scanner *LineScanner, parent HasContent, stack *[]Has
 					lastLi.Content = append(lastLi.Content, container)
 				} else {
 					item := &ListItemNode{Content: []interface{}{container}}
+					item.SetPosition(Position{StartLine: lineNo, EndLine: lineNo})
 					listStack[len(listStack)-1].Items = append(listStack[len(listStack)-1].Items, item)
 				}
 			} else {
@@ -130,9 +161,11 @@ func (p *ListParser) Parse(scanner *LineScanner, parent HasContent, stack *[]Has
 			listStack = append(listStack, container)
 		}
 		item := &ListItemNode{Content: []interface{}{text}}
+		item.SetPosition(Position{StartLine: lineNo, EndLine: lineNo})
 		listStack[len(listStack)-1].Items = append(listStack[len(listStack)-1].Items, item)
 	}
 	node := &ListNode{Items: ret}
+	node.SetPosition(Position{StartLine: lineNos[0], EndLine: lineNos[len(lineNos)-1]})
 	parent.AddChild(node)
 	return true
 }
@@ -1,9 +1,16 @@
 package syntax
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	htmltpl "html/template"
+	"io"
+	"math/rand/v2"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 )
 
 type CallerOptions struct {
@@ -13,13 +20,108 @@ type CallerOptions struct {
 type XatenaContext interface {
 	GetInline() Inline
 	ExecuteTemplate(name string, params map[string]interface{}) string
+	WriteTemplate(w io.Writer, name string, params map[string]interface{}) error
 	PreferHatenaCompatible() bool
+	PreferSourcePos() bool // ブロック要素に data-sourcepos 属性を出力するかどうか
 }
 
 type Node interface {
 	ToHTML(ctx context.Context, xatena XatenaContext, options CallerOptions) string
 }
 
+// HTMLWriter は HTML を文字列を経由せず io.Writer に直接書き出せる Node
+// 実装していない Node は ToHTML の結果が書き出される
+type HTMLWriter interface {
+	WriteHTML(ctx context.Context, w io.Writer, xatena XatenaContext, options CallerOptions) error
+}
+
+// WriteNode: n を w に書き出す
+func WriteNode(ctx context.Context, w io.Writer, n Node, xatena XatenaContext, options CallerOptions) error {
+	if hw, ok := n.(HTMLWriter); ok {
+		return hw.WriteHTML(ctx, w, xatena, options)
+	}
+	_, err := io.WriteString(w, n.ToHTML(ctx, xatena, options))
+	return err
+}
+
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// renderString: write の出力をプールしたバッファに受けて文字列で返す
+// テンプレートに埋め込む子要素の HTML など、文字列が必要な箇所で使う
+func renderString(write func(w io.Writer) error) string {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	write(buf)
+	s := buf.String()
+	bufferPool.Put(buf)
+	return s
+}
+
+// contentSlots はテンプレートに埋め込む子要素を、文字列にせず直接書き出すための目印を管理する
+// テンプレートには目印の文字列を渡し、出力のうち目印の位置で子要素を w に書き出す
+type contentSlots struct {
+	prefix  string // 入力に偶然含まれないよう描画ごとに変える
+	writers []func(w io.Writer) error
+}
+
+func newContentSlots() *contentSlots {
+	return &contentSlots{prefix: fmt.Sprintf("xatenaslot%016x", rand.Uint64())}
+}
+
+// add: write の出力を埋め込む位置の目印を返す
+func (s *contentSlots) add(write func(w io.Writer) error) htmltpl.HTML {
+	s.writers = append(s.writers, write)
+	return htmltpl.HTML(fmt.Sprintf("%sn%dz", s.prefix, len(s.writers)-1))
+}
+
+// writeTemplate: テンプレート name の出力を w に書き出す。目印の位置には子要素を書き出し、そのエラーも返す
+func (s *contentSlots) writeTemplate(w io.Writer, xatena XatenaContext, name string, params map[string]interface{}) error {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer bufferPool.Put(buf)
+	if err := xatena.WriteTemplate(buf, name, params); err != nil {
+		return err
+	}
+	out := buf.String()
+	ew := &errWriter{w: w}
+	for {
+		i := strings.Index(out, s.prefix+"n")
+		if i < 0 {
+			break
+		}
+		rest := out[i+len(s.prefix)+1:]
+		end := strings.IndexByte(rest, 'z')
+		if end < 0 {
+			break
+		}
+		n, err := strconv.Atoi(rest[:end])
+		if err != nil || n >= len(s.writers) {
+			break
+		}
+		ew.WriteString(out[:i])
+		if ew.err != nil {
+			return ew.err
+		}
+		if err := s.writers[n](w); err != nil {
+			return err
+		}
+		out = rest[end+1:]
+	}
+	ew.WriteString(out)
+	return ew.err
+}
+
+// writeContentTemplate: r の子ノードを params["Content"] としてテンプレート name で書き出す
+func writeContentTemplate(r HasContent, ctx context.Context, w io.Writer, xatena XatenaContext, options CallerOptions, name string, params map[string]interface{}) error {
+	slots := newContentSlots()
+	params["Content"] = slots.add(func(w io.Writer) error {
+		return WriteContent(r, ctx, w, xatena, options)
+	})
+	return slots.writeTemplate(w, xatena, name, params)
+}
+
 type HasContent interface {
 	AddChild(n Node)
 	GetContent() []Node
@@ -44,49 +146,130 @@ var reToHTMLParagraph = regexp.MustCompile(`(\n{2,})`)
 var reToHTMLParagraphLineBreak = regexp.MustCompile(`^\n+$`)
 
 func ToHTMLParagraph(ctx context.Context, text string, xatena XatenaContext, options CallerOptions) string {
-	text = xatena.GetInline().Format(ctx, text)
+	return renderString(func(w io.Writer) error {
+		return writeParagraph(ctx, w, text, nil, xatena, options)
+	})
+}
+
+// writeParagraph: lines は段落の元の行 (位置情報とパース済みのインライン要素に使う。なければ nil)
+func writeParagraph(ctx context.Context, w io.Writer, text string, lines []*TextNode, xatena XatenaContext, options CallerOptions) error {
+	raw := text
+	text = formatInline(ctx, xatena, text, paragraphInlines(lines))
 	if options.stopp {
-		return text
+		_, err := io.WriteString(w, text)
+		return err
 	}
 	parts := reSplitWithSep(reToHTMLParagraph, text)
+	positions := paragraphPositions(xatena, reToHTMLParagraph, raw, lines, len(parts))
 
-	html := "<p>"
-	for _, para := range parts {
+	ew := &errWriter{w: w}
+	openParagraph(ew, xatena, positions, 0)
+	for i, para := range parts {
 		if reToHTMLParagraphLineBreak.MatchString(para) {
-			html += "</p>" + strings.Repeat("<br />\n", (len(para)-2)) + "<p>"
+			ew.WriteString("</p>")
+			for i := 0; i < len(para)-2; i++ {
+				ew.WriteString("<br />\n")
+			}
+			openParagraph(ew, xatena, positions, i+1)
 		} else {
-			html += strings.Join(SplitForBreak(para), "<br />\n")
+			for i, line := range SplitForBreak(para) {
+				if i > 0 {
+					ew.WriteString("<br />\n")
+				}
+				ew.WriteString(line)
+			}
 		}
 	}
-	html += "</p>"
-	return html
+	ew.WriteString("</p>")
+	return ew.err
+}
+
+// openParagraph: parts[i] から始まる段落の <p> を書く
+func openParagraph(ew *errWriter, xatena XatenaContext, positions []Position, i int) {
+	if i < len(positions) {
+		ew.WriteString("<p" + sourcePosAttr(xatena, positions[i]) + ">")
+		return
+	}
+	ew.WriteString("<p>")
+}
+
+// paragraphPositions: 整形前の段落 text を re で分けた各部分の元の行範囲 (data-sourcepos を出力しないときは nil)
+// インライン記法が段落の区切りをまたいで整形後の部分の数 n と合わないときは、どれも段落全体の範囲にする
+func paragraphPositions(xatena XatenaContext, re *regexp.Regexp, text string, lines []*TextNode, n int) []Position {
+	if len(lines) == 0 || lines[0].StartLine == 0 || !xatena.PreferSourcePos() {
+		return nil
+	}
+	var lineNo []int // text の各行の元の行番号
+	for _, l := range lines {
+		for i := 0; i <= strings.Count(l.Text, "\n"); i++ {
+			lineNo = append(lineNo, l.StartLine+i)
+		}
+	}
+	at := func(k int) int {
+		if k >= len(lineNo) {
+			k = len(lineNo) - 1
+		}
+		return lineNo[k]
+	}
+	cur := 0
+	if strings.HasPrefix(text, "\n") { // インライン記法の整形と同じく先頭の改行を除く
+		text, cur = text[1:], 1
+	}
+	positions := make([]Position, n)
+	parts := reSplitWithSep(re, text)
+	if len(parts) != n {
+		for i := range positions {
+			positions[i] = Position{StartLine: lineNo[0], EndLine: lineNo[len(lineNo)-1]}
+		}
+		return positions
+	}
+	for i, part := range parts {
+		end := cur + strings.Count(strings.TrimRight(part, "\n"), "\n")
+		positions[i] = Position{StartLine: at(cur), EndLine: at(end)}
+		cur += strings.Count(part, "\n")
+	}
+	return positions
 }
 
 var reToHTMLParagraphHatenaCompatible = regexp.MustCompile(`(\n+)`)
 var reToHTMLParagraphHatenaCompatibleLineBreak = regexp.MustCompile(`^(\n+)$`)
 
 func ToHTMLParagraphHatenaCompatible(ctx context.Context, text string, xatena XatenaContext, options CallerOptions) string {
-	text = xatena.GetInline().Format(ctx, text)
+	return renderString(func(w io.Writer) error {
+		return writeParagraphHatenaCompatible(ctx, w, text, nil, xatena, options)
+	})
+}
+
+func writeParagraphHatenaCompatible(ctx context.Context, w io.Writer, text string, lines []*TextNode, xatena XatenaContext, options CallerOptions) error {
+	raw := strings.TrimSuffix(text, "\n")
+	text = formatInline(ctx, xatena, text, paragraphInlines(lines))
 	text = strings.TrimSuffix(text, "\n") // Remove trailing newline
 	if options.stopp {
-		return text
+		_, err := io.WriteString(w, text)
+		return err
 	}
 	parts := reSplitWithSep(reToHTMLParagraphHatenaCompatible, text)
+	positions := paragraphPositions(xatena, reToHTMLParagraphHatenaCompatible, raw, lines, len(parts))
 
-	html := "<p>"
-	for _, para := range parts {
+	ew := &errWriter{w: w}
+	openParagraph(ew, xatena, positions, 0)
+	for i, para := range parts {
 		if m := reToHTMLParagraphHatenaCompatibleLineBreak.FindStringSubmatch(para); m != nil {
 			brs := len(m[1]) - 2
 			if brs < 0 {
 				brs = 0
 			}
-			html += "</p>" + strings.Repeat("<br />\n", brs) + "<p>"
+			ew.WriteString("</p>")
+			for i := 0; i < brs; i++ {
+				ew.WriteString("<br />\n")
+			}
+			openParagraph(ew, xatena, positions, i+1)
 		} else {
-			html += para
+			ew.WriteString(para)
 		}
 	}
-	html += "</p>"
-	return html
+	ew.WriteString("</p>")
+	return ew.err
 }
 
 //	local *Text::Xatena::Node::as_html_paragraph = sub {
@@ -110,29 +293,76 @@ func ToHTMLParagraphHatenaCompatible(ctx context.Context, text string, xatena Xa
 //	    }
 //	};
 func ContentToHTML(r HasContent, ctx context.Context, xatena XatenaContext, options CallerOptions) string {
-	html := ""
+	return renderString(func(w io.Writer) error {
+		return WriteContent(r, ctx, w, xatena, options)
+	})
+}
+
+// WriteContent: r の子ノードを順に w へ書き出す。連続する TextNode は段落にまとめる
+func WriteContent(r HasContent, ctx context.Context, w io.Writer, xatena XatenaContext, options CallerOptions) error {
 	var textBuf []string
-	flushParagraph := func() {
+	var textNodes []*TextNode
+	report := DiagnosticReporter(ctx) != nil
+	paraCtx := ctx
+	flushParagraph := func() error {
 		hasText := strings.Join(textBuf, "") != ""
-		if hasText {
-			if xatena.PreferHatenaCompatible() {
-				html += ToHTMLParagraphHatenaCompatible(ctx, strings.Join(textBuf, "\n"), xatena, options)
-			} else {
-				html += ToHTMLParagraph(ctx, strings.Join(textBuf, "\n"), xatena, options)
-			}
+		text := strings.Join(textBuf, "\n")
+		lines := textNodes
+		textBuf, textNodes = nil, nil
+		if !hasText {
+			return nil
+		}
+		if xatena.PreferHatenaCompatible() {
+			return writeParagraphHatenaCompatible(paraCtx, w, text, lines, xatena, options)
 		}
-		textBuf = nil
+		return writeParagraph(paraCtx, w, text, lines, xatena, options)
 	}
 	for _, n := range r.GetContent() {
 		if t, ok := n.(*TextNode); ok {
+			if report && len(textBuf) == 0 && t.StartLine > 0 {
+				paraCtx = WithDiagnosticLine(ctx, t.StartLine)
+			}
 			textBuf = append(textBuf, t.Text)
+			textNodes = append(textNodes, t)
 		} else {
-			flushParagraph()
-			html += n.ToHTML(ctx, xatena, options)
+			if err := flushParagraph(); err != nil {
+				return err
+			}
+			// ctx が終了していれば残りのノードは書き出さない
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			nodeCtx := ctx
+			if p, ok := n.(Positioned); ok && report && p.GetPosition().StartLine > 0 {
+				nodeCtx = WithDiagnosticLine(ctx, p.GetPosition().StartLine)
+			}
+			if err := WriteNode(nodeCtx, w, n, xatena, options); err != nil {
+				return err
+			}
 		}
 	}
-	flushParagraph()
-	return html
+	return flushParagraph()
+}
+
+// errWriter は最初の書き込みエラーを保持し、以降の書き込みを捨てる
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (e *errWriter) Write(p []byte) (int, error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+	n, err := e.w.Write(p)
+	e.err = err
+	return n, err
+}
+
+func (e *errWriter) WriteString(s string) {
+	if e.err == nil {
+		_, e.err = io.WriteString(e.w, s)
+	}
 }
 
 // reSplitWithSep splits s by the regexp re, including the separator (match) in the result slice.
@@ -154,11 +384,35 @@ func reSplitWithSep(re *regexp.Regexp, s string) []string {
 	return result
 }
 
+// lastIndexOfStack: stack を上から探して match する要素の位置を返す。ルート (0番目) は対象外
+func lastIndexOfStack(stack []HasContent, match func(HasContent) bool) int {
+	for i := len(stack) - 1; i > 0; i-- {
+		if match(stack[i]) {
+			return i
+		}
+	}
+	return -1
+}
+
 type BlockParser interface {
 	CanHandle(line string) bool // パフォーマンスのためパース前に簡易的にチェックする
 	Parse(scanner *LineScanner, parent HasContent, stack *[]HasContent) bool
 }
 
+// BlockTriggers はパーサが反応しうる行の特徴。いずれかに当てはまる行でだけ CanHandle と Parse を呼ぶ
+type BlockTriggers struct {
+	Prefixes   []string // 行頭の文字列
+	Suffixes   []string // 行末の文字列
+	Substrings []string // 行のどこかに含まれる文字列
+}
+
+// TriggeredBlockParser は反応する行の特徴を宣言する BlockParser
+// 宣言していないパーサはすべての行で CanHandle を呼ぶ
+type TriggeredBlockParser interface {
+	BlockParser
+	Triggers() BlockTriggers
+}
+
 func (r *RootNode) AddChild(n Node) {
 	r.Content = append(r.Content, n)
 }
@@ -171,8 +425,26 @@ func (r *RootNode) ToHTML(ctx context.Context, xatena XatenaContext, options Cal
 	return ContentToHTML(r, ctx, xatena, options)
 }
 
+func (r *RootNode) WriteHTML(ctx context.Context, w io.Writer, xatena XatenaContext, options CallerOptions) error {
+	return WriteContent(r, ctx, w, xatena, options)
+}
+
 type TextNode struct {
-	Text string
+	Position
+	Text   string
+	Offset int // 行頭の、入力全体 (改行を正規化する前の入力) でのバイトオフセット
+	// Inlines は段落 (連続する TextNode) の先頭の行にだけ、段落全体 (行を改行でつないだテキスト) を
+	// パースしたインライン要素を持つ。2行目以降とパースしていない行は nil
+	Inlines []InlineNode
+}
+
+// paragraphInlines: 段落 (連続する TextNode) のパース済みのインライン要素
+// 段落全体の要素は先頭の行が持つ。パースしていなければ nil を返す
+func paragraphInlines(lines []*TextNode) []InlineNode {
+	if len(lines) == 0 {
+		return nil
+	}
+	return lines[0].Inlines
 }
 
 func (t *TextNode) ToHTML(ctx context.Context, xatena XatenaContext, options CallerOptions) string {
@@ -0,0 +1,70 @@
+package syntax
+
+import (
+	"fmt"
+	"html"
+)
+
+// Position はノードが元の入力のどの行から来たかを表す (1 始まり、EndLine の行を含む)
+// StartLine が 0 のときは位置不明 (プログラムから組み立てたノードなど)
+type Position struct {
+	StartLine int
+	EndLine   int
+}
+
+func (p Position) GetPosition() Position {
+	return p
+}
+
+func (p *Position) SetPosition(pos Position) {
+	*p = pos
+}
+
+// Positioned は位置情報を持つノード
+type Positioned interface {
+	GetPosition() Position
+	SetPosition(pos Position)
+}
+
+// FinalizePositions: 閉じ記号を持たないコンテナ (セクション・続きを読むなど) や閉じ忘れたブロックの
+// EndLine を子ノードの最終行まで広げる
+func FinalizePositions(n Node) {
+	c, ok := n.(HasContent)
+	if !ok {
+		return
+	}
+	end := 0
+	for _, child := range c.GetContent() {
+		FinalizePositions(child)
+		if p, ok := child.(Positioned); ok && p.GetPosition().EndLine > end {
+			end = p.GetPosition().EndLine
+		}
+	}
+	if p, ok := n.(Positioned); ok {
+		pos := p.GetPosition()
+		if pos.StartLine > 0 && end > pos.EndLine {
+			pos.EndLine = end
+			p.SetPosition(pos)
+		}
+	}
+}
+
+// SourcePos: data-sourcepos 属性の値 ("開始行-終了行")。出力しない場合は空文字
+func SourcePos(xatena XatenaContext, p Position) string {
+	if !xatena.PreferSourcePos() || p.StartLine == 0 {
+		return ""
+	}
+	end := p.EndLine
+	if end < p.StartLine {
+		end = p.StartLine
+	}
+	return fmt.Sprintf("%d-%d", p.StartLine, end)
+}
+
+// sourcePosAttr: テンプレートを通さずに組み立てるタグ用の data-sourcepos 属性
+func sourcePosAttr(xatena XatenaContext, p Position) string {
+	if v := SourcePos(xatena, p); v != "" {
+		return ` data-sourcepos="` + html.EscapeString(v) + `"`
+	}
+	return ""
+}
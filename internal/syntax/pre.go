@@ -3,26 +3,30 @@ package syntax
 import (
 	"context"
 	htmltpl "html/template"
+	"io"
 	"regexp"
 	"strings"
 )
 
 var PreTemplate = htmltpl.Must(htmltpl.New("pre").Parse(`
-<pre>{{.Content}}</pre>
+<pre{{with .SourcePos}} data-sourcepos="{{.}}"{{end}}>{{.Content}}</pre>
 `))
 
 // PreNode represents a <pre> block (stopp block with <pre> wrapper)
 type PreNode struct {
+	Position
 	Content []Node // StopPNodeのように子ノードを持つ
 }
 
 func (p *PreNode) ToHTML(ctx context.Context, xatena XatenaContext, options CallerOptions) string {
-	content := ContentToHTML(p, ctx, xatena, CallerOptions{
-		stopp: true,
+	return renderString(func(w io.Writer) error {
+		return p.WriteHTML(ctx, w, xatena, options)
 	})
-	params := map[string]interface{}{"Content": htmltpl.HTML(content)}
-	html := xatena.ExecuteTemplate("pre", params)
-	return html
+}
+
+func (p *PreNode) WriteHTML(ctx context.Context, w io.Writer, xatena XatenaContext, options CallerOptions) error {
+	params := map[string]interface{}{"SourcePos": SourcePos(xatena, p.Position)}
+	return writeContentTemplate(p, ctx, w, xatena, CallerOptions{stopp: true}, "pre", params)
 }
 
 func (p *PreNode) AddChild(n Node) {
@@ -42,20 +46,37 @@ func (p *PreParser) CanHandle(line string) bool {
 	return strings.HasPrefix(line, ">|") || strings.HasSuffix(line, "|<")
 }
 
+func (p *PreParser) Triggers() BlockTriggers {
+	return BlockTriggers{Prefixes: []string{">|"}, Suffixes: []string{"|<"}}
+}
+
 func (p *PreParser) Parse(scanner *LineScanner, parent HasContent, stack *[]HasContent) bool {
 	if scanner.Scan(rePreStart) {
-		node := &PreNode{}
+		node := &PreNode{Position: Position{StartLine: scanner.Pos(), EndLine: scanner.Pos()}}
 		parent.AddChild(node)
 		*stack = append(*stack, node)
 		return true
 	}
 	if scanner.Scan(rePreEnd) {
-		m := scanner.Matched()
-		parent.AddChild(&TextNode{Text: m[1]})
-		if len(*stack) == 0 {
+		idx := lastIndexOfStack(*stack, func(n HasContent) bool {
+			_, ok := n.(*PreNode)
+			return ok
+		})
+		if idx < 0 {
+			// 対応する >| がない行はただのテキストとして扱う
+			if strings.HasPrefix(scanner.Matched()[0], "||<") {
+				scanner.Report(SeverityWarning, "stray ||< without matching >|lang|")
+			} else {
+				scanner.Report(SeverityWarning, "stray |< without matching >|")
+			}
+			scanner.Reset(scanner.Pos() - 1)
 			return false
 		}
-		*stack = (*stack)[:len(*stack)-1]
+		m := scanner.Matched()
+		line := scanner.Pos()
+		parent.AddChild(&TextNode{Text: m[1], Position: Position{StartLine: line, EndLine: line}, Offset: scanner.LineOffset(line)})
+		(*stack)[idx].(*PreNode).EndLine = line
+		*stack = (*stack)[:idx]
 		return true
 	}
 	return false
@@ -3,19 +3,20 @@ package syntax
 import (
 	"context"
 	htmltpl "html/template"
+	"io"
 	"regexp"
 	"strings"
 )
 
 var SectionTemplate = htmltpl.Must(htmltpl.New("section").Parse(`
-<div class="section">
+<div class="section"{{with .SourcePos}} data-sourcepos="{{.}}"{{end}}>
 <h{{.Level}}>{{.Title}}</h{{.Level}}>
 {{.Content}}
 </div>
 `))
 
 var HatenaCompatibleSectionTemplate = htmltpl.Must(htmltpl.New("section").Parse(`
-<h{{.Level}}>{{.Title}}</h{{.Level}}>
+<h{{.Level}}{{with .SourcePos}} data-sourcepos="{{.}}"{{end}}>{{.Title}}</h{{.Level}}>
 {{.Content}}
 `))
 
@@ -25,11 +26,17 @@ func (p *SectionParser) CanHandle(line string) bool {
 	return strings.HasPrefix(line, "*")
 }
 
+func (p *SectionParser) Triggers() BlockTriggers {
+	return BlockTriggers{Prefixes: []string{"*"}}
+}
+
 // SectionNode represents a section (heading + content)
 type SectionNode struct {
-	Level   int    // 1=*, 2=**, ...
-	Title   string // heading text
-	Content []Node // nested block nodes
+	Position
+	Level        int          // 1=*, 2=**, ...
+	Title        string       // heading text
+	TitleInlines []InlineNode // Title をパースしたインライン要素 (パースしていなければ nil)
+	Content      []Node       // nested block nodes
 }
 
 func (s *SectionNode) AddChild(n Node) {
@@ -65,6 +72,7 @@ func (p *SectionParser) Parse(scanner *LineScanner, parent HasContent, stack *[]
 		level = 1
 	}
 	sec := &SectionNode{Level: level, Title: title}
+	sec.SetPosition(Position{StartLine: scanner.Pos(), EndLine: scanner.Pos()})
 	for len(*stack) > 0 {
 		if s, ok := (*stack)[len(*stack)-1].(*SectionNode); ok && s.Level >= level {
 			*stack = (*stack)[:len(*stack)-1]
@@ -81,14 +89,17 @@ func (p *SectionParser) Parse(scanner *LineScanner, parent HasContent, stack *[]
 }
 
 func (s *SectionNode) ToHTML(ctx context.Context, xatena XatenaContext, options CallerOptions) string {
-	inline := xatena.GetInline()
-	title := inline.Format(ctx, s.Title)
-	content := ContentToHTML(s, ctx, xatena, options)
+	return renderString(func(w io.Writer) error {
+		return s.WriteHTML(ctx, w, xatena, options)
+	})
+}
+
+func (s *SectionNode) WriteHTML(ctx context.Context, w io.Writer, xatena XatenaContext, options CallerOptions) error {
+	title := formatInline(ctx, xatena, s.Title, s.TitleInlines)
 	params := map[string]interface{}{
-		"Level":   s.Level + 2,
-		"Title":   htmltpl.HTML(title),
-		"Content": htmltpl.HTML(content),
+		"Level":     s.Level + 2,
+		"Title":     htmltpl.HTML(title),
+		"SourcePos": SourcePos(xatena, s.Position),
 	}
-	html := xatena.ExecuteTemplate("section", params)
-	return html
+	return writeContentTemplate(s, ctx, w, xatena, options, "section", params)
 }
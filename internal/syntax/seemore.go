@@ -3,26 +3,32 @@ package syntax
 import (
 	"context"
 	htmltpl "html/template"
+	"io"
 	"regexp"
 	"strings"
 )
 
 var SeeMoreTemplate = htmltpl.Must(htmltpl.New("seemore").Parse(`
-<div class="seemore">{{.Content}}</div>
+<div class="seemore"{{with .SourcePos}} data-sourcepos="{{.}}"{{end}}>{{.Content}}</div>
 `))
 
 // SeeMoreNode represents a <div class="seemore"> block
 // (==== or ===== line)
 type SeeMoreNode struct {
+	Position
 	IsSuper bool
 	Content []Node
 }
 
 func (s *SeeMoreNode) ToHTML(ctx context.Context, xatena XatenaContext, options CallerOptions) string {
-	content := ContentToHTML(s, ctx, xatena, options)
-	params := map[string]interface{}{"Content": htmltpl.HTML(content)}
-	html := xatena.ExecuteTemplate("seemore", params)
-	return html
+	return renderString(func(w io.Writer) error {
+		return s.WriteHTML(ctx, w, xatena, options)
+	})
+}
+
+func (s *SeeMoreNode) WriteHTML(ctx context.Context, w io.Writer, xatena XatenaContext, options CallerOptions) error {
+	params := map[string]interface{}{"SourcePos": SourcePos(xatena, s.Position)}
+	return writeContentTemplate(s, ctx, w, xatena, options, "seemore", params)
 }
 
 func (s *SeeMoreNode) AddChild(n Node) {
@@ -41,10 +47,15 @@ func (p *SeeMoreParser) CanHandle(line string) bool {
 	return strings.HasPrefix(line, "====")
 }
 
+func (p *SeeMoreParser) Triggers() BlockTriggers {
+	return BlockTriggers{Prefixes: []string{"===="}}
+}
+
 func (p *SeeMoreParser) Parse(scanner *LineScanner, parent HasContent, stack *[]HasContent) bool {
 	if scanner.Scan(reSeeMore) {
 		isSuper := scanner.Matched()[1] != ""
 		node := &SeeMoreNode{IsSuper: isSuper}
+		node.SetPosition(Position{StartLine: scanner.Pos(), EndLine: scanner.Pos()})
 		parent.AddChild(node)
 		*stack = append(*stack, node)
 		return true
@@ -3,6 +3,7 @@ package syntax
 import (
 	"context"
 	htmltpl "html/template"
+	"io"
 	"regexp"
 	"strings"
 )
@@ -13,16 +14,18 @@ var StopPTemplate = htmltpl.Must(htmltpl.New("stopp").Parse(`
 
 // StopPNode represents a block that disables auto <p>/<br> insertion.
 type StopPNode struct {
+	Position
 	Content []Node
 }
 
 func (s *StopPNode) ToHTML(ctx context.Context, xatena XatenaContext, options CallerOptions) string {
-	content := ContentToHTML(s, ctx, xatena, CallerOptions{
-		stopp: true,
+	return renderString(func(w io.Writer) error {
+		return s.WriteHTML(ctx, w, xatena, options)
 	})
-	params := map[string]interface{}{"Content": htmltpl.HTML(content)}
-	html := xatena.ExecuteTemplate("stopp", params)
-	return html
+}
+
+func (s *StopPNode) WriteHTML(ctx context.Context, w io.Writer, xatena XatenaContext, options CallerOptions) error {
+	return writeContentTemplate(s, ctx, w, xatena, CallerOptions{stopp: true}, "stopp", map[string]interface{}{})
 }
 func (s *StopPNode) AddChild(n Node) {
 	s.Content = append(s.Content, n)
@@ -41,10 +44,15 @@ func (p *StopPParser) CanHandle(line string) bool {
 	return strings.HasPrefix(line, ">") || strings.HasSuffix(line, "<")
 }
 
+func (p *StopPParser) Triggers() BlockTriggers {
+	return BlockTriggers{Prefixes: []string{">"}, Suffixes: []string{"<"}}
+}
+
 func (p *StopPParser) Parse(scanner *LineScanner, parent HasContent, stack *[]HasContent) bool {
 	if scanner.Scan(reStopPStart) {
-		node := &StopPNode{}
-		node.AddChild(&TextNode{Text: scanner.Matched()[1]}) // Add the opening tag
+		line := scanner.Pos()
+		node := &StopPNode{Position: Position{StartLine: line, EndLine: line}}
+		node.AddChild(&TextNode{Text: scanner.Matched()[1], Position: Position{StartLine: line, EndLine: line}, Offset: scanner.LineOffset(line)}) // Add the opening tag
 		parent.AddChild(node)
 		if scanner.Matched()[2] == "" {
 			*stack = append(*stack, node)
@@ -53,9 +61,24 @@ func (p *StopPParser) Parse(scanner *LineScanner, parent HasContent, stack *[]Ha
 	}
 
 	if scanner.Scan(reStopPEnd) {
+		if lastIndexOfStack(*stack, func(n HasContent) bool {
+			_, ok := n.(*StopPNode)
+			return ok
+		}) < 0 {
+			scanner.Report(SeverityWarning, "stray >< without matching ><tag>")
+			if len(*stack) <= 1 {
+				// 閉じるものがない行はただのテキストとして扱う
+				scanner.Reset(scanner.Pos() - 1)
+				return false
+			}
+		}
+		line := scanner.Pos()
 		lastParent := (*stack)[len(*stack)-1]
+		if n, ok := lastParent.(*StopPNode); ok {
+			n.EndLine = line
+		}
 		*stack = (*stack)[:len(*stack)-1]
-		lastParent.AddChild(&TextNode{Text: scanner.Matched()[1]})
+		lastParent.AddChild(&TextNode{Text: scanner.Matched()[1], Position: Position{StartLine: line, EndLine: line}, Offset: scanner.LineOffset(line)})
 		return true
 	}
 	return false
@@ -4,32 +4,148 @@ import (
 	"context"
 	"html"
 	htmltpl "html/template"
+	"io"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
+// ファイル名・行番号・強調する行を指定したブロック (>|go:main.go{1,4-6}|) は Lines が空でなく、
+// <figure> の中で1行ずつ <span class="line" data-line="N"> に入れる
 var SuperPreTemplate = htmltpl.Must(htmltpl.New("superpre").Parse(`
-<pre class="{{.Class}}">{{.RawText}}</pre>
+{{if .Lines -}}
+<figure class="code-block"{{with .SourcePos}} data-sourcepos="{{.}}"{{end}}>{{with .Filename}}<figcaption>{{.}}</figcaption>{{end}}<pre class="{{.Class}}">{{range $i, $l := .Lines}}{{if $i}}
+{{end}}<span class="line{{if $l.Emphasized}} emphasized{{end}}" data-line="{{$l.Number}}">{{$l.HTML}}</span>{{end}}</pre></figure>
+{{- else -}}
+<pre class="{{.Class}}"{{with .SourcePos}} data-sourcepos="{{.}}"{{end}}>{{.RawText}}</pre>
+{{- end}}
 `))
 
 // SuperPreNode represents a <pre> block with HTML-escaped content (super pre)
 type SuperPreNode struct {
-	Lang    string // e.g. "perl", "python" (optional)
-	RawText string // raw preformatted text (will be HTML-escaped)
+	Position
+	Lang      string      // e.g. "perl", "python" (optional)
+	Filename  string      // >|go:main.go| のファイル名 (optional)
+	FirstLine int         // >|go@10| の1行目の行番号。0 なら指定なし (1 から数える)
+	Emphasis  []LineRange // >|go{1,4-6}| の強調する行。ブロックの先頭を 1 とする
+	RawText   string      // raw preformatted text (will be HTML-escaped)
+}
+
+// LineRange は From 行目から To 行目まで (両端を含む)
+type LineRange struct {
+	From, To int
+}
+
+func (r LineRange) Contains(line int) bool {
+	return r.From <= line && line <= r.To
+}
+
+// SuperPreLine は行ごとに出力する super pre の1行
+type SuperPreLine struct {
+	Number     int // data-line に出す行番号 (FirstLine から数える)
+	Emphasized bool
+	HTML       htmltpl.HTML
+}
+
+// hasAttributes: ファイル名・行番号・強調する行のどれかが指定されていれば true
+func (s *SuperPreNode) hasAttributes() bool {
+	return s.Filename != "" || s.FirstLine > 0 || len(s.Emphasis) > 0
+}
+
+// lines: text (エスケープ・色分け済みの HTML) を行に分け、行番号と強調の有無を付ける
+func (s *SuperPreNode) lines(text string) []SuperPreLine {
+	first := s.FirstLine
+	if first <= 0 {
+		first = 1
+	}
+	var lines []SuperPreLine
+	for i, line := range splitHTMLLines(text) {
+		l := SuperPreLine{Number: first + i, HTML: htmltpl.HTML(line)}
+		for _, r := range s.Emphasis {
+			if r.Contains(i + 1) {
+				l.Emphasized = true
+				break
+			}
+		}
+		lines = append(lines, l)
+	}
+	return lines
+}
+
+var reHTMLTag = regexp.MustCompile(`<(/?)([A-Za-z][A-Za-z0-9-]*)[^>]*>`)
+
+// splitHTMLLines: HTML を改行で分ける。行をまたいで開いているタグは行末で閉じ、次の行の先頭で開き直す
+func splitHTMLLines(s string) []string {
+	var lines []string
+	var open, names []string // 開いている開始タグとその名前
+	for _, line := range strings.Split(s, "\n") {
+		reopen := strings.Join(open, "")
+		for _, m := range reHTMLTag.FindAllStringSubmatch(line, -1) {
+			switch {
+			case m[1] == "/":
+				if len(open) > 0 {
+					open, names = open[:len(open)-1], names[:len(names)-1]
+				}
+			case !strings.HasSuffix(m[0], "/>"):
+				open, names = append(open, m[0]), append(names, m[2])
+			}
+		}
+		var closing strings.Builder
+		for i := len(names) - 1; i >= 0; i-- {
+			closing.WriteString("</" + names[i] + ">")
+		}
+		lines = append(lines, reopen+line+closing.String())
+	}
+	return lines
 }
 
 func (s *SuperPreNode) ToHTML(ctx context.Context, xatena XatenaContext, options CallerOptions) string {
+	return renderString(func(w io.Writer) error {
+		return s.WriteHTML(ctx, w, xatena, options)
+	})
+}
+
+// CodeHighlighter は super pre のコードを色分けできる XatenaContext が実装する
+// ok が false なら対応していない言語として、エスケープだけして出力する
+type CodeHighlighter interface {
+	HighlightCode(lang, code string) (html string, ok bool)
+}
+
+// SuperPreRenderer は言語ごとに super pre の出力を差し替えられる XatenaContext が実装する
+// ok が false なら通常どおり <pre class="code"> として出力する
+type SuperPreRenderer interface {
+	RenderSuperPre(ctx context.Context, s *SuperPreNode) (html string, ok bool)
+}
+
+func (s *SuperPreNode) WriteHTML(ctx context.Context, w io.Writer, xatena XatenaContext, options CallerOptions) error {
+	if r, ok := xatena.(SuperPreRenderer); ok && s.Lang != "" {
+		if out, ok := r.RenderSuperPre(ctx, s); ok {
+			_, err := io.WriteString(w, out)
+			return err
+		}
+	}
 	className := "code"
 	langClass := ""
 	if s.Lang != "" {
 		langClass = " lang-" + s.Lang
 	}
+	text := htmltpl.HTML(html.EscapeString(s.RawText))
+	if h, ok := xatena.(CodeHighlighter); ok && s.Lang != "" {
+		if highlighted, ok := h.HighlightCode(s.Lang, s.RawText); ok {
+			text = htmltpl.HTML(highlighted)
+		}
+	}
 	params := map[string]interface{}{
-		"Class":   className + langClass,
-		"RawText": htmltpl.HTML(html.EscapeString(s.RawText)),
+		"Class":     className + langClass,
+		"RawText":   text,
+		"SourcePos": SourcePos(xatena, s.Position),
+		"Filename":  s.Filename,
+		"Lines":     []SuperPreLine(nil),
+	}
+	if s.hasAttributes() {
+		params["Lines"] = s.lines(string(text))
 	}
-	html := xatena.ExecuteTemplate("superpre", params)
-	return html
+	return xatena.WriteTemplate(w, "superpre", params)
 }
 
 func (s *SuperPreNode) AddChild(n Node) {
@@ -43,21 +159,72 @@ func (s *SuperPreNode) GetContent() []Node {
 type SuperPreParser struct{}
 
 var reSuperPreStart = regexp.MustCompile(`^>\|([^|]*)\|$`)
+
+// >|lang:filename@first{ranges}| (lang 以外は省略できる)
+var reSuperPreHeader = regexp.MustCompile(`^([^:@{}]*)(?::([^@{}]*))?(?:@([1-9]\d*))?(?:\{([^{}]*)\})?$`)
+var reLineRange = regexp.MustCompile(`^(\d+)(?:-(\d+))?$`)
+
+// parseSuperPreHeader: >|...| の中身を言語・ファイル名・行番号・強調する行に分ける
+// 拡張した形として読めなければ、これまでどおり全体を言語とし ok は false
+func parseSuperPreHeader(header string, node *SuperPreNode) (ok bool) {
+	node.Lang = header
+	m := reSuperPreHeader.FindStringSubmatch(header)
+	if m == nil {
+		return false
+	}
+	var emphasis []LineRange
+	if m[4] != "" {
+		for _, item := range strings.Split(m[4], ",") {
+			r := reLineRange.FindStringSubmatch(strings.TrimSpace(item))
+			if r == nil {
+				return false
+			}
+			from, _ := strconv.Atoi(r[1])
+			to := from
+			if r[2] != "" {
+				to, _ = strconv.Atoi(r[2])
+			}
+			if to < from {
+				from, to = to, from
+			}
+			emphasis = append(emphasis, LineRange{From: from, To: to})
+		}
+	}
+	node.Lang = m[1]
+	node.Filename = m[2]
+	if m[3] != "" {
+		node.FirstLine, _ = strconv.Atoi(m[3])
+	}
+	node.Emphasis = emphasis
+	return true
+}
+
 var reSuperPreEnd = regexp.MustCompile(`^\|\|<$`)
 
 func (p *SuperPreParser) CanHandle(line string) bool {
 	return strings.HasPrefix(line, ">|") || strings.HasPrefix(line, "||<")
 }
 
+func (p *SuperPreParser) Triggers() BlockTriggers {
+	return BlockTriggers{Prefixes: []string{">|", "||<"}}
+}
+
 func (p *SuperPreParser) Parse(scanner *LineScanner, parent HasContent, stack *[]HasContent) bool {
 	if scanner.Scan(reSuperPreStart) {
-		lang := scanner.Matched()[1]
+		start := scanner.Pos()
+		header := scanner.Matched()[1]
 		lines := scanner.ScanUntil(reSuperPreEnd)
-		lines = lines[:len(lines)-1] // remove last matched
-		node := &SuperPreNode{
-			Lang:    lang,
-			RawText: strings.Join(lines, "\n"),
+		if len(lines) == 0 || !reSuperPreEnd.MatchString(lines[len(lines)-1]) {
+			scanner.ReportAt(start, SeverityError, "unclosed super pre block: missing ||<")
+		}
+		if len(lines) > 0 {
+			lines = lines[:len(lines)-1] // remove last matched (閉じていない場合も Text::Xatena 同様最終行を捨てる)
+		}
+		node := &SuperPreNode{RawText: strings.Join(lines, "\n")}
+		if !parseSuperPreHeader(header, node) {
+			scanner.ReportAt(start, SeverityWarning, "invalid super pre header: "+header)
 		}
+		node.SetPosition(Position{StartLine: start, EndLine: scanner.Pos()})
 		parent.AddChild(node)
 		return true
 	}
@@ -3,12 +3,13 @@ package syntax
 import (
 	"context"
 	htmltpl "html/template"
+	"io"
 	"regexp"
 	"strings"
 )
 
 var TableTemplate = htmltpl.Must(htmltpl.New("table").Parse(`
-<table>
+<table{{with .SourcePos}} data-sourcepos="{{.}}"{{end}}>
 {{- range .Rows}}
   <tr>
   {{- range .}}
@@ -20,37 +21,44 @@ var TableTemplate = htmltpl.Must(htmltpl.New("table").Parse(`
 
 // TableNode represents a table block
 type TableNode struct {
+	Position
 	Rows [][]TableCellNode
 }
 
 type TableCellNode struct {
 	IsHeader bool
 	Content  string
+	Inlines  []InlineNode // Content をパースしたインライン要素 (パースしていなければ nil)
 }
 
 func (t *TableNode) ToHTML(ctx context.Context, xatena XatenaContext, options CallerOptions) string {
+	return renderString(func(w io.Writer) error {
+		return t.WriteHTML(ctx, w, xatena, options)
+	})
+}
+
+func (t *TableNode) WriteHTML(ctx context.Context, w io.Writer, xatena XatenaContext, options CallerOptions) error {
 	type cell struct {
 		IsHeader bool
 		Content  htmltpl.HTML
 	}
 	type row []cell
 	var rows []row
-	inline := xatena.GetInline()
 	for _, r := range t.Rows {
 		var rowCells row
 		for _, c := range r {
 			rowCells = append(rowCells, cell{
 				IsHeader: c.IsHeader,
-				Content:  htmltpl.HTML(inline.Format(ctx, c.Content)),
+				Content:  htmltpl.HTML(formatInline(ctx, xatena, c.Content, c.Inlines)),
 			})
 		}
 		rows = append(rows, rowCells)
 	}
 	params := map[string]interface{}{
-		"Rows": rows,
+		"Rows":      rows,
+		"SourcePos": SourcePos(xatena, t.Position),
 	}
-	html := xatena.ExecuteTemplate("table", params)
-	return html
+	return xatena.WriteTemplate(w, "table", params)
 }
 
 func (t *TableNode) AddChild(n Node) {
@@ -69,10 +77,15 @@ func (p *TableParser) CanHandle(line string) bool {
 	return strings.HasPrefix(line, "|")
 }
 
+func (p *TableParser) Triggers() BlockTriggers {
+	return BlockTriggers{Prefixes: []string{"|"}}
+}
+
 func (p *TableParser) Parse(scanner *LineScanner, parent HasContent, stack *[]HasContent) bool {
 	if !reTableRow.MatchString(scanner.Peek()) {
 		return false
 	}
+	start := scanner.Pos() + 1
 	var rows [][]TableCellNode
 	for !scanner.EOF() && reTableRow.MatchString(scanner.Peek()) {
 		rows = append(rows, parseTableRow(scanner.Next()))
@@ -82,6 +95,7 @@ func (p *TableParser) Parse(scanner *LineScanner, parent HasContent, stack *[]Ha
 	}
 
 	node := &TableNode{Rows: rows}
+	node.SetPosition(Position{StartLine: start, EndLine: scanner.Pos()})
 	parent.AddChild(node)
 	return true
 }
@@ -0,0 +1,264 @@
+// Package highlight は super pre のコードを字句解析し、トークンの種類ごとに class を付けた <span> で囲む
+// class ははてなダイアリーのシンタックスハイライト (vim の syntax グループ) と同じ名前を使う
+package highlight
+
+import (
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// トークンの種類 (<span> の class)
+const (
+	Comment    = "synComment"
+	Constant   = "synConstant"   // 文字列・数値・true などの定数
+	Identifier = "synIdentifier" // 変数・組み込み関数、diff の追加行
+	Statement  = "synStatement"  // キーワード、diff の @@ 行
+	PreProc    = "synPreProc"    // import・use・デコレータなど
+	Type       = "synType"       // 型名、diff のファイル名の行
+	Special    = "synSpecial"    // diff の削除行
+)
+
+// HTML: code を lang の字句解析器で色分けした HTML にする
+// lang に対応する字句解析器がなければ ok は false (code は何もしない)
+// 複数行にまたがるトークンは行ごとに <span> を閉じて開き直すので、行単位で分割しても入れ子が崩れない
+func HTML(lang, code string) (string, bool) {
+	lex, ok := lexers[aliases[strings.ToLower(lang)]]
+	if !ok {
+		return "", false
+	}
+	var b strings.Builder
+	lex(code, func(class, text string) {
+		if class == "" {
+			b.WriteString(html.EscapeString(text))
+			return
+		}
+		for i, line := range strings.Split(text, "\n") {
+			if i > 0 {
+				b.WriteByte('\n')
+			}
+			if line != "" {
+				b.WriteString(`<span class="` + class + `">` + html.EscapeString(line) + `</span>`)
+			}
+		}
+	})
+	return b.String(), true
+}
+
+// Supported: lang (別名を含む) に対応する字句解析器があれば true
+func Supported(lang string) bool {
+	_, ok := aliases[strings.ToLower(lang)]
+	return ok
+}
+
+// Languages: 対応している言語の名前 (別名を含む) を名前順に返す
+func Languages() []string {
+	names := make([]string, 0, len(aliases))
+	for name := range aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lexer は code をトークンに分けて順に emit する。class が空のトークンは色を付けない
+type lexer func(code string, emit func(class, text string))
+
+var lexers = map[string]lexer{
+	"go":         goLexer,
+	"perl":       perlLexer,
+	"javascript": javascriptLexer,
+	"python":     pythonLexer,
+	"shell":      shellLexer,
+	"diff":       diffLexer,
+	"json":       jsonLexer,
+}
+
+var aliases = map[string]string{
+	"go": "go", "golang": "go",
+	"perl": "perl", "pl": "perl", "pm": "perl",
+	"javascript": "javascript", "js": "javascript", "mjs": "javascript", "node": "javascript",
+	"python": "python", "py": "python", "python3": "python",
+	"sh": "shell", "shell": "shell", "bash": "shell", "zsh": "shell",
+	"diff": "diff", "patch": "diff",
+	"json": "json",
+}
+
+// rule は正規表現で1つのトークンを読む規則
+type rule struct {
+	re    *regexp.Regexp // 先頭 (\A) からのマッチだけを見る。グループがあれば最初のグループの部分だけをトークンにする
+	class string
+	// keywords を指定すると、マッチした語を keywords で引いた class にする (なければ色を付けない)
+	keywords map[string]string
+	// afterSpace なら行頭か空白の直後でだけ試す (シェルの # コメントなど)
+	afterSpace bool
+}
+
+func re(pattern string) *regexp.Regexp {
+	return regexp.MustCompile(`\A(?:` + pattern + `)`)
+}
+
+// words: 空白区切りの語をすべて class にする表を作る
+func words(class, list string, into map[string]string) map[string]string {
+	if into == nil {
+		into = map[string]string{}
+	}
+	for _, w := range strings.Fields(list) {
+		into[w] = class
+	}
+	return into
+}
+
+// ruleLexer: 規則を順に試し、最初にマッチしたものをトークンにする。どれもマッチしなければ1文字進める
+func ruleLexer(rules []rule) lexer {
+	return func(code string, emit func(class, text string)) {
+		plain := 0 // まだ出力していない色なしの部分の先頭
+		for pos := 0; pos < len(code); {
+			matched := false
+			for _, r := range rules {
+				if r.afterSpace && pos > 0 && !strings.ContainsRune(" \t\n", rune(code[pos-1])) {
+					continue
+				}
+				m := r.re.FindStringSubmatchIndex(code[pos:])
+				if m == nil || m[1] == 0 {
+					continue
+				}
+				if len(m) > 2 && m[2] == 0 && m[3] > 0 {
+					m[1] = m[3]
+				}
+				text := code[pos : pos+m[1]]
+				class := r.class
+				if r.keywords != nil {
+					class = r.keywords[text]
+				}
+				if class == "" {
+					// 色の付かない識別子も1語としてまとめて進める (語の途中でキーワードにマッチさせない)
+					pos += m[1]
+					matched = true
+					break
+				}
+				if plain < pos {
+					emit("", code[plain:pos])
+				}
+				emit(class, text)
+				pos += m[1]
+				plain = pos
+				matched = true
+				break
+			}
+			if !matched {
+				_, size := utf8.DecodeRuneInString(code[pos:])
+				pos += size
+			}
+		}
+		if plain < len(code) {
+			emit("", code[plain:])
+		}
+	}
+}
+
+var (
+	reNumber       = re(`0[xX][0-9a-fA-F_]+|[0-9][0-9_]*(?:\.[0-9_]+)?(?:[eE][+-]?[0-9]+)?`)
+	reIdent        = re(`[A-Za-z_][A-Za-z0-9_]*`)
+	reDoubleString = re(`"(?:[^"\\\n]|\\.)*"?`)
+	reSingleString = re(`'(?:[^'\\\n]|\\.)*'?`)
+	reLineComment  = re(`//[^\n]*`)
+	reBlockComment = re(`/\*(?s:.*?)(?:\*/|\z)`)
+	reHashComment  = re(`#[^\n]*`)
+)
+
+var goLexer = ruleLexer([]rule{
+	{re: reLineComment, class: Comment},
+	{re: reBlockComment, class: Comment},
+	{re: reDoubleString, class: Constant},
+	{re: re("`[^`]*`?"), class: Constant},
+	{re: re(`'(?:[^'\\\n]|\\[^\n]+?)'`), class: Constant},
+	{re: reNumber, class: Constant},
+	{re: reIdent, keywords: words(Statement, `break case chan const continue default defer else fallthrough for func go goto if
+		interface map range return select struct switch type var`,
+		words(PreProc, `package import`,
+			words(Type, `bool byte complex64 complex128 error float32 float64 int int8 int16 int32 int64 rune string
+				uint uint8 uint16 uint32 uint64 uintptr any comparable`,
+				words(Constant, `true false nil iota`,
+					words(Identifier, `append cap clear close complex copy delete imag len make max min new panic print println real recover`, nil)))))},
+})
+
+var perlLexer = ruleLexer([]rule{
+	{re: reHashComment, class: Comment},
+	{re: reDoubleString, class: Constant},
+	{re: reSingleString, class: Constant},
+	{re: re(`[$@%](?:\{\^?\w+\}|\^\w|[A-Za-z_][A-Za-z0-9_]*(?:::\w+)*|\d+|[_!@/\\,;.0&])|\$#\w+`), class: Identifier},
+	{re: reNumber, class: Constant},
+	{re: reIdent, keywords: words(Statement, `my our local sub if elsif else unless while until for foreach return last next redo
+		do eval and or not xor die wantarray given when default`,
+		words(PreProc, `use no package require BEGIN END __END__ __DATA__`, nil))},
+})
+
+var javascriptLexer = ruleLexer([]rule{
+	{re: reLineComment, class: Comment},
+	{re: reBlockComment, class: Comment},
+	{re: reDoubleString, class: Constant},
+	{re: reSingleString, class: Constant},
+	{re: re("`(?:[^`\\\\]|\\\\.)*`?"), class: Constant},
+	{re: reNumber, class: Constant},
+	{re: re(`[A-Za-z_$][A-Za-z0-9_$]*`), keywords: words(Statement, `var let const function return if else for while do break continue
+		switch case default new delete typeof instanceof in of class extends super this try catch finally throw
+		async await yield void static get set`,
+		words(PreProc, `import export from as`,
+			words(Constant, `true false null undefined NaN Infinity`, nil)))},
+})
+
+var pythonLexer = ruleLexer([]rule{
+	{re: reHashComment, class: Comment},
+	{re: re(`(?i:[rbuf]{0,2})(?:"""(?s:.*?)(?:"""|\z)|'''(?s:.*?)(?:'''|\z))`), class: Constant},
+	{re: re(`(?i:[rbuf]{0,2})(?:"(?:[^"\\\n]|\\.)*"?|'(?:[^'\\\n]|\\.)*'?)`), class: Constant},
+	{re: re(`@[A-Za-z_][\w.]*`), class: PreProc, afterSpace: true},
+	{re: reNumber, class: Constant},
+	{re: reIdent, keywords: words(Statement, `and as assert async await break class continue def del elif else except finally for
+		global if in is lambda nonlocal not or pass raise return try while with yield match case`,
+		words(PreProc, `import from`,
+			words(Constant, `True False None`,
+				words(Identifier, `self print len range open`, nil))))},
+})
+
+var shellLexer = ruleLexer([]rule{
+	{re: reHashComment, class: Comment, afterSpace: true},
+	{re: re(`"(?:[^"\\]|\\.)*"?`), class: Constant},
+	{re: re(`'[^']*'?`), class: Constant},
+	{re: re(`\$(?:\{[^}\n]*\}?|[A-Za-z_][A-Za-z0-9_]*|[0-9#?$!@*-])`), class: Identifier},
+	{re: re(`[A-Za-z_][A-Za-z0-9_.-]*`), keywords: words(Statement, `if then else elif fi for while until do done case esac in
+		function return exit local export readonly select break continue set unset shift`,
+		words(PreProc, `source alias`, nil))},
+	{re: reNumber, class: Constant},
+})
+
+var jsonLexer = ruleLexer([]rule{
+	{re: re(`("(?:[^"\\\n]|\\.)*")[ \t]*:`), class: Identifier}, // オブジェクトのキー
+	{re: reDoubleString, class: Constant},
+	{re: re(`-?[0-9]+(?:\.[0-9]+)?(?:[eE][+-]?[0-9]+)?`), class: Constant},
+	{re: reIdent, keywords: words(Constant, `true false null`, nil)},
+})
+
+// diffLexer: 行の先頭の記号で色を付ける
+func diffLexer(code string, emit func(class, text string)) {
+	for i, line := range strings.Split(code, "\n") {
+		if i > 0 {
+			emit("", "\n")
+		}
+		var class string
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"),
+			strings.HasPrefix(line, "diff "), strings.HasPrefix(line, "index "):
+			class = Type
+		case strings.HasPrefix(line, "@@"):
+			class = Statement
+		case strings.HasPrefix(line, "+"), strings.HasPrefix(line, ">"):
+			class = Identifier
+		case strings.HasPrefix(line, "-"), strings.HasPrefix(line, "<"):
+			class = Special
+		}
+		emit(class, line)
+	}
+}
@@ -0,0 +1,80 @@
+package highlight
+
+import (
+	"strings"
+	"testing"
+)
+
+func span(class, text string) string {
+	return `<span class="` + class + `">` + text + `</span>`
+}
+
+func TestHTML(t *testing.T) {
+	cases := []struct {
+		name string
+		lang string
+		code string
+		want string
+	}{
+		{"go", "go", "func main() { x := len(s) + 0x1F // done\n\treturn nil }",
+			span(Statement, "func") + " main() { x := " + span(Identifier, "len") + "(s) + " + span(Constant, "0x1F") + " " + span(Comment, "// done") +
+				"\n\t" + span(Statement, "return") + " " + span(Constant, "nil") + " }"},
+		{"go strings", "golang", "import \"fmt\"; var r rune = '\\n'; s := `a<b`",
+			span(PreProc, "import") + " " + span(Constant, "&#34;fmt&#34;") + "; " + span(Statement, "var") + " r " + span(Type, "rune") + " = " +
+				span(Constant, "&#39;\\n&#39;") + "; s := " + span(Constant, "`a&lt;b`")},
+		{"keyword inside identifier", "go", "format iffy", "format iffy"},
+		{"perl", "perl", "use strict;\nmy @a = ($x, 1) if $#a; # c",
+			span(PreProc, "use") + " strict;\n" + span(Statement, "my") + " " + span(Identifier, "@a") + " = (" + span(Identifier, "$x") + ", " +
+				span(Constant, "1") + ") " + span(Statement, "if") + " " + span(Identifier, "$#a") + "; " + span(Comment, "# c")},
+		{"javascript", "js", "const s = `t${x}` && null; /* c */",
+			span(Statement, "const") + " s = " + span(Constant, "`t${x}`") + " &amp;&amp; " + span(Constant, "null") + "; " + span(Comment, "/* c */")},
+		{"python", "py", "@dec\ndef f(self):\n    return r'x' # c",
+			span(PreProc, "@dec") + "\n" + span(Statement, "def") + " f(" + span(Identifier, "self") + "):\n    " + span(Statement, "return") + " " +
+				span(Constant, "r&#39;x&#39;") + " " + span(Comment, "# c")},
+		{"shell", "bash", "for f in *; do echo \"$f\" ${HOME} # c\ndone; a#b",
+			span(Statement, "for") + " f " + span(Statement, "in") + " *; " + span(Statement, "do") + " echo " + span(Constant, "&#34;$f&#34;") + " " +
+				span(Identifier, "${HOME}") + " " + span(Comment, "# c") + "\n" + span(Statement, "done") + "; a#b"},
+		{"diff", "patch", "--- a\n+++ b\n@@ -1 +1 @@\n-old\n+new\n ctx",
+			span(Type, "--- a") + "\n" + span(Type, "+++ b") + "\n" + span(Statement, "@@ -1 +1 @@") + "\n" + span(Special, "-old") + "\n" + span(Identifier, "+new") + "\n ctx"},
+		{"json", "JSON", `{"a": [1, -2.5e3, true], "b" : "s"}`,
+			"{" + span(Identifier, "&#34;a&#34;") + ": [" + span(Constant, "1") + ", " + span(Constant, "-2.5e3") + ", " + span(Constant, "true") + "], " +
+				span(Identifier, "&#34;b&#34;") + " : " + span(Constant, "&#34;s&#34;") + "}"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, ok := HTML(c.lang, c.code)
+			if !ok {
+				t.Fatalf("%s not supported", c.lang)
+			}
+			if got != c.want {
+				t.Errorf("got  %s\nwant %s", got, c.want)
+			}
+		})
+	}
+}
+
+// 複数行のトークンは行ごとに <span> を閉じる
+func TestHTMLMultilineTokens(t *testing.T) {
+	got, _ := HTML("go", "/* a\n\nb */")
+	if want := span(Comment, "/* a") + "\n\n" + span(Comment, "b */"); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	got, _ = HTML("python", "'''x\ny'''")
+	for _, line := range strings.Split(got, "\n") {
+		if strings.Count(line, "<span") != strings.Count(line, "</span>") {
+			t.Errorf("unbalanced line %q", line)
+		}
+	}
+}
+
+func TestHTMLUnknown(t *testing.T) {
+	if _, ok := HTML("cobol", "DISPLAY 'x'"); ok {
+		t.Errorf("cobol should not be supported")
+	}
+	if Supported("") || !Supported("Perl") {
+		t.Errorf("Supported")
+	}
+	if got := strings.Join(Languages(), ","); !strings.Contains(got, "go,golang") || !strings.Contains(got, "json") {
+		t.Errorf("Languages() = %s", got)
+	}
+}
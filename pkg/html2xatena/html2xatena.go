@@ -0,0 +1,529 @@
+// Package html2xatena は HTML をはてな記法に戻す
+// xatena の出力 (とそれに近い WYSIWYG エディタの HTML) を対象にし、
+// Convert した結果を xatena で HTML 化すると元の HTML と同じ構造になることを目標にする
+package html2xatena
+
+import (
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Convert: HTML 文字列をはてな記法に変換する
+func Convert(s string) (string, error) {
+	return ConvertReader(strings.NewReader(s))
+}
+
+// ConvertReader: r から読んだ HTML をはてな記法に変換する
+func ConvertReader(r io.Reader) (string, error) {
+	// html.Parse だと先頭のコメントが <html> の外に出てしまうので body の断片としてパースする
+	nodes, err := html.ParseFragment(r, &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body})
+	if err != nil {
+		return "", err
+	}
+	c := &converter{}
+	for _, n := range nodes {
+		c.block(n)
+	}
+	return strings.Join(c.out, ""), nil
+}
+
+type converter struct {
+	out      []string
+	lastPara bool // 直前のブロックが段落 (続く段落との間は空行で区切る必要がある)
+	brs      int  // 段落の間にある <br> の数 (空行の追加分)
+}
+
+// emit: ブロックを1つ出力する。ブロックの間は空行1つで区切る
+// (空行が2つ以上あると次の段落の先頭に <br /> が入ってしまう)
+func (c *converter) emit(block string, para bool) {
+	if len(c.out) > 0 {
+		sep := "\n\n"
+		if para && c.lastPara {
+			sep += strings.Repeat("\n", c.brs)
+		}
+		c.out = append(c.out, sep)
+	}
+	c.out = append(c.out, block)
+	c.lastPara = para
+	c.brs = 0
+}
+
+func (c *converter) blocks(parent *html.Node) {
+	for n := parent.FirstChild; n != nil; n = n.NextSibling {
+		c.block(n)
+	}
+}
+
+func (c *converter) block(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		if strings.TrimSpace(n.Data) != "" {
+			c.emit(escapeText(strings.TrimSpace(n.Data)), true)
+		}
+		return
+	case html.CommentNode:
+		c.emit("<!-- -->", false)
+		return
+	case html.ElementNode:
+	default:
+		return
+	}
+	switch n.DataAtom {
+	case atom.H3, atom.H4, atom.H5:
+		level := int(n.Data[1] - '2')
+		// 見出しが空や * で始まる場合もレベルを取り違えないよう空白を挟む
+		c.emit(strings.Repeat("*", level)+" "+strings.TrimSpace(c.inline(n, false)), false)
+	case atom.P:
+		c.emit(strings.Trim(c.inline(n, false), "\n"), true)
+	case atom.Br:
+		c.brs++
+	case atom.Ul, atom.Ol:
+		c.emit(strings.Join(c.list(n, ""), "\n"), false)
+	case atom.Table:
+		c.emit(c.table(n), false)
+	case atom.Dl:
+		c.emit(c.definitionList(n), false)
+	case atom.Pre:
+		c.pre(n)
+	case atom.Figure:
+		if hasClass(n, "code-block") {
+			c.codeFigure(n)
+		} else {
+			c.stopp(n)
+		}
+	case atom.Blockquote:
+		c.blockquote(n)
+	case atom.Div:
+		switch {
+		case hasClass(n, "section"):
+			c.blocks(n)
+		case hasClass(n, "seemore"):
+			c.emit("====", false)
+			c.blocks(n)
+		case hasClass(n, "footnote"):
+			// 脚注は本文中の ((...)) から作り直される
+		default:
+			c.stopp(n)
+		}
+	default:
+		c.stopp(n)
+	}
+}
+
+// inline: n の子をインライン記法に変換する。pre でなければ改行は <br> だけから作る
+func (c *converter) inline(n *html.Node, pre bool) string {
+	var b strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		c.inlineNode(&b, child, pre)
+	}
+	return b.String()
+}
+
+var reFootnoteHref = regexp.MustCompile(`^#fn\d+$`)
+
+func (c *converter) inlineNode(b *strings.Builder, n *html.Node, pre bool) {
+	switch n.Type {
+	case html.TextNode:
+		text := n.Data
+		if !pre {
+			text = strings.ReplaceAll(text, "\n", "")
+		}
+		b.WriteString(escapeText(text))
+		return
+	case html.CommentNode:
+		b.WriteString("<!-- -->")
+		return
+	case html.ElementNode:
+	default:
+		return
+	}
+	switch n.DataAtom {
+	case atom.Br:
+		b.WriteString("\n")
+		return
+	case atom.A:
+		if s, ok := c.link(n); ok {
+			b.WriteString(s)
+			return
+		}
+	case atom.Img:
+		if s, ok := image(n); ok {
+			b.WriteString(s)
+			return
+		}
+	case atom.Svg:
+		if s, ok := barcodeSVG(n); ok {
+			b.WriteString(s)
+			return
+		}
+	case atom.Math, atom.Code:
+		if s, ok := tex(n); ok {
+			b.WriteString(s)
+			return
+		}
+	}
+	writeRaw(b, n, func(child *html.Node) { c.inlineNode(b, child, pre) })
+}
+
+// link: xatena が出力するリンクを記法に戻す
+func (c *converter) link(n *html.Node) (string, bool) {
+	href, ok := attr(n, "href")
+	if !ok {
+		return "", false
+	}
+	text := textContent(n)
+	if title, ok := attr(n, "title"); ok && reFootnoteHref.MatchString(href) {
+		return "((" + html.UnescapeString(title) + "))", true
+	}
+	if n.FirstChild != nil && (n.FirstChild.NextSibling != nil || n.FirstChild.Type != html.TextNode) {
+		return "", false // 中に要素があるリンクはそのまま残す
+	}
+	switch {
+	case strings.HasPrefix(href, "mailto:") && text == href[len("mailto:"):]:
+		return "[" + href + "]", true
+	case isURL(href) && text == href:
+		return "[" + href + "]", true
+	case isURL(href) && !strings.Contains(text, "]") && text != "":
+		return "[" + href + ":title=" + text + "]", true
+	}
+	return "", false
+}
+
+// 以前の xatena が出力していた Google Chart API の画像も記法に戻す
+var (
+	reQRChart  = regexp.MustCompile(`^http://chart\.apis\.google\.com/chart\?chs=150x150&cht=qr&chl=`)
+	reTexChart = regexp.MustCompile(`^http://chart\.apis\.google\.com/chart\?cht=tx&chl=`)
+)
+
+func image(n *html.Node) (string, bool) {
+	src, _ := attr(n, "src")
+	if class, _ := attr(n, "class"); class == "barcode" || reQRChart.MatchString(src) {
+		if title, ok := attr(n, "title"); ok {
+			return "[" + title + ":barcode]", true
+		}
+	}
+	if reTexChart.MatchString(src) {
+		if alt, ok := attr(n, "alt"); ok && !strings.Contains(alt, "]") {
+			return "[tex:" + alt + "]", true
+		}
+	}
+	return "", false
+}
+
+// barcodeSVG: <svg class="barcode"> で埋め込んだ QR コードを記法に戻す (URL は <title> にある)
+func barcodeSVG(n *html.Node) (string, bool) {
+	if class, _ := attr(n, "class"); class != "barcode" {
+		return "", false
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode && child.Data == "title" {
+			return "[" + textContent(child) + ":barcode]", true
+		}
+	}
+	return "", false
+}
+
+// tex: [tex:...] の出力 (MathML の <math alttext> と、変換できなかったときの <code class="tex">) を記法に戻す
+func tex(n *html.Node) (string, bool) {
+	var expr string
+	if n.DataAtom == atom.Math {
+		alt, ok := attr(n, "alttext")
+		if !ok {
+			return "", false
+		}
+		expr = alt
+	} else {
+		if class, _ := attr(n, "class"); class != "tex" {
+			return "", false
+		}
+		expr = textContent(n)
+	}
+	if expr == "" || strings.Contains(expr, "]") {
+		return "", false
+	}
+	return "[tex:" + expr + "]", true
+}
+
+func (c *converter) list(n *html.Node, marks string) []string {
+	mark := "-"
+	if n.DataAtom == atom.Ol {
+		mark = "+"
+	}
+	marks += mark
+	var lines []string
+	for li := n.FirstChild; li != nil; li = li.NextSibling {
+		if li.Type != html.ElementNode || li.DataAtom != atom.Li {
+			continue
+		}
+		var text strings.Builder
+		var nested []string
+		for child := li.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type == html.ElementNode && (child.DataAtom == atom.Ul || child.DataAtom == atom.Ol) {
+				nested = append(nested, c.list(child, marks)...)
+				continue
+			}
+			c.inlineNode(&text, child, false)
+		}
+		if t := strings.TrimSpace(text.String()); t != "" {
+			lines = append(lines, marks+" "+t)
+		}
+		lines = append(lines, nested...)
+	}
+	return lines
+}
+
+func (c *converter) table(n *html.Node) string {
+	var rows []string
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != html.ElementNode {
+				continue
+			}
+			if child.DataAtom != atom.Tr {
+				walk(child) // tbody / thead
+				continue
+			}
+			var b strings.Builder
+			b.WriteString("|")
+			for cell := child.FirstChild; cell != nil; cell = cell.NextSibling {
+				if cell.Type != html.ElementNode {
+					continue
+				}
+				if cell.DataAtom == atom.Th {
+					b.WriteString("*")
+				}
+				b.WriteString(strings.TrimSpace(c.inline(cell, false)))
+				b.WriteString("|")
+			}
+			rows = append(rows, b.String())
+		}
+	}
+	walk(n)
+	return strings.Join(rows, "\n")
+}
+
+func (c *converter) definitionList(n *html.Node) string {
+	var lines []string
+	termOnly := false // 最後の行が :term: だけで、最初の dd をその行に続けられる
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != html.ElementNode {
+			continue
+		}
+		text := strings.TrimSpace(c.inline(child, false))
+		switch child.DataAtom {
+		case atom.Dt:
+			lines = append(lines, ":"+text+":")
+			termOnly = true
+		case atom.Dd:
+			if termOnly {
+				lines[len(lines)-1] += text
+			} else {
+				lines = append(lines, "::"+text)
+			}
+			termOnly = false
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+var reLangClass = regexp.MustCompile(`(?:^|\s)lang-(\S+)`)
+
+func (c *converter) pre(n *html.Node) {
+	if hasClass(n, "code") {
+		lang := ""
+		if class, _ := attr(n, "class"); reLangClass.MatchString(class) {
+			lang = reLangClass.FindStringSubmatch(class)[1]
+		}
+		c.emit(">|"+lang+"|\n"+textContent(n)+"\n||<", false)
+		return
+	}
+	c.emit(">|\n"+c.inline(n, true)+"|<", false)
+}
+
+// codeFigure: ファイル名・行番号・強調する行を付けた super pre (>|go:main.go@10{1,4-6}|) に戻す
+func (c *converter) codeFigure(n *html.Node) {
+	var filename string
+	var pre *html.Node
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		switch child.DataAtom {
+		case atom.Figcaption:
+			filename = strings.TrimSpace(textContent(child))
+		case atom.Pre:
+			pre = child
+		}
+	}
+	if pre == nil {
+		c.stopp(n)
+		return
+	}
+	header := ""
+	if class, _ := attr(pre, "class"); reLangClass.MatchString(class) {
+		header = reLangClass.FindStringSubmatch(class)[1]
+	}
+	first, line := 0, 0
+	var emphasized []int
+	for child := pre.FirstChild; child != nil; child = child.NextSibling {
+		if child.DataAtom != atom.Span || !hasClass(child, "line") {
+			continue
+		}
+		line++
+		if line == 1 {
+			v, _ := attr(child, "data-line")
+			first, _ = strconv.Atoi(v)
+		}
+		if hasClass(child, "emphasized") {
+			emphasized = append(emphasized, line)
+		}
+	}
+	if filename != "" {
+		header += ":" + filename
+	}
+	if first > 1 || (first == 1 && filename == "" && len(emphasized) == 0) {
+		header += "@" + strconv.Itoa(first)
+	}
+	if len(emphasized) > 0 {
+		header += "{" + lineRanges(emphasized) + "}"
+	}
+	c.emit(">|"+header+"|\n"+textContent(pre)+"\n||<", false)
+}
+
+// lineRanges: 昇順の行番号を 1,4-6 の形にまとめる
+func lineRanges(lines []int) string {
+	var ranges []string
+	for i := 0; i < len(lines); {
+		j := i
+		for j+1 < len(lines) && lines[j+1] == lines[j]+1 {
+			j++
+		}
+		r := strconv.Itoa(lines[i])
+		if j > i {
+			r += "-" + strconv.Itoa(lines[j])
+		}
+		ranges = append(ranges, r)
+		i = j + 1
+	}
+	return strings.Join(ranges, ",")
+}
+
+func (c *converter) blockquote(n *html.Node) {
+	header := ""
+	var citeNode *html.Node
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode && child.DataAtom == atom.Cite {
+			citeNode = child
+		}
+	}
+	if cite, ok := attr(n, "cite"); ok && isURL(cite) {
+		header = cite
+		if citeNode != nil {
+			if t := textContent(citeNode); t != cite {
+				header += ":title=" + t
+			}
+		}
+	} else if citeNode != nil {
+		header = strings.TrimSpace(c.inline(citeNode, false))
+	}
+	c.emit(">"+header+">", false)
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child != citeNode {
+			c.block(child)
+		}
+	}
+	c.emit("<<", false)
+}
+
+// stopp: 記法に対応しない要素は ><...>< (p を補わないブロック) でそのまま出力する
+func (c *converter) stopp(n *html.Node) {
+	var b strings.Builder
+	writeRaw(&b, n, func(child *html.Node) { c.inlineNode(&b, child, true) })
+	s := strings.TrimSpace(b.String())
+	if s == "" {
+		return
+	}
+	c.emit(">"+s+"<", false)
+}
+
+// writeRaw: 要素をタグ付きで書き出す。子は inner で書き出す
+func writeRaw(b *strings.Builder, n *html.Node, inner func(*html.Node)) {
+	b.WriteString("<" + n.Data)
+	for _, a := range n.Attr {
+		b.WriteString(" " + a.Key + `="` + html.EscapeString(a.Val) + `"`)
+	}
+	if isVoid(n.DataAtom) {
+		b.WriteString(" />")
+		return
+	}
+	b.WriteString(">")
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		inner(child)
+	}
+	b.WriteString("</" + n.Data + ">")
+}
+
+func isVoid(a atom.Atom) bool {
+	switch a {
+	case atom.Area, atom.Base, atom.Br, atom.Col, atom.Embed, atom.Hr, atom.Img, atom.Input,
+		atom.Link, atom.Meta, atom.Source, atom.Track, atom.Wbr:
+		return true
+	}
+	return false
+}
+
+var textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// 記法として解釈されうる文字列
+var reNotationLike = regexp.MustCompile(`\(\(|\)\)|\[|https?:|ftp:`)
+
+// escapeText: テキストを記法として解釈されないようにする
+// HTML の特殊文字は文字参照に戻し、記法に見える部分は []...[] で囲む
+func escapeText(s string) string {
+	s = textEscaper.Replace(s)
+	if reNotationLike.MatchString(s) && !strings.Contains(s, "[]") {
+		return "[]" + s + "[]"
+	}
+	return s
+}
+
+func textContent(n *html.Node) string {
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return b.String()
+}
+
+func attr(n *html.Node, key string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+func hasClass(n *html.Node, class string) bool {
+	v, _ := attr(n, "class")
+	for _, c := range strings.Fields(v) {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+func isURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://") || strings.HasPrefix(s, "ftp://")
+}
@@ -0,0 +1,47 @@
+package html2xatena
+
+import "testing"
+
+func TestConvert(t *testing.T) {
+	cases := []struct {
+		name     string
+		html     string
+		expected string
+	}{
+		{"headings", "<h3>a</h3><h4>b</h4><h5>c</h5>", "* a\n\n** b\n\n*** c"},
+		{"paragraphs", "<p>foo<br />\nbar</p><p>baz</p><br /><p>qux</p>", "foo\nbar\n\nbaz\n\n\nqux"},
+		{"lists", "<ul><li>a<ol><li>b</li></ol></li><li>c</li></ul>", "- a\n-+ b\n- c"},
+		{"table", "<table><tr><th>h</th><th>v</th></tr><tr><td>1</td><td>2</td></tr></table>", "|*h|*v|\n|1|2|"},
+		{"definition list", "<dl><dt>t</dt><dd>d1</dd><dd>d2:</dd><dt>u</dt></dl>", ":t:d1\n::d2:\n:u:"},
+		{"super pre", `<pre class="code lang-go">if a &lt; b {}</pre>`, ">|go|\nif a < b {}\n||<"},
+		{"super pre figure", `<figure class="code-block"><figcaption>main.go</figcaption><pre class="code lang-go"><span class="line" data-line="10">a</span>
+<span class="line emphasized" data-line="11"><span class="synComment">// &lt;b&gt;</span></span>
+<span class="line emphasized" data-line="12">c</span>
+<span class="line" data-line="13">d</span>
+<span class="line emphasized" data-line="14">e</span></pre></figure>`, ">|go:main.go@10{2-3,5}|\na\n// <b>\nc\nd\ne\n||<"},
+		{"super pre figure numbers only", `<figure class="code-block"><pre class="code"><span class="line" data-line="1">a</span></pre></figure>`, ">|@1|\na\n||<"},
+		{"pre", "<pre>a <b>b</b>\n</pre>", ">|\na <b>b</b>\n|<"},
+		{"blockquote with cite", `<blockquote cite="http://example.com/"><p>q</p><cite><a href="http://example.com/">Ex</a></cite></blockquote>`, ">http://example.com/:title=Ex>\n\nq\n\n<<"},
+		{"links", `<p><a href="http://example.com/">http://example.com/</a> <a href="http://example.com/">Ex</a> <a href="mailto:a@example.com">a@example.com</a></p>`,
+			"[http://example.com/] [http://example.com/:title=Ex] [mailto:a@example.com]"},
+		{"footnote", `<p>a<a href="#fn1" title="note">*1</a></p><div class="footnote"><p class="footnote">note</p></div>`, "a((note))"},
+		{"escape", "<p>((not a footnote)) &lt;b&gt; &amp;</p>", "[]((not a footnote)) &lt;b&gt; &amp;[]"},
+		{"unknown block", `<div class="x"><span>raw</span></div>`, `><div class="x"><span>raw</span></div><`},
+		{"barcode img", `<p><img class="barcode" src="data:image/svg+xml;base64,PHN2Zy8+" title="http://example.com/" alt="http://example.com/"/></p>`, "[http://example.com/:barcode]"},
+		{"barcode svg", `<p><svg xmlns="http://www.w3.org/2000/svg" class="barcode" width="150" height="150"><title>http://example.com/?a=1&amp;b=2</title><path d="M4 4h7v1h-7z"/></svg></p>`, "[http://example.com/?a=1&b=2:barcode]"},
+		{"legacy barcode", `<p><img src="http://chart.apis.google.com/chart?chs=150x150&amp;cht=qr&amp;chl=http%3A%2F%2Fexample.com%2F" title="http://example.com/"/></p>`, "[http://example.com/:barcode]"},
+		{"tex", `<p><math xmlns="http://www.w3.org/1998/Math/MathML" alttext="x &lt; \frac{1}{2}"><mi>x</mi></math> <code class="tex">\unknown</code> <code>x</code></p>`, `[tex:x < \frac{1}{2}] [tex:\unknown] <code>x</code>`},
+		{"inline markup", "<p><strong>bold</strong> text</p>", "<strong>bold</strong> text"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := Convert(c.html)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != c.expected {
+				t.Errorf("got %q, want %q", got, c.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+// Package pagetitle は Web ページのタイトルを取得する
+// HTTP ヘッダ・BOM・<meta charset> から文字コードを判定して UTF-8 に直し、
+// <title> がなければ og:title、twitter:title の順に使う。
+// Fetcher は xatena.TitleResolver として [url:title] のタイトル取得に使える
+package pagetitle
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+)
+
+const (
+	DefaultMaxRedirects = 5
+	DefaultMaxBodySize  = 2 << 20 // 2MB
+	DefaultTimeout      = 10 * time.Second
+)
+
+// ErrNoTitle はページにタイトルが見つからなかったときのエラー
+var ErrNoTitle = errors.New("pagetitle: no title")
+
+// Fetcher は HTTP でページを取得してタイトルを取り出す。ゼロ値でも使える
+type Fetcher struct {
+	Client       *http.Client // nil なら DefaultTimeout のタイムアウトを設定したクライアント
+	UserAgent    string
+	MaxRedirects int   // たどるリダイレクトの数 (0 なら DefaultMaxRedirects、負ならたどらない)
+	MaxBodySize  int64 // 読み込むレスポンスボディのバイト数 (0 なら DefaultMaxBodySize)
+}
+
+var defaultClient = &http.Client{Timeout: DefaultTimeout}
+
+func (f *Fetcher) client() *http.Client {
+	c := defaultClient
+	if f.Client != nil {
+		c = f.Client
+	}
+	max := f.MaxRedirects
+	if max == 0 {
+		max = DefaultMaxRedirects
+	} else if max < 0 {
+		max = 0
+	}
+	capped := *c
+	capped.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) > max {
+			return fmt.Errorf("pagetitle: stopped after %d redirects", max)
+		}
+		if c.CheckRedirect != nil {
+			return c.CheckRedirect(req, via)
+		}
+		return nil
+	}
+	return &capped
+}
+
+// Fetch: uri のページのタイトルを返す
+// 2xx 以外の応答や HTML でないページ、タイトルのないページはエラーになる
+func (f *Fetcher) Fetch(ctx context.Context, uri string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+	if err != nil {
+		return "", err
+	}
+	if f.UserAgent != "" {
+		req.Header.Set("User-Agent", f.UserAgent)
+	}
+	resp, err := f.client().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("pagetitle: non-2xx status: %d", resp.StatusCode)
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if !isHTML(contentType) {
+		return "", fmt.Errorf("pagetitle: not an HTML page: %s", contentType)
+	}
+	size := f.MaxBodySize
+	if size <= 0 {
+		size = DefaultMaxBodySize
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, size))
+	if err != nil {
+		return "", err
+	}
+	return Extract(body, contentType)
+}
+
+// ResolveTitle: Fetch と同じ。xatena.TitleResolver を満たす
+func (f *Fetcher) ResolveTitle(ctx context.Context, uri string) (string, error) {
+	return f.Fetch(ctx, uri)
+}
+
+// isHTML: Content-Type が HTML (または不明) なら true
+func isHTML(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return true
+	}
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+// Extract: HTML のバイト列からタイトルを取り出す。contentType は HTTP の Content-Type ヘッダ (なければ空)
+// <title>、og:title、twitter:title の順に空でないものを、空白をまとめて返す
+func Extract(body []byte, contentType string) (string, error) {
+	enc := DetectEncoding(body, contentType)
+	decoded, err := enc.NewDecoder().Bytes(body)
+	if err != nil {
+		return "", err
+	}
+	var title, og, twitter string
+	z := html.NewTokenizer(bytes.NewReader(decoded))
+	for title == "" {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+		t := z.Token()
+		switch t.DataAtom {
+		case atom.Title:
+			if z.Next() == html.TextToken {
+				title = Normalize(z.Token().Data)
+			}
+		case atom.Meta:
+			key, content := "", ""
+			for _, a := range t.Attr {
+				switch a.Key {
+				case "property", "name":
+					key = strings.ToLower(a.Val)
+				case "content":
+					content = a.Val
+				}
+			}
+			switch {
+			case key == "og:title" && og == "":
+				og = Normalize(content)
+			case key == "twitter:title" && twitter == "":
+				twitter = Normalize(content)
+			}
+		case atom.Body:
+			// タイトルとメタデータは head にあるので本文は読まない
+			if og != "" || twitter != "" {
+				return firstNonEmpty(og, twitter), nil
+			}
+		}
+	}
+	if t := firstNonEmpty(title, og, twitter); t != "" {
+		return t, nil
+	}
+	return "", ErrNoTitle
+}
+
+func firstNonEmpty(ss ...string) string {
+	for _, s := range ss {
+		if s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// DetectEncoding: HTML の文字コードを BOM、Content-Type の charset、<meta charset> (http-equiv を含む) の順に判定する
+// どれもなければ UTF-8 として正しいバイト列なら UTF-8、そうでなければ windows-1252 とみなす
+func DetectEncoding(body []byte, contentType string) encoding.Encoding {
+	enc, _, certain := charset.DetermineEncoding(body, contentType)
+	if certain {
+		return enc
+	}
+	// DetermineEncoding は先頭 1024 バイトしか見ないので、長い head の後ろにある宣言も探す
+	if enc := metaCharset(body); enc != nil {
+		return enc
+	}
+	if utf8.Valid(body) {
+		return encoding.Nop
+	}
+	return enc
+}
+
+// metaCharset: head の中の <meta charset> か <meta http-equiv="Content-Type"> が示す文字コード
+// 日本語の文字コードでも <、> と属性の区切りは ASCII のままなので、変換前のバイト列のまま読める
+func metaCharset(body []byte) encoding.Encoding {
+	z := html.NewTokenizer(bytes.NewReader(body))
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			return nil
+		case html.EndTagToken:
+			if name, _ := z.TagName(); string(name) == "head" {
+				return nil
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			t := z.Token()
+			switch t.DataAtom {
+			case atom.Body:
+				return nil
+			case atom.Meta:
+				var httpEquiv, content string
+				for _, a := range t.Attr {
+					switch a.Key {
+					case "charset":
+						if enc, _ := charset.Lookup(a.Val); enc != nil {
+							return enc
+						}
+					case "http-equiv":
+						httpEquiv = strings.ToLower(a.Val)
+					case "content":
+						content = a.Val
+					}
+				}
+				if httpEquiv == "content-type" {
+					if _, params, err := mime.ParseMediaType(content); err == nil {
+						if enc, _ := charset.Lookup(params["charset"]); enc != nil {
+							return enc
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// Normalize: 前後の空白を除き、改行やタブを含む連続した空白を1つのスペースにする
+// ノーブレークスペースも空白として扱い、全角スペースはタイトルの一部として残す
+func Normalize(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range s {
+		switch r {
+		case ' ', '\t', '\n', '\r', '\f', '\u00a0':
+			space = b.Len() > 0
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
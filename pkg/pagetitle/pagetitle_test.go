@@ -0,0 +1,150 @@
+package pagetitle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+const fixtureTitle = "はてな記法 ― 日本語のタイトル"
+
+func TestExtractFixtures(t *testing.T) {
+	cases := []struct {
+		file        string
+		contentType string
+		want        string
+	}{
+		{"utf8.html", "text/html", fixtureTitle},
+		{"shift_jis.html", "text/html", fixtureTitle},
+		{"euc-jp.html", "", fixtureTitle},
+		{"iso-2022-jp.html", "text/html", fixtureTitle},
+		// 先頭 1024 バイトより後ろにある <meta charset>
+		{"shift_jis-late-meta.html", "text/html", fixtureTitle},
+		// 文書中に宣言がなく HTTP ヘッダだけで分かる
+		{"shift_jis-header.html", "text/html; charset=Shift_JIS", fixtureTitle},
+		// BOM は <meta charset> や HTTP ヘッダより優先する
+		{"utf8-bom.html", "text/html; charset=EUC-JP", fixtureTitle},
+		{"utf16le-bom.html", "", fixtureTitle},
+		{"og-title.html", "text/html", fixtureTitle + " (OGP)"},
+		{"twitter-title.html", "text/html", fixtureTitle + " (Twitter)"},
+		{"whitespace.html", "text/html", "Hello, World &　全角"},
+	}
+	for _, c := range cases {
+		t.Run(c.file, func(t *testing.T) {
+			body, err := os.ReadFile("testdata/" + c.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Extract(body, c.contentType)
+			if err != nil {
+				t.Fatalf("err = %v", err)
+			}
+			if got != c.want {
+				t.Errorf("got %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestExtractNoTitle(t *testing.T) {
+	body, err := os.ReadFile("testdata/no-title.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Extract(body, "text/html"); !errors.Is(err, ErrNoTitle) {
+		t.Errorf("err = %v, want ErrNoTitle", err)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	cases := map[string]string{
+		"":          "",
+		"  a  ":     "a",
+		"a\n\t b":   "a b",
+		"a\u00a0 b": "a b",
+		"全角　スペース":   "全角　スペース",
+	}
+	for in, want := range cases {
+		if got := Normalize(in); got != want {
+			t.Errorf("Normalize(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func newFixtureServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sjis", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := os.ReadFile("testdata/shift_jis-header.html")
+		w.Header().Set("Content-Type", "text/html; charset=Shift_JIS")
+		w.Write(body)
+	})
+	mux.HandleFunc("/euc", func(w http.ResponseWriter, r *http.Request) {
+		// Content-Type を付けずに返す
+		body, _ := os.ReadFile("testdata/euc-jp.html")
+		w.Header()["Content-Type"] = nil
+		w.Write(body)
+	})
+	mux.HandleFunc("/image", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("\x89PNG"))
+	})
+	mux.HandleFunc("/ua", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "<title>%s</title>", r.UserAgent())
+	})
+	mux.HandleFunc("/redirect/", func(w http.ResponseWriter, r *http.Request) {
+		var n int
+		fmt.Sscanf(strings.TrimPrefix(r.URL.Path, "/redirect/"), "%d", &n)
+		if n == 0 {
+			http.Redirect(w, r, "/sjis", http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("/redirect/%d", n-1), http.StatusFound)
+	})
+	s := httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestFetcher(t *testing.T) {
+	s := newFixtureServer(t)
+	ctx := context.Background()
+	f := &Fetcher{UserAgent: "xatena-test"}
+	for _, path := range []string{"/sjis", "/euc", "/redirect/2"} {
+		got, err := f.Fetch(ctx, s.URL+path)
+		if err != nil || got != fixtureTitle {
+			t.Errorf("Fetch(%s) = %q, %v", path, got, err)
+		}
+	}
+	if got, err := f.ResolveTitle(ctx, s.URL+"/ua"); err != nil || got != "xatena-test" {
+		t.Errorf("user agent = %q, %v", got, err)
+	}
+	if _, err := f.Fetch(ctx, s.URL+"/image"); err == nil {
+		t.Errorf("expected error for non-HTML page")
+	}
+	if _, err := f.Fetch(ctx, s.URL+"/missing"); err == nil {
+		t.Errorf("expected error for 404")
+	}
+}
+
+func TestFetcherRedirectCap(t *testing.T) {
+	s := newFixtureServer(t)
+	ctx := context.Background()
+	// /redirect/2 → /redirect/1 → /redirect/0 → /sjis で3回リダイレクトする
+	if _, err := (&Fetcher{MaxRedirects: 3}).Fetch(ctx, s.URL+"/redirect/2"); err != nil {
+		t.Errorf("3 redirects: %v", err)
+	}
+	if _, err := (&Fetcher{MaxRedirects: 2}).Fetch(ctx, s.URL+"/redirect/2"); err == nil || !strings.Contains(err.Error(), "stopped after 2 redirects") {
+		t.Errorf("err = %v, want redirect cap", err)
+	}
+	if _, err := (&Fetcher{MaxRedirects: -1}).Fetch(ctx, s.URL+"/redirect/0"); err == nil {
+		t.Errorf("expected error when redirects are disabled")
+	}
+	if _, err := (&Fetcher{}).Fetch(ctx, s.URL+"/redirect/10"); err == nil {
+		t.Errorf("expected error beyond DefaultMaxRedirects")
+	}
+}
@@ -0,0 +1,302 @@
+package qrcode
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// decode: Black だけを見てモジュールを読み、バイトモードのデータを取り出す
+// 形式情報の2つの写しと、各ブロックの誤り訂正コード語が正しいことも確かめる
+func decode(t *testing.T, c *Code) []byte {
+	t.Helper()
+	size := c.Size
+	version := (size - 17) / 4
+	if version*4+17 != size || version < MinVersion || version > MaxVersion {
+		t.Fatalf("invalid size %d", size)
+	}
+	black := func(x, y int) int {
+		if c.Black(x, y) {
+			return 1
+		}
+		return 0
+	}
+	var first, second int
+	for i := 0; i <= 5; i++ {
+		first |= black(8, i) << i
+	}
+	first |= black(8, 7)<<6 | black(8, 8)<<7 | black(7, 8)<<8
+	for i := 9; i < 15; i++ {
+		first |= black(14-i, 8) << i
+	}
+	for i := 0; i < 8; i++ {
+		second |= black(size-1-i, 8) << i
+	}
+	for i := 8; i < 15; i++ {
+		second |= black(8, size-15+i) << i
+	}
+	if first != second {
+		t.Fatalf("format info copies differ: %015b %015b", first, second)
+	}
+	format := (first ^ 0x5412) >> 10
+	var level Level
+	for l := Low; l <= High; l++ {
+		if l.formatBits() == format>>3 {
+			level = l
+		}
+	}
+	mask := format & 7
+	if formatInfo(level, mask) != first {
+		t.Fatalf("format info BCH mismatch: %015b", first)
+	}
+	if level != c.Level || mask != c.Mask || version != c.Version {
+		t.Fatalf("decoded %v/%d/v%d, code says %v/%d/v%d", level, mask, version, c.Level, c.Mask, c.Version)
+	}
+	if version >= 7 {
+		var v int
+		for i := 0; i < 18; i++ {
+			v |= black(size-11+i%3, i/3) << i
+		}
+		if v != versionInfo(version) {
+			t.Fatalf("version info = %018b", v)
+		}
+	}
+
+	// 機能パターンの位置は型番だけで決まる
+	function := newCode(version, level).isFunction
+	var raw []byte
+	var cur byte
+	n := 0
+	for right := size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < size; vert++ {
+			y := vert
+			if (right+1)&2 == 0 {
+				y = size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if function[y][x] {
+					continue
+				}
+				bit := c.Black(x, y) != masked(mask, x, y)
+				cur <<= 1
+				if bit {
+					cur |= 1
+				}
+				if n++; n%8 == 0 {
+					raw = append(raw, cur)
+					cur = 0
+				}
+			}
+		}
+	}
+	total := rawDataModules(version) / 8
+	raw = raw[:total]
+
+	// 交互に並んだコード語をブロックに戻す
+	numBlocks := errorCorrectionBlocks[level][version]
+	eccLen := eccCodewordsPerBlock[level][version]
+	numShort := numBlocks - total%numBlocks
+	shortData := total/numBlocks - eccLen
+	blocks := make([][]byte, numBlocks)
+	k := 0
+	for i := 0; i < shortData+1; i++ {
+		for j := range blocks {
+			if i < shortData || j >= numShort {
+				blocks[j] = append(blocks[j], raw[k])
+				k++
+			}
+		}
+	}
+	divisor := rsDivisor(eccLen)
+	var data []byte
+	for j := range blocks {
+		ecc := make([]byte, eccLen)
+		for i := range ecc {
+			ecc[i] = raw[k+i*numBlocks+j]
+		}
+		if want := rsRemainder(blocks[j], divisor); !bytes.Equal(ecc, want) {
+			t.Fatalf("block %d: ecc mismatch", j)
+		}
+		data = append(data, blocks[j]...)
+	}
+
+	pos := 0
+	read := func(n int) int {
+		v := 0
+		for i := 0; i < n; i++ {
+			v = v<<1 | int(data[pos>>3]>>(7-pos&7)&1)
+			pos++
+		}
+		return v
+	}
+	if mode := read(4); mode != 4 {
+		t.Fatalf("mode = %04b, want byte mode", mode)
+	}
+	length := read(charCountBits(version))
+	out := make([]byte, length)
+	for i := range out {
+		out[i] = byte(read(8))
+	}
+	return out
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	inputs := []string{
+		"",
+		"http://example.com/",
+		"https://example.com/path?query=値&x=1#frag",
+		strings.Repeat("0123456789abcdef", 20), // 型番 7 以上 (型番情報あり)
+		strings.Repeat("xatena", 150),          // 長いブロックと短いブロックが混ざる
+	}
+	for _, in := range inputs {
+		for level := Low; level <= High; level++ {
+			t.Run(fmt.Sprintf("%d/%v", len(in), level), func(t *testing.T) {
+				c, err := Encode([]byte(in), level)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := decode(t, c); string(got) != in {
+					t.Errorf("decoded %q, want %q", got, in)
+				}
+			})
+		}
+	}
+}
+
+func TestEncodeVersion(t *testing.T) {
+	cases := []struct {
+		size    int
+		level   Level
+		version int
+	}{
+		{17, Low, 1}, // 型番 1-L のバイトモードの容量
+		{18, Low, 2},
+		{14, Medium, 1},
+		{15, Medium, 2},
+		{7, High, 1},
+		{2953, Low, 40},
+		{1273, High, 40},
+	}
+	for _, c := range cases {
+		code, err := Encode(bytes.Repeat([]byte("a"), c.size), c.level)
+		if err != nil {
+			t.Fatalf("%d bytes %v: %v", c.size, c.level, err)
+		}
+		if code.Version != c.version || code.Size != c.version*4+17 {
+			t.Errorf("%d bytes %v: version %d size %d, want version %d", c.size, c.level, code.Version, code.Size, c.version)
+		}
+	}
+	if _, err := Encode(make([]byte, 2954), Low); err != ErrTooLong {
+		t.Errorf("err = %v, want ErrTooLong", err)
+	}
+}
+
+func TestFunctionPatterns(t *testing.T) {
+	c, err := Encode([]byte("http://example.com/"), Medium)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 左上の位置検出パターンと分離パターン
+	want := []string{
+		"#######.",
+		"#.....#.",
+		"#.###.#.",
+		"#.###.#.",
+		"#.###.#.",
+		"#.....#.",
+		"#######.",
+		"........",
+	}
+	for y, row := range want {
+		for x, ch := range row {
+			if c.Black(x, y) != (ch == '#') {
+				t.Errorf("module (%d, %d) = %v", x, y, c.Black(x, y))
+			}
+		}
+	}
+	if !c.Black(8, c.Size-8) {
+		t.Errorf("dark module missing")
+	}
+	if c.Black(-1, 0) || c.Black(0, c.Size) {
+		t.Errorf("out of range modules should be light")
+	}
+}
+
+func TestSVG(t *testing.T) {
+	c, err := Encode([]byte("http://example.com/?a=<b>"), Medium)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svg := c.SVG(150, "barcode", "http://example.com/?a=<b>")
+	n := c.Size + QuietZone*2
+	for _, want := range []string{
+		`<svg xmlns="http://www.w3.org/2000/svg" class="barcode" width="150" height="150"`,
+		fmt.Sprintf(`viewBox="0 0 %d %d"`, n, n),
+		`<title>http://example.com/?a=&lt;b&gt;</title>`,
+	} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("missing %s in %s", want, svg)
+		}
+	}
+	// パスから暗モジュールを復元して元の配置と比べる
+	dark := map[[2]int]bool{}
+	for _, m := range regexp.MustCompile(`M(\d+) (\d+)h(\d+)`).FindAllStringSubmatch(svg, -1) {
+		var x, y, run int
+		fmt.Sscan(m[1], &x)
+		fmt.Sscan(m[2], &y)
+		fmt.Sscan(m[3], &run)
+		for i := 0; i < run; i++ {
+			dark[[2]int{x + i - QuietZone, y - QuietZone}] = true
+		}
+	}
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if dark[[2]int{x, y}] != c.Black(x, y) {
+				t.Fatalf("path module (%d, %d) = %v", x, y, dark[[2]int{x, y}])
+			}
+		}
+	}
+
+	uri := c.DataURI(0)
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(uri, "data:image/svg+xml;base64,"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := c.SVG(0, "", ""); string(decoded) != want || !strings.Contains(want, fmt.Sprintf(`width="%d"`, n)) {
+		t.Errorf("data URI = %s", decoded)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, l := range []Level{Low, Medium, Quartile, High} {
+		if got, err := ParseLevel(strings.ToLower(l.String())); err != nil || got != l {
+			t.Errorf("ParseLevel(%s) = %v, %v", l, got, err)
+		}
+	}
+	if _, err := ParseLevel("X"); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestReedSolomon(t *testing.T) {
+	// "HELLO WORLD" (英数字モード、型番 1-M) のデータコード語と誤り訂正コード語
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if got := rsRemainder(data, rsDivisor(len(want))); !bytes.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// 型番 1-M、マスク 5 の形式情報
+	if got := formatInfo(Medium, 5); got != 0b100000011001110 {
+		t.Errorf("format info = %015b", got)
+	}
+	if got := versionInfo(7); got != 0b000111110010010100 {
+		t.Errorf("version info = %018b", got)
+	}
+}
@@ -0,0 +1,576 @@
+// Package texmath は LaTeX の数式のよく使う部分を MathML に変換する
+// 分数・添字・ギリシャ文字・\sqrt・\sum や \int の上下限・行列・演算子などに対応し、
+// 対応していないコマンドは ErrUnsupported を返す
+package texmath
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+)
+
+// ErrUnsupported は対応していないコマンドや環境を使ったときのエラー
+var ErrUnsupported = errors.New("texmath: unsupported")
+
+// ToMathML: expr を <math> 要素にする。元の式は alttext に入れる
+func ToMathML(expr string) (string, error) {
+	return toMathML(expr, "")
+}
+
+// ToDisplayMathML: ToMathML と同じだが、段落から独立した数式 (display="block") にする
+func ToDisplayMathML(expr string) (string, error) {
+	return toMathML(expr, ` display="block"`)
+}
+
+func toMathML(expr, attrs string) (string, error) {
+	p := &parser{toks: tokenize(expr)}
+	items, stop, err := p.parseList()
+	if err != nil {
+		return "", err
+	}
+	if stop != "" {
+		return "", fmt.Errorf("texmath: unexpected %s", stop)
+	}
+	return `<math xmlns="http://www.w3.org/1998/Math/MathML"` + attrs + ` alttext="` + html.EscapeString(expr) + `">` + row(items) + `</math>`, nil
+}
+
+// tokenize: コマンド (\frac、\, など)、連続した空白 (" " にまとめる)、それ以外の1文字に分ける
+func tokenize(s string) []string {
+	var toks []string
+	rs := []rune(s)
+	for i := 0; i < len(rs); {
+		j := i + 1
+		switch r := rs[i]; {
+		case unicode.IsSpace(r):
+			for j < len(rs) && unicode.IsSpace(rs[j]) {
+				j++
+			}
+			toks = append(toks, " ")
+			i = j
+			continue
+		case r == '\\':
+			for j < len(rs) && isLetter(rs[j]) {
+				j++
+			}
+			if j == i+1 && j < len(rs) {
+				j++ // \, \{ などの記号1文字のコマンド
+			}
+		}
+		toks = append(toks, string(rs[i:j]))
+		i = j
+	}
+	return toks
+}
+
+func isLetter(r rune) bool {
+	return 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z'
+}
+
+func isDigit(tok string) bool {
+	return len(tok) == 1 && '0' <= tok[0] && tok[0] <= '9'
+}
+
+// item は変換した要素1つ
+type item struct {
+	ml     string
+	limits bool // \sum などで、添字を真下・真上に置く
+}
+
+func row(items []item) string {
+	if len(items) == 1 {
+		return items[0].ml
+	}
+	var b strings.Builder
+	b.WriteString("<mrow>")
+	for _, it := range items {
+		b.WriteString(it.ml)
+	}
+	b.WriteString("</mrow>")
+	return b.String()
+}
+
+type parser struct {
+	toks    []string
+	pos     int
+	variant string // \mathbf などで指定した mathvariant
+}
+
+func (p *parser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return ""
+}
+
+func (p *parser) next() string {
+	tok := p.peek()
+	if tok != "" {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) skipSpace() {
+	for p.peek() == " " {
+		p.pos++
+	}
+}
+
+// listStops はリストの終わりになるトークン。ここで止まって呼び出し元に返す
+var listStops = map[string]bool{"}": true, "&": true, `\\`: true, `\right`: true, `\end`: true}
+
+// parseList: 終わりのトークン (消費しない) か入力の終わりまで読む。止まったトークンを返す (入力の終わりなら空)
+func (p *parser) parseList(extraStops ...string) ([]item, string, error) {
+	var items []item
+	for {
+		p.skipSpace()
+		tok := p.peek()
+		if tok == "" || listStops[tok] {
+			return items, tok, nil
+		}
+		for _, s := range extraStops {
+			if tok == s {
+				return items, tok, nil
+			}
+		}
+		p.next()
+		switch tok {
+		case "^", "_":
+			var base item
+			if len(items) > 0 {
+				base = items[len(items)-1]
+				items = items[:len(items)-1]
+			} else {
+				base = item{ml: "<mrow></mrow>"}
+			}
+			scripted, err := p.parseScripts(base, tok)
+			if err != nil {
+				return nil, "", err
+			}
+			items = append(items, scripted)
+		case "'":
+			primes := "′"
+			for p.peek() == "'" {
+				p.next()
+				primes += "′"
+			}
+			if len(items) == 0 {
+				items = append(items, item{ml: "<mo>" + primes + "</mo>"})
+				continue
+			}
+			last := &items[len(items)-1]
+			last.ml = "<msup>" + last.ml + "<mo>" + primes + "</mo></msup>"
+		case `\limits`, `\nolimits`:
+			if len(items) > 0 {
+				items[len(items)-1].limits = tok == `\limits`
+			}
+		default:
+			if isDigit(tok) {
+				num := tok
+				for isDigit(p.peek()) || p.peek() == "." && p.pos+1 < len(p.toks) && isDigit(p.toks[p.pos+1]) {
+					num += p.next()
+				}
+				items = append(items, item{ml: p.token("mn", num)})
+				continue
+			}
+			it, err := p.parseAtom(tok)
+			if err != nil {
+				return nil, "", err
+			}
+			items = append(items, it)
+		}
+	}
+}
+
+// parseScripts: base に続く ^ と _ (first が最初の記号) を読んで添字を付ける
+func (p *parser) parseScripts(base item, first string) (item, error) {
+	scripts := map[string]string{}
+	for op := first; ; {
+		if _, dup := scripts[op]; dup {
+			return item{}, fmt.Errorf("texmath: double %s", op)
+		}
+		arg, err := p.parseArg()
+		if err != nil {
+			return item{}, err
+		}
+		scripts[op] = arg
+		p.skipSpace()
+		if next := p.peek(); next == "^" || next == "_" {
+			op = p.next()
+			continue
+		}
+		break
+	}
+	sub, hasSub := scripts["_"]
+	sup, hasSup := scripts["^"]
+	tags := [3]string{"msub", "msup", "msubsup"}
+	if base.limits {
+		tags = [3]string{"munder", "mover", "munderover"}
+	}
+	var ml string
+	switch {
+	case hasSub && hasSup:
+		ml = "<" + tags[2] + ">" + base.ml + sub + sup + "</" + tags[2] + ">"
+	case hasSub:
+		ml = "<" + tags[0] + ">" + base.ml + sub + "</" + tags[0] + ">"
+	default:
+		ml = "<" + tags[1] + ">" + base.ml + sup + "</" + tags[1] + ">"
+	}
+	return item{ml: ml}, nil
+}
+
+// parseArg: コマンドの引数を1つ読む ({...} か1文字か1つのコマンド)
+func (p *parser) parseArg() (string, error) {
+	p.skipSpace()
+	tok := p.next()
+	switch {
+	case tok == "":
+		return "", errors.New("texmath: missing argument")
+	case listStops[tok] || tok == "^" || tok == "_":
+		return "", fmt.Errorf("texmath: unexpected %s", tok)
+	case isDigit(tok):
+		return p.token("mn", tok), nil
+	}
+	it, err := p.parseAtom(tok)
+	return it.ml, err
+}
+
+// parseGroup: { を読んだあと、対応する } までを読む
+func (p *parser) parseGroup() (string, error) {
+	items, stop, err := p.parseList()
+	if err != nil {
+		return "", err
+	}
+	if stop != "}" {
+		return "", errors.New("texmath: missing }")
+	}
+	p.next()
+	return row(items), nil
+}
+
+// rawGroup: {...} の中身をそのまま文字列として読む (\text など)
+func (p *parser) rawGroup() (string, error) {
+	p.skipSpace()
+	if p.next() != "{" {
+		return "", errors.New("texmath: missing {")
+	}
+	var b strings.Builder
+	for depth := 0; ; {
+		tok := p.next()
+		switch tok {
+		case "":
+			return "", errors.New("texmath: missing }")
+		case "{":
+			depth++
+		case "}":
+			if depth == 0 {
+				return b.String(), nil
+			}
+			depth--
+		}
+		b.WriteString(tok)
+	}
+}
+
+// token: mi / mn / mo などのトークン要素を作る。mi と mn には \mathbf などの字体を付ける
+func (p *parser) token(tag, text string) string {
+	if p.variant != "" && tag != "mo" {
+		return `<` + tag + ` mathvariant="` + p.variant + `">` + html.EscapeString(text) + `</` + tag + `>`
+	}
+	return "<" + tag + ">" + html.EscapeString(text) + "</" + tag + ">"
+}
+
+// 演算子として扱う記号
+var operatorChars = map[string]string{
+	"+": "+", "-": "−", "*": "∗", "/": "/", "=": "=", "<": "<", ">": ">", ",": ",", ";": ";", ":": ":",
+	"!": "!", "?": "?", "(": "(", ")": ")", "[": "[", "]": "]", "|": "|", ".": ".",
+}
+
+func (p *parser) parseAtom(tok string) (item, error) {
+	if tok == "{" {
+		ml, err := p.parseGroup()
+		return item{ml: ml}, err
+	}
+	if strings.HasPrefix(tok, `\`) {
+		return p.parseCommand(tok)
+	}
+	if op, ok := operatorChars[tok]; ok {
+		return item{ml: p.token("mo", op)}, nil
+	}
+	if r := []rune(tok)[0]; unicode.IsLetter(r) {
+		return item{ml: p.token("mi", tok)}, nil
+	}
+	return item{ml: p.token("mo", tok)}, nil
+}
+
+var greek = map[string]string{
+	"alpha": "α", "beta": "β", "gamma": "γ", "delta": "δ", "epsilon": "ϵ", "varepsilon": "ε", "zeta": "ζ",
+	"eta": "η", "theta": "θ", "vartheta": "ϑ", "iota": "ι", "kappa": "κ", "lambda": "λ", "mu": "μ", "nu": "ν",
+	"xi": "ξ", "pi": "π", "varpi": "ϖ", "rho": "ρ", "varrho": "ϱ", "sigma": "σ", "varsigma": "ς", "tau": "τ",
+	"upsilon": "υ", "phi": "ϕ", "varphi": "φ", "chi": "χ", "psi": "ψ", "omega": "ω",
+	"Gamma": "Γ", "Delta": "Δ", "Theta": "Θ", "Lambda": "Λ", "Xi": "Ξ", "Pi": "Π", "Sigma": "Σ",
+	"Upsilon": "Υ", "Phi": "Φ", "Psi": "Ψ", "Omega": "Ω",
+}
+
+// 識別子として扱う記号
+var identifiers = map[string]string{
+	"infty": "∞", "partial": "∂", "nabla": "∇", "emptyset": "∅", "varnothing": "∅", "hbar": "ℏ",
+	"ell": "ℓ", "Re": "ℜ", "Im": "ℑ", "aleph": "ℵ",
+}
+
+var operators = map[string]string{
+	"times": "×", "cdot": "⋅", "div": "÷", "pm": "±", "mp": "∓", "ast": "∗", "star": "⋆", "circ": "∘", "bullet": "∙",
+	"oplus": "⊕", "otimes": "⊗", "leq": "≤", "le": "≤", "geq": "≥", "ge": "≥", "neq": "≠", "ne": "≠",
+	"approx": "≈", "equiv": "≡", "sim": "∼", "simeq": "≃", "cong": "≅", "propto": "∝", "ll": "≪", "gg": "≫",
+	"in": "∈", "notin": "∉", "ni": "∋", "subset": "⊂", "subseteq": "⊆", "supset": "⊃", "supseteq": "⊇",
+	"cup": "∪", "cap": "∩", "setminus": "∖", "to": "→", "rightarrow": "→", "leftarrow": "←", "gets": "←",
+	"Rightarrow": "⇒", "Leftarrow": "⇐", "Leftrightarrow": "⇔", "leftrightarrow": "↔", "mapsto": "↦",
+	"implies": "⟹", "iff": "⟺", "forall": "∀", "exists": "∃", "neg": "¬", "lnot": "¬", "land": "∧", "lor": "∨",
+	"wedge": "∧", "vee": "∨", "cdots": "⋯", "ldots": "…", "dots": "…", "vdots": "⋮", "ddots": "⋱",
+	"perp": "⊥", "parallel": "∥", "mid": "∣", "angle": "∠", "prime": "′", "langle": "⟨", "rangle": "⟩",
+	"lfloor": "⌊", "rfloor": "⌋", "lceil": "⌈", "rceil": "⌉",
+	"{": "{", "}": "}", "|": "‖", "&": "&", "%": "%", "$": "$", "#": "#", "_": "_",
+}
+
+// 上下限を真下・真上に置く大型演算子と、添字の位置に置く積分記号
+var bigOperators = map[string]string{
+	"sum": "∑", "prod": "∏", "coprod": "∐", "bigcup": "⋃", "bigcap": "⋂", "bigoplus": "⨁", "bigotimes": "⨂",
+}
+
+var integrals = map[string]string{"int": "∫", "iint": "∬", "iiint": "∭", "oint": "∮"}
+
+// 立体で書く関数名。limitFunctions は \lim のように下に添字を置く
+var functions = map[string]bool{
+	"sin": true, "cos": true, "tan": true, "cot": true, "sec": true, "csc": true, "arcsin": true, "arccos": true,
+	"arctan": true, "sinh": true, "cosh": true, "tanh": true, "log": true, "ln": true, "lg": true, "exp": true,
+	"det": true, "dim": true, "arg": true, "deg": true, "gcd": true, "ker": true, "hom": true, "Pr": true,
+}
+
+var limitFunctions = map[string]bool{"lim": true, "max": true, "min": true, "sup": true, "inf": true, "limsup": true, "liminf": true}
+
+var spaces = map[string]string{
+	",": "0.167em", ":": "0.222em", ">": "0.222em", ";": "0.278em", " ": "0.333em", "quad": "1em", "qquad": "2em", "!": "-0.167em",
+}
+
+var fonts = map[string]string{
+	"mathrm": "normal", "mathbf": "bold", "mathit": "italic", "mathbb": "double-struck", "mathcal": "script",
+	"mathfrak": "fraktur", "mathsf": "sans-serif", "mathtt": "monospace", "boldsymbol": "bold-italic",
+}
+
+var accents = map[string]string{
+	"hat": "^", "widehat": "^", "bar": "¯", "overline": "¯", "vec": "→", "overrightarrow": "→",
+	"dot": "˙", "ddot": "¨", "tilde": "˜", "widetilde": "˜",
+}
+
+func (p *parser) parseCommand(tok string) (item, error) {
+	name := tok[1:]
+	if s, ok := greek[name]; ok {
+		if unicode.IsUpper([]rune(s)[0]) && p.variant == "" {
+			return item{ml: `<mi mathvariant="normal">` + s + `</mi>`}, nil
+		}
+		return item{ml: p.token("mi", s)}, nil
+	}
+	if s, ok := identifiers[name]; ok {
+		return item{ml: p.token("mi", s)}, nil
+	}
+	if s, ok := operators[name]; ok {
+		return item{ml: p.token("mo", s)}, nil
+	}
+	if s, ok := bigOperators[name]; ok {
+		return item{ml: "<mo>" + s + "</mo>", limits: true}, nil
+	}
+	if s, ok := integrals[name]; ok {
+		return item{ml: "<mo>" + s + "</mo>"}, nil
+	}
+	if functions[name] {
+		return item{ml: "<mi>" + name + "</mi>"}, nil
+	}
+	if limitFunctions[name] {
+		return item{ml: "<mo>" + name + "</mo>", limits: true}, nil
+	}
+	if w, ok := spaces[name]; ok {
+		return item{ml: `<mspace width="` + w + `"/>`}, nil
+	}
+	if v, ok := fonts[name]; ok {
+		saved := p.variant
+		p.variant = v
+		arg, err := p.parseArg()
+		p.variant = saved
+		return item{ml: arg}, err
+	}
+	if a, ok := accents[name]; ok {
+		arg, err := p.parseArg()
+		return item{ml: `<mover accent="true">` + arg + "<mo>" + a + "</mo></mover>"}, err
+	}
+	switch name {
+	case "frac", "dfrac", "tfrac", "binom":
+		num, err := p.parseArg()
+		if err != nil {
+			return item{}, err
+		}
+		den, err := p.parseArg()
+		if err != nil {
+			return item{}, err
+		}
+		if name == "binom" {
+			return item{ml: `<mrow><mo>(</mo><mfrac linethickness="0">` + num + den + `</mfrac><mo>)</mo></mrow>`}, nil
+		}
+		return item{ml: "<mfrac>" + num + den + "</mfrac>"}, nil
+	case "sqrt":
+		p.skipSpace()
+		var index string
+		if p.peek() == "[" {
+			p.next()
+			items, stop, err := p.parseList("]")
+			if err != nil {
+				return item{}, err
+			}
+			if stop != "]" {
+				return item{}, errors.New("texmath: missing ]")
+			}
+			p.next()
+			index = row(items)
+		}
+		arg, err := p.parseArg()
+		if err != nil {
+			return item{}, err
+		}
+		if index != "" {
+			return item{ml: "<mroot>" + arg + index + "</mroot>"}, nil
+		}
+		return item{ml: "<msqrt>" + arg + "</msqrt>"}, nil
+	case "underline":
+		arg, err := p.parseArg()
+		return item{ml: `<munder accentunder="true">` + arg + "<mo>_</mo></munder>"}, err
+	case "text", "textrm", "mbox":
+		text, err := p.rawGroup()
+		return item{ml: "<mtext>" + html.EscapeString(text) + "</mtext>"}, err
+	case "operatorname":
+		text, err := p.rawGroup()
+		return item{ml: "<mi>" + html.EscapeString(text) + "</mi>"}, err
+	case "left":
+		return p.parseFenced()
+	case "begin":
+		return p.parseEnvironment()
+	}
+	return item{}, fmt.Errorf("%w command: %s", ErrUnsupported, tok)
+}
+
+// delimiter: \left や \right に続く区切り記号 ("." なら何も置かない)
+func (p *parser) delimiter() (string, error) {
+	p.skipSpace()
+	tok := p.next()
+	switch {
+	case tok == ".":
+		return "", nil
+	case tok == "" || tok == "{" || tok == "}":
+		return "", errors.New("texmath: missing delimiter")
+	case strings.HasPrefix(tok, `\`):
+		if s, ok := operators[tok[1:]]; ok {
+			return `<mo fence="true">` + html.EscapeString(s) + `</mo>`, nil
+		}
+		return "", fmt.Errorf("%w delimiter: %s", ErrUnsupported, tok)
+	}
+	return `<mo fence="true">` + html.EscapeString(tok) + `</mo>`, nil
+}
+
+// parseFenced: \left( ... \right) を読む
+func (p *parser) parseFenced() (item, error) {
+	open, err := p.delimiter()
+	if err != nil {
+		return item{}, err
+	}
+	items, stop, err := p.parseList()
+	if err != nil {
+		return item{}, err
+	}
+	if stop != `\right` {
+		return item{}, errors.New(`texmath: missing \right`)
+	}
+	p.next()
+	closing, err := p.delimiter()
+	if err != nil {
+		return item{}, err
+	}
+	return item{ml: "<mrow>" + open + row(items) + closing + "</mrow>"}, nil
+}
+
+// 行列の環境と、両側に置く括弧
+var matrixFences = map[string][2]string{
+	"matrix": {"", ""}, "pmatrix": {"(", ")"}, "bmatrix": {"[", "]"}, "Bmatrix": {"{", "}"},
+	"vmatrix": {"|", "|"}, "Vmatrix": {"‖", "‖"}, "cases": {"{", ""},
+}
+
+// parseEnvironment: \begin{...} ... \end{...} の行列を読む。セルは &、行は \\ で区切る
+func (p *parser) parseEnvironment() (item, error) {
+	name, err := p.rawGroup()
+	if err != nil {
+		return item{}, err
+	}
+	fences, ok := matrixFences[name]
+	if !ok {
+		return item{}, fmt.Errorf("%w environment: %s", ErrUnsupported, name)
+	}
+	var rows [][]string
+	var cells []string
+	for {
+		items, stop, err := p.parseList()
+		if err != nil {
+			return item{}, err
+		}
+		cell := ""
+		if len(items) > 0 {
+			cell = row(items)
+		}
+		cells = append(cells, cell)
+		p.next()
+		switch stop {
+		case "&":
+			continue
+		case `\\`:
+			rows = append(rows, cells)
+			cells = nil
+			continue
+		case `\end`:
+			end, err := p.rawGroup()
+			if err != nil {
+				return item{}, err
+			}
+			if end != name {
+				return item{}, fmt.Errorf(`texmath: \begin{%s} ended by \end{%s}`, name, end)
+			}
+		default:
+			return item{}, fmt.Errorf(`texmath: missing \end{%s}`, name)
+		}
+		break
+	}
+	// 最後の \\ のあとの空の行は出力しない
+	if len(cells) > 1 || cells[0] != "" {
+		rows = append(rows, cells)
+	}
+	var b strings.Builder
+	b.WriteString("<mrow>")
+	if fences[0] != "" {
+		b.WriteString(`<mo fence="true">` + fences[0] + `</mo>`)
+	}
+	if name == "cases" {
+		b.WriteString(`<mtable columnalign="left">`)
+	} else {
+		b.WriteString("<mtable>")
+	}
+	for _, r := range rows {
+		b.WriteString("<mtr>")
+		for _, c := range r {
+			b.WriteString("<mtd>" + c + "</mtd>")
+		}
+		b.WriteString("</mtr>")
+	}
+	b.WriteString("</mtable>")
+	if fences[1] != "" {
+		b.WriteString(`<mo fence="true">` + fences[1] + `</mo>`)
+	}
+	b.WriteString("</mrow>")
+	return item{ml: b.String()}, nil
+}
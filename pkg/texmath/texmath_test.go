@@ -0,0 +1,96 @@
+package texmath
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// body: <math> の中身だけを返す
+func body(t *testing.T, expr string) string {
+	t.Helper()
+	got, err := ToMathML(expr)
+	if err != nil {
+		t.Fatalf("ToMathML(%q): %v", expr, err)
+	}
+	start := strings.Index(got, ">") + 1
+	return strings.TrimSuffix(got[start:], "</math>")
+}
+
+func TestToMathML(t *testing.T) {
+	cases := []struct {
+		name string
+		expr string
+		want string
+	}{
+		{"identifiers and numbers", "E=mc^2", `<mrow><mi>E</mi><mo>=</mo><mi>m</mi><msup><mi>c</mi><mn>2</mn></msup></mrow>`},
+		{"decimal", "3.14 + x_1", `<mrow><mn>3.14</mn><mo>+</mo><msub><mi>x</mi><mn>1</mn></msub></mrow>`},
+		{"script takes one digit", "x^10", `<mrow><msup><mi>x</mi><mn>1</mn></msup><mn>0</mn></mrow>`},
+		{"sub and sup", "x_i^{n+1}", `<msubsup><mi>x</mi><mi>i</mi><mrow><mi>n</mi><mo>+</mo><mn>1</mn></mrow></msubsup>`},
+		{"fraction", `\frac{a+b}{2}`, `<mfrac><mrow><mi>a</mi><mo>+</mo><mi>b</mi></mrow><mn>2</mn></mfrac>`},
+		{"binomial", `\binom n k`, `<mrow><mo>(</mo><mfrac linethickness="0"><mi>n</mi><mi>k</mi></mfrac><mo>)</mo></mrow>`},
+		{"greek", `\alpha + \Omega`, `<mrow><mi>α</mi><mo>+</mo><mi mathvariant="normal">Ω</mi></mrow>`},
+		{"sqrt", `\sqrt{x} \sqrt[3]{y}`, `<mrow><msqrt><mi>x</mi></msqrt><mroot><mi>y</mi><mn>3</mn></mroot></mrow>`},
+		{"sum with limits", `\sum_{i=1}^n i`, `<mrow><munderover><mo>∑</mo><mrow><mi>i</mi><mo>=</mo><mn>1</mn></mrow><mi>n</mi></munderover><mi>i</mi></mrow>`},
+		{"sum nolimits", `\sum\nolimits_i`, `<msub><mo>∑</mo><mi>i</mi></msub>`},
+		{"integral", `\int_0^\infty e^{-x}\,dx`, `<mrow><msubsup><mo>∫</mo><mn>0</mn><mi>∞</mi></msubsup><msup><mi>e</mi><mrow><mo>−</mo><mi>x</mi></mrow></msup><mspace width="0.167em"/><mi>d</mi><mi>x</mi></mrow>`},
+		{"lim", `\lim_{x\to0}\sin x`, `<mrow><munder><mo>lim</mo><mrow><mi>x</mi><mo>→</mo><mn>0</mn></mrow></munder><mi>sin</mi><mi>x</mi></mrow>`},
+		{"operators", `a \times b \leq c \neq d \pm e`, `<mrow><mi>a</mi><mo>×</mo><mi>b</mi><mo>≤</mo><mi>c</mi><mo>≠</mo><mi>d</mi><mo>±</mo><mi>e</mi></mrow>`},
+		{"escape", `a<b \& c`, `<mrow><mi>a</mi><mo>&lt;</mo><mi>b</mi><mo>&amp;</mo><mi>c</mi></mrow>`},
+		{"prime", `f''(x)`, `<mrow><msup><mi>f</mi><mo>′′</mo></msup><mo>(</mo><mi>x</mi><mo>)</mo></mrow>`},
+		{"fenced", `\left[\frac12\right.`, `<mrow><mo fence="true">[</mo><mfrac><mn>1</mn><mn>2</mn></mfrac></mrow>`},
+		{"fenced norm", `\left\|v\right\|`, `<mrow><mo fence="true">‖</mo><mi>v</mi><mo fence="true">‖</mo></mrow>`},
+		{"text", `x \text{ if } x>0`, `<mrow><mi>x</mi><mtext> if </mtext><mi>x</mi><mo>&gt;</mo><mn>0</mn></mrow>`},
+		{"font", `\mathbf{v}+\mathbb R`, `<mrow><mi mathvariant="bold">v</mi><mo>+</mo><mi mathvariant="double-struck">R</mi></mrow>`},
+		{"accent", `\vec{v}`, `<mover accent="true"><mi>v</mi><mo>→</mo></mover>`},
+		{"operatorname", `\operatorname{rank} A`, `<mrow><mi>rank</mi><mi>A</mi></mrow>`},
+		{"matrix", `\begin{pmatrix}1&0\\0&1\end{pmatrix}`,
+			`<mrow><mo fence="true">(</mo><mtable><mtr><mtd><mn>1</mn></mtd><mtd><mn>0</mn></mtd></mtr><mtr><mtd><mn>0</mn></mtd><mtd><mn>1</mn></mtd></mtr></mtable><mo fence="true">)</mo></mrow>`},
+		{"cases", `\begin{cases}1&x>0\\0&\\\end{cases}`,
+			`<mrow><mo fence="true">{</mo><mtable columnalign="left"><mtr><mtd><mn>1</mn></mtd><mtd><mrow><mi>x</mi><mo>&gt;</mo><mn>0</mn></mrow></mtd></mtr><mtr><mtd><mn>0</mn></mtd><mtd></mtd></mtr></mtable></mrow>`},
+		{"unicode", "日本", `<mrow><mi>日</mi><mi>本</mi></mrow>`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := body(t, c.expr); got != c.want {
+				t.Errorf("ToMathML(%q)\n got %s\nwant %s", c.expr, got, c.want)
+			}
+		})
+	}
+}
+
+func TestToMathMLAltText(t *testing.T) {
+	got, err := ToMathML(`a<b`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `<math xmlns="http://www.w3.org/1998/Math/MathML" alttext="a&lt;b">`; !strings.HasPrefix(got, want) {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestToDisplayMathML(t *testing.T) {
+	got, err := ToDisplayMathML("x^2\n+ 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<math xmlns="http://www.w3.org/1998/Math/MathML" display="block" alttext="x^2` + "\n" + `+ 1"><mrow><msup><mi>x</mi><mn>2</mn></msup><mo>+</mo><mn>1</mn></mrow></math>`
+	if got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestToMathMLErrors(t *testing.T) {
+	unsupported := []string{`\foo`, `\frac{\unknown}{2}`, `\begin{align}x\end{align}`, `\left\foo x\right)`, `\`}
+	for _, expr := range unsupported {
+		if _, err := ToMathML(expr); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("ToMathML(%q) err = %v, want ErrUnsupported", expr, err)
+		}
+	}
+	invalid := []string{"{x", "x}", "a^b^c", `\frac{1}`, `\sqrt[3{x}`, `\left(x`, `\begin{matrix}1`, `\begin{matrix}1\end{pmatrix}`, "a & b", `a \\ b`, "x^"}
+	for _, expr := range invalid {
+		if got, err := ToMathML(expr); err == nil {
+			t.Errorf("ToMathML(%q) = %s, want error", expr, got)
+		}
+	}
+}
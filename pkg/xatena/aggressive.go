@@ -0,0 +1,183 @@
+package xatena
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Text::Xatena::Inline::Aggressive 相当のインライン記法
+// はてなダイアリーの [google:...] や id:user などを、サービスごとの URL テンプレートでリンクにする
+
+// NewAggressiveInlineFormatter が追加するインライン記法の名前
+const (
+	InlineGoogle    = "google"    // [google:...], [google:images:...], [google:news:...]
+	InlineAmazon    = "amazon"    // [amazon:...]
+	InlineWikipedia = "wikipedia" // [wikipedia:...], [wikipedia:en:...]
+	InlineISBN      = "isbn"      // [isbn:...], [asin:...]
+	InlineKeyword   = "keyword"   // [keyword:...]
+	InlineID        = "id"        // id:user, id:user:20060102
+)
+
+// AggressiveURLs はサービスごとのリンク先の URL テンプレート。空の項目は DefaultAggressiveURLs の値を使う
+// {word} は記法の語で、検索 (Google・Amazon) ではクエリとして、それ以外ではパスとしてエスケープする
+type AggressiveURLs struct {
+	Google        string
+	GoogleImages  string
+	GoogleNews    string
+	Amazon        string
+	Wikipedia     string // {lang} は言語
+	WikipediaLang string // [wikipedia:...] で言語を省略したときの言語
+	ASIN          string // {word} は ASIN。ISBN-13 (978) は ISBN-10 に直す
+	Keyword       string
+	ID            string // {word} はユーザー名
+	IDDiary       string // {word} はユーザー名、{date} は日付 (YYYYMMDD)
+}
+
+// DefaultAggressiveURLs: 既定のリンク先
+func DefaultAggressiveURLs() AggressiveURLs {
+	return AggressiveURLs{
+		Google:        "https://www.google.com/search?q={word}&ie=utf-8&oe=utf-8",
+		GoogleImages:  "https://www.google.com/search?q={word}&tbm=isch",
+		GoogleNews:    "https://news.google.com/search?q={word}",
+		Amazon:        "https://www.amazon.co.jp/s?k={word}",
+		Wikipedia:     "https://{lang}.wikipedia.org/wiki/{word}",
+		WikipediaLang: "ja",
+		ASIN:          "https://www.amazon.co.jp/dp/{word}",
+		Keyword:       "https://d.hatena.ne.jp/keyword/{word}",
+		ID:            "https://profile.hatena.ne.jp/{word}/",
+		IDDiary:       "https://d.hatena.ne.jp/{word}/{date}",
+	}
+}
+
+// withDefaults: 空の項目を既定値で埋める
+func (u AggressiveURLs) withDefaults() AggressiveURLs {
+	d := DefaultAggressiveURLs()
+	for _, p := range []struct{ v, def *string }{
+		{&u.Google, &d.Google}, {&u.GoogleImages, &d.GoogleImages}, {&u.GoogleNews, &d.GoogleNews},
+		{&u.Amazon, &d.Amazon}, {&u.Wikipedia, &d.Wikipedia}, {&u.WikipediaLang, &d.WikipediaLang},
+		{&u.ASIN, &d.ASIN}, {&u.Keyword, &d.Keyword}, {&u.ID, &d.ID}, {&u.IDDiary, &d.IDDiary},
+	} {
+		if *p.v == "" {
+			*p.v = *p.def
+		}
+	}
+	return u
+}
+
+// aggressiveTitleCacheSize は NewAggressiveInlineFormatter が :title のタイトルを覚えておく件数
+const aggressiveTitleCacheSize = 1000
+
+// NewAggressiveInlineFormatter: 組み込みの記法に加えて、はてなダイアリーの記法をリンクにする InlineFormatter を作る
+// Perl 版と同じく [url:title] で取得したタイトルはメモリに保存して使い回す (SetTitleCache で差し替えられる)
+func NewAggressiveInlineFormatter(urls AggressiveURLs, opts ...func(*InlineFormatter)) *InlineFormatter {
+	f := NewInlineFormatter(func(f *InlineFormatter) {
+		f.SetTitleCache(NewLRUTitleCache(aggressiveTitleCacheSize))
+	})
+	// 括弧のない URL より前に置き、[google:http://...] などを URL として扱わない
+	autolink := len(f.rules)
+	for i, r := range f.rules {
+		if r.Name == InlineAutoLink {
+			autolink = i
+			break
+		}
+	}
+	rules := append([]InlineRule{}, f.rules[:autolink]...)
+	rules = append(rules, aggressiveInlineRules(urls.withDefaults())...)
+	f.rules = append(rules, f.rules[autolink:]...)
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
+}
+
+func aggressiveInlineRules(u AggressiveURLs) []InlineRule {
+	link := func(tmpl, word, title string, vars ...string) InlineNode {
+		r := strings.NewReplacer(append([]string{"{word}", word}, vars...)...)
+		return &Link{URL: r.Replace(tmpl), Title: title}
+	}
+	return []InlineRule{
+		{
+			Name:    InlineGoogle,
+			Pattern: regexp.MustCompile(`\[google:(?:(images|news):)?([^\]]+)\]`),
+			Node: func(m []string) InlineNode {
+				tmpl := u.Google
+				switch m[1] {
+				case "images":
+					tmpl = u.GoogleImages
+				case "news":
+					tmpl = u.GoogleNews
+				}
+				return link(tmpl, url.QueryEscape(m[2]), m[0][1:len(m[0])-1])
+			},
+		},
+		{
+			Name:    InlineAmazon,
+			Pattern: regexp.MustCompile(`\[amazon:([^\]]+)\]`),
+			Node: func(m []string) InlineNode {
+				return link(u.Amazon, url.QueryEscape(m[1]), m[0][1:len(m[0])-1])
+			},
+		},
+		{
+			Name:    InlineWikipedia,
+			Pattern: regexp.MustCompile(`\[wikipedia:(?:([a-z]{2,3}(?:-[a-z]+)?):)?([^\]]+)\]`),
+			Node: func(m []string) InlineNode {
+				lang := m[1]
+				if lang == "" {
+					lang = u.WikipediaLang
+				}
+				word := url.PathEscape(strings.ReplaceAll(m[2], " ", "_"))
+				return link(u.Wikipedia, word, m[0][1:len(m[0])-1], "{lang}", lang)
+			},
+		},
+		{
+			Name:    InlineISBN,
+			Pattern: regexp.MustCompile(`\[(isbn|asin):([0-9A-Za-z-]+)\]`),
+			Node: func(m []string) InlineNode {
+				code := strings.ToUpper(strings.ReplaceAll(m[2], "-", ""))
+				if m[1] == "isbn" {
+					code = isbn10(code)
+				}
+				return link(u.ASIN, url.PathEscape(code), m[0][1:len(m[0])-1])
+			},
+		},
+		{
+			Name:    InlineKeyword,
+			Pattern: regexp.MustCompile(`\[keyword:([^\]]+)\]`),
+			Node: func(m []string) InlineNode {
+				return link(u.Keyword, url.PathEscape(m[1]), m[1])
+			},
+		},
+		{
+			Name:    InlineID,
+			Pattern: regexp.MustCompile(`\bid:([A-Za-z][A-Za-z0-9_-]{1,30}[A-Za-z0-9])(?::([0-9]{8}))?`),
+			Node: func(m []string) InlineNode {
+				if m[2] != "" {
+					return link(u.IDDiary, url.PathEscape(m[1]), m[0], "{date}", m[2])
+				}
+				return link(u.ID, url.PathEscape(m[1]), m[0])
+			},
+		},
+	}
+}
+
+// isbn10: 978 で始まる ISBN-13 を ISBN-10 に直す。それ以外はそのまま返す
+func isbn10(isbn string) string {
+	if len(isbn) != 13 || !strings.HasPrefix(isbn, "978") {
+		return isbn
+	}
+	body := isbn[3:12]
+	sum := 0
+	for i, c := range body {
+		if c < '0' || c > '9' {
+			return isbn
+		}
+		sum += (10 - i) * int(c-'0')
+	}
+	switch check := (11 - sum%11) % 11; check {
+	case 10:
+		return body + "X"
+	default:
+		return body + string(rune('0'+check))
+	}
+}
@@ -0,0 +1,66 @@
+package xatena
+
+import (
+	"fmt"
+	"html"
+
+	"github.com/cho45/xatena-go/pkg/qrcode"
+)
+
+// [url:barcode] の QR コードは外部のサービスを使わずにその場で生成する
+
+const DefaultBarcodeSize = 150
+
+// BarcodeOptions は [url:barcode] で出力する QR コードの設定
+type BarcodeOptions struct {
+	Size   int          // 1辺のピクセル数 (0 なら DefaultBarcodeSize)
+	Level  qrcode.Level // 誤り訂正レベル (ゼロ値は qrcode.Low なので、既定の M にするには DefaultBarcodeOptions を使う)
+	Inline bool         // true なら <svg> を直接埋め込む。false なら data: URI の <img> にする
+}
+
+// DefaultBarcodeOptions: 150px、誤り訂正レベル M の <img>
+func DefaultBarcodeOptions() BarcodeOptions {
+	return BarcodeOptions{Size: DefaultBarcodeSize, Level: qrcode.Medium}
+}
+
+func (o BarcodeOptions) size() int {
+	if o.Size <= 0 {
+		return DefaultBarcodeSize
+	}
+	return o.Size
+}
+
+// SetBarcodeOptions: [url:barcode] で出力する QR コードの大きさ・誤り訂正レベル・形式を変える
+func (f *InlineFormatter) SetBarcodeOptions(o BarcodeOptions) {
+	f.barcode = &o
+}
+
+func (f *InlineFormatter) barcodeOptions() BarcodeOptions {
+	if f.barcode == nil {
+		return DefaultBarcodeOptions()
+	}
+	return *f.barcode
+}
+
+// renderBarcode: uri の QR コードを HTML にする。uri は title (inline なら <title>) に入れる
+// 型番 40 にも収まらない長さの URL は、QR コードの代わりにリンクにする
+func renderBarcode(o BarcodeOptions, uri string) string {
+	code, err := qrcode.Encode([]byte(uri), o.Level)
+	if err != nil {
+		return fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(uri), html.EscapeString(uri))
+	}
+	if o.Inline {
+		return code.SVG(o.size(), "barcode", uri)
+	}
+	return fmt.Sprintf(`<img class="barcode" src="%s" width="%d" height="%d" title="%s" alt="%s"/>`,
+		code.DataURI(o.size()), o.size(), o.size(), html.EscapeString(uri), html.EscapeString(uri))
+}
+
+// barcodeDataURI: uri の QR コードの data: URI (Markdown の画像に使う)。生成できなければ空
+func barcodeDataURI(o BarcodeOptions, uri string) string {
+	code, err := qrcode.Encode([]byte(uri), o.Level)
+	if err != nil {
+		return ""
+	}
+	return code.DataURI(o.size())
+}
@@ -0,0 +1,121 @@
+package xatena
+
+import (
+	"strings"
+
+	"github.com/cho45/xatena-go/internal/syntax"
+)
+
+// ブロックパーサを試す前の振り分け
+// TriggeredBlockParser が宣言した行頭の文字列は先頭のバイトで引けるようにしておき、
+// 行ごとに反応しうるパーサだけを登録順に試す。普通の段落の行では正規表現を1つも実行しない
+
+type blockDispatch struct {
+	parsers  []syntax.BlockParser
+	triggers []*syntax.BlockTriggers // parsers[i] の宣言 (nil ならすべての行で試す)
+	byFirst  [256][]int              // 行頭の文字列を宣言したパーサの添字を、その先頭のバイトで引く
+	byLast   [256][]int              // 行末の文字列を宣言したパーサの添字を、その末尾のバイトで引く
+	others   []int                   // 部分文字列を宣言したパーサと、宣言していないパーサの添字
+}
+
+func newBlockDispatch(parsers []namedBlockParser) *blockDispatch {
+	d := &blockDispatch{
+		parsers:  make([]syntax.BlockParser, len(parsers)),
+		triggers: make([]*syntax.BlockTriggers, len(parsers)),
+	}
+	for i, p := range parsers {
+		d.parsers[i] = p.parser
+		tp, ok := p.parser.(syntax.TriggeredBlockParser)
+		if !ok {
+			d.others = append(d.others, i)
+			continue
+		}
+		t := tp.Triggers()
+		if hasEmpty(t.Prefixes) || hasEmpty(t.Suffixes) || hasEmpty(t.Substrings) {
+			d.others = append(d.others, i)
+			continue
+		}
+		d.triggers[i] = &t
+		for _, prefix := range t.Prefixes {
+			d.byFirst[prefix[0]] = appendIndex(d.byFirst[prefix[0]], i)
+		}
+		for _, suffix := range t.Suffixes {
+			b := suffix[len(suffix)-1]
+			d.byLast[b] = appendIndex(d.byLast[b], i)
+		}
+		if len(t.Substrings) > 0 {
+			d.others = append(d.others, i)
+		}
+	}
+	return d
+}
+
+func hasEmpty(ss []string) bool {
+	for _, s := range ss {
+		if s == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// appendIndex: 昇順の indexes の末尾に i がなければ追加する
+func appendIndex(indexes []int, i int) []int {
+	if len(indexes) > 0 && indexes[len(indexes)-1] == i {
+		return indexes
+	}
+	return append(indexes, i)
+}
+
+// candidates: line で試すパーサの添字を、登録順に buf へ追加して返す
+func (d *blockDispatch) candidates(line string, buf []int) []int {
+	start := len(buf)
+	if line != "" {
+		buf = append(buf, d.byFirst[line[0]]...)
+		buf = append(buf, d.byLast[line[len(line)-1]]...)
+	}
+	buf = append(buf, d.others...)
+	if len(buf) == start {
+		return buf
+	}
+	// 数個しかないので挿入ソートで登録順に並べ、重複と宣言に当てはまらないものを除く
+	c := buf[start:]
+	for i := 1; i < len(c); i++ {
+		for j := i; j > 0 && c[j] < c[j-1]; j-- {
+			c[j], c[j-1] = c[j-1], c[j]
+		}
+	}
+	n := start
+	for k, i := range c {
+		if k > 0 && i == c[k-1] {
+			continue
+		}
+		if d.accepts(i, line) {
+			buf[n] = i
+			n++
+		}
+	}
+	return buf[:n]
+}
+func (d *blockDispatch) accepts(i int, line string) bool {
+	t := d.triggers[i]
+	if t == nil {
+		return true
+	}
+	for _, prefix := range t.Prefixes {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	for _, suffix := range t.Suffixes {
+		if strings.HasSuffix(line, suffix) {
+			return true
+		}
+	}
+	for _, sub := range t.Substrings {
+		if strings.Contains(line, sub) {
+			return true
+		}
+	}
+	return false
+}
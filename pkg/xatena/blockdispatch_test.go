@@ -0,0 +1,106 @@
+package xatena
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cho45/xatena-go/internal/syntax"
+)
+
+// untriggered は Triggers を隠して、すべての行で CanHandle を呼ばせる (以前の振り分けと同じ)
+type untriggered struct {
+	BlockParser
+}
+
+func newLinearXatena() *Xatena {
+	x := NewXatena()
+	for _, p := range x.blockParsers {
+		x.ReplaceBlockParser(p.name, untriggered{p.parser})
+	}
+	return x
+}
+
+func TestBlockDispatchCandidates(t *testing.T) {
+	x := NewXatena()
+	names := func(line string) []string {
+		var got []string
+		for _, i := range x.dispatch.candidates(line, nil) {
+			got = append(got, x.blockParsers[i].name)
+		}
+		return got
+	}
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"plain text", nil},
+		{"", nil},
+		{"* title", []string{BlockSection}},
+		{">|perl|", []string{BlockSuperPre, BlockStopP, BlockBlockquote, BlockPre}},
+		{">>", []string{BlockStopP, BlockBlockquote}},
+		{"foo|<", []string{BlockStopP, BlockPre}},
+		{"a <!-- b", []string{BlockComment}},
+		{"- item <!-- c", []string{BlockList, BlockComment}},
+	}
+	for _, tt := range tests {
+		if got := names(tt.line); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("candidates(%q) = %v, want %v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestBlockDispatchSameAsLinear(t *testing.T) {
+	ctx := context.Background()
+	x := NewXatena()
+	linear := newLinearXatena()
+	for _, input := range append(allTestInputs(), plainProse(20)) {
+		got, _ := x.Parse(ctx, input)
+		want, _ := linear.Parse(ctx, input)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("input %q: parse differs", input)
+		}
+	}
+}
+
+func TestBlockDispatchUntriggeredParser(t *testing.T) {
+	x := NewXatena()
+	// Triggers を宣言していないパーサはすべての行で試される
+	if err := x.InsertBlockParserBefore(BlockSection, "upper", untriggered{&syntax.SectionParser{}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := x.dispatch.candidates("plain", nil); !reflect.DeepEqual(got, []int{x.blockParserIndex("upper")}) {
+		t.Errorf("candidates = %v", got)
+	}
+}
+
+// plainProse: 記法を含まない段落だけの文書
+func plainProse(paragraphs int) string {
+	var b strings.Builder
+	for i := 0; i < paragraphs; i++ {
+		fmt.Fprintf(&b, "This is paragraph %d of a long article without any notation.\n", i)
+		b.WriteString("Most lines in real documents are plain prose like this one, written line by line.\n")
+		b.WriteString("日本語の文章もそのまま段落になり、ブロック記法のパーサは反応しない。\n\n")
+	}
+	return b.String()
+}
+
+func benchmarkParse(b *testing.B, x *Xatena, input string) {
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		x.Parse(ctx, input)
+	}
+}
+
+func BenchmarkParsePlainProse(b *testing.B) {
+	benchmarkParse(b, NewXatena(), plainProse(2000))
+}
+
+func BenchmarkParsePlainProseLinear(b *testing.B) {
+	benchmarkParse(b, newLinearXatena(), plainProse(2000))
+}
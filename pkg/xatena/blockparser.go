@@ -0,0 +1,141 @@
+package xatena
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/cho45/xatena-go/internal/syntax"
+)
+
+// ブロック記法を外部から追加するための型。internal/syntax の型を公開名で参照できるようにする
+// BlockParser.Parse は scanner から行を読み、作ったノードを parent に追加する。
+// 子を持つブロックは stack に積み、閉じる行で取り除く (組み込みの BlockquoteParser などと同じ)
+type (
+	BlockParser   = syntax.BlockParser
+	LineScanner   = syntax.LineScanner
+	XatenaContext = syntax.XatenaContext
+	CallerOptions = syntax.CallerOptions
+	HTMLWriter    = syntax.HTMLWriter
+
+	// TriggeredBlockParser は反応する行頭・行末・部分文字列を宣言し、それ以外の行では呼ばれない BlockParser
+	TriggeredBlockParser = syntax.TriggeredBlockParser
+	BlockTriggers        = syntax.BlockTriggers
+)
+
+// 組み込みのブロック記法の名前 (InsertBlockParserBefore での位置の指定や WithoutExtensions に使う)
+const (
+	BlockSeeMore        = "seemore"
+	BlockSuperPre       = "superpre"
+	BlockStopP          = "stopp"
+	BlockBlockquote     = "blockquote"
+	BlockPre            = "pre"
+	BlockList           = "list"
+	BlockDefinitionList = "definitionlist"
+	BlockTable          = "table"
+	BlockSection        = "section"
+	BlockComment        = "comment"
+)
+
+type namedBlockParser struct {
+	name   string
+	parser syntax.BlockParser
+}
+
+// BlockParserNames: 登録されている BlockParser の名前を試す順に返す
+func (x *Xatena) BlockParserNames() []string {
+	names := make([]string, len(x.blockParsers))
+	for i, p := range x.blockParsers {
+		names[i] = p.name
+	}
+	return names
+}
+
+func (x *Xatena) blockParserIndex(name string) int {
+	for i, p := range x.blockParsers {
+		if p.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// 以下の変更は ToHTML などを呼ぶ前に行うこと (レンダリング中の変更は保護しない)
+
+// AddBlockParser: BlockParser を最後に追加する
+func (x *Xatena) AddBlockParser(name string, parser BlockParser) error {
+	return x.insertBlockParser(len(x.blockParsers), name, parser)
+}
+
+// InsertBlockParserBefore: before という名前の BlockParser の直前に追加する
+func (x *Xatena) InsertBlockParserBefore(before, name string, parser BlockParser) error {
+	i := x.blockParserIndex(before)
+	if i < 0 {
+		return fmt.Errorf("block parser not found: %s", before)
+	}
+	return x.insertBlockParser(i, name, parser)
+}
+
+// InsertBlockParserAfter: after という名前の BlockParser の直後に追加する
+func (x *Xatena) InsertBlockParserAfter(after, name string, parser BlockParser) error {
+	i := x.blockParserIndex(after)
+	if i < 0 {
+		return fmt.Errorf("block parser not found: %s", after)
+	}
+	return x.insertBlockParser(i+1, name, parser)
+}
+
+func (x *Xatena) insertBlockParser(i int, name string, parser BlockParser) error {
+	if x.blockParserIndex(name) >= 0 {
+		return fmt.Errorf("block parser already registered: %s", name)
+	}
+	parsers := make([]namedBlockParser, 0, len(x.blockParsers)+1)
+	parsers = append(parsers, x.blockParsers[:i]...)
+	parsers = append(parsers, namedBlockParser{name, parser})
+	x.setBlockParsers(append(parsers, x.blockParsers[i:]...))
+	return nil
+}
+
+// ReplaceBlockParser: name という名前の BlockParser を置き換える (順番は変わらない)
+func (x *Xatena) ReplaceBlockParser(name string, parser BlockParser) error {
+	i := x.blockParserIndex(name)
+	if i < 0 {
+		return fmt.Errorf("block parser not found: %s", name)
+	}
+	parsers := append([]namedBlockParser(nil), x.blockParsers...)
+	parsers[i].parser = parser
+	x.setBlockParsers(parsers)
+	return nil
+}
+
+// RemoveBlockParser: name という名前の BlockParser を取り除く
+func (x *Xatena) RemoveBlockParser(name string) error {
+	i := x.blockParserIndex(name)
+	if i < 0 {
+		return fmt.Errorf("block parser not found: %s", name)
+	}
+	parsers := make([]namedBlockParser, 0, len(x.blockParsers)-1)
+	parsers = append(parsers, x.blockParsers[:i]...)
+	x.setBlockParsers(append(parsers, x.blockParsers[i+1:]...))
+	return nil
+}
+
+func (x *Xatena) setBlockParsers(parsers []namedBlockParser) {
+	x.blockParsers = parsers
+	x.dispatch = newBlockDispatch(parsers)
+}
+
+// ContentToHTML: 子を持つノードの中身を HTML 化する。独自ノードの ToHTML から使う
+func ContentToHTML(r HasContent, ctx context.Context, xatena XatenaContext, options CallerOptions) string {
+	return syntax.ContentToHTML(r, ctx, xatena, options)
+}
+
+// WriteContent: 子を持つノードの中身を w へ書き出す。独自ノードの WriteHTML から使う
+func WriteContent(r HasContent, ctx context.Context, w io.Writer, xatena XatenaContext, options CallerOptions) error {
+	return syntax.WriteContent(r, ctx, w, xatena, options)
+}
+
+// SourcePos: SourcePos オプションが有効なとき、独自ノードのテンプレートに渡す data-sourcepos の値を返す
+func SourcePos(xatena XatenaContext, p Position) string {
+	return syntax.SourcePos(xatena, p)
+}
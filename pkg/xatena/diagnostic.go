@@ -0,0 +1,71 @@
+package xatena
+
+import (
+	"context"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/cho45/xatena-go/internal/syntax"
+)
+
+type (
+	Diagnostic = syntax.Diagnostic
+	Severity   = syntax.Severity
+)
+
+const (
+	SeverityError   = syntax.SeverityError
+	SeverityWarning = syntax.SeverityWarning
+)
+
+// ToHTMLWithDiagnostics: ToHTML と同じ HTML に加えて、閉じられていないブロック・対応のない閉じ記号・
+// テンプレートのエラー・未知のインライン記法などの診断を行番号順で返す
+// Limits の上限を超えたり ctx が終了した場合は、その理由もエラーの診断として返す
+func (x *Xatena) ToHTMLWithDiagnostics(ctx context.Context, input string) (string, []Diagnostic) {
+	doc, err := x.parseXatena(ctx, input)
+	if err != nil {
+		return "", []Diagnostic{limitDiagnostic(err)}
+	}
+	session := x.newRenderSession()
+	ctx, done := x.beginRender(syntax.WithDiagnosticReporter(ctx, session.report), doc)
+	var sb strings.Builder
+	if err := done(session.writeDocument(ctx, &sb, doc)); err != nil {
+		session.report(limitDiagnostic(err))
+	}
+
+	diagnostics := make([]Diagnostic, 0, len(doc.Diagnostics)+len(session.diagnostics))
+	diagnostics = append(diagnostics, doc.Diagnostics...)
+	diagnostics = append(diagnostics, session.diagnostics...)
+	// 行番号順。位置不明 (0) のものは最後に回す
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		li, lj := diagnostics[i].Line, diagnostics[j].Line
+		if li == 0 || lj == 0 {
+			return lj == 0 && li != 0
+		}
+		return li < lj
+	})
+	return sb.String(), diagnostics
+}
+
+func (s *renderSession) report(d Diagnostic) {
+	s.diagnostics = append(s.diagnostics, d)
+}
+
+// WriteTemplate: テンプレートのエラーを診断として記録する
+func (s *renderSession) WriteTemplate(w io.Writer, name string, params map[string]interface{}) error {
+	tmplErr, err := s.writeTemplate(w, name, params)
+	if tmplErr != nil {
+		s.report(Diagnostic{Severity: SeverityError, Message: "template " + name + ": " + tmplErr.Error()})
+	}
+	return err
+}
+
+// ExecuteTemplate: テンプレートのエラーを診断として記録する
+func (s *renderSession) ExecuteTemplate(name string, params map[string]interface{}) string {
+	html, tmplErr := s.executeTemplate(name, params)
+	if tmplErr != nil {
+		s.report(Diagnostic{Severity: SeverityError, Message: "template " + name + ": " + tmplErr.Error()})
+	}
+	return html
+}
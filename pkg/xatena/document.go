@@ -0,0 +1,231 @@
+package xatena
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/cho45/xatena-go/internal/syntax"
+)
+
+// パース結果のノード型。internal/syntax の型を公開名で参照できるようにする
+type (
+	Node           = syntax.Node
+	HasContent     = syntax.HasContent
+	Text           = syntax.TextNode
+	Section        = syntax.SectionNode
+	List           = syntax.ListNode
+	ListStruct     = syntax.ListStructNode
+	ListItem       = syntax.ListItemNode
+	Table          = syntax.TableNode
+	TableCell      = syntax.TableCellNode
+	DefinitionList = syntax.DefinitionListNode
+	DefinitionItem = syntax.DefinitionItemNode
+	Blockquote     = syntax.BlockquoteNode
+	SuperPre       = syntax.SuperPreNode
+	LineRange      = syntax.LineRange
+	Pre            = syntax.PreNode
+	StopP          = syntax.StopPNode
+	SeeMore        = syntax.SeeMoreNode
+	Comment        = syntax.CommentNode
+	Position       = syntax.Position
+	Positioned     = syntax.Positioned
+)
+
+// インライン記法をパースした結果の要素
+type (
+	InlineNode  = syntax.InlineNode
+	InlinePos   = syntax.InlinePos
+	InlineText  = syntax.InlineTextNode
+	Link        = syntax.LinkNode
+	FootnoteRef = syntax.FootnoteRefNode
+	RawHTML     = syntax.RawHTMLNode
+	HTMLComment = syntax.HTMLCommentNode
+	Tex         = syntax.TexNode
+	MailTo      = syntax.MailToNode
+	Barcode     = syntax.BarcodeNode
+)
+
+// Document はパース済みの文書ツリーのルート
+// Content を書き換えてから Render/RenderDocument に渡すこともできる
+type Document struct {
+	Content     []Node
+	Diagnostics []Diagnostic // パース中に見つかった問題 (閉じられていないブロックなど)
+}
+
+func (d *Document) AddChild(n Node) {
+	d.Content = append(d.Content, n)
+}
+
+func (d *Document) GetContent() []Node {
+	return d.Content
+}
+
+func (d *Document) ToHTML(ctx context.Context, xatena syntax.XatenaContext, options syntax.CallerOptions) string {
+	return syntax.ContentToHTML(d, ctx, xatena, options)
+}
+
+func (d *Document) WriteHTML(ctx context.Context, w io.Writer, xatena syntax.XatenaContext, options syntax.CallerOptions) error {
+	return syntax.WriteContent(d, ctx, w, xatena, options)
+}
+
+// Walk は n 以下のノードを深さ優先で辿り、各ノードについて fn を呼ぶ
+// fn が false を返すとそのノードの子は辿らない
+func Walk(n Node, fn func(Node) bool) {
+	if !fn(n) {
+		return
+	}
+	if c, ok := n.(HasContent); ok {
+		for _, child := range c.GetContent() {
+			Walk(child, fn)
+		}
+	}
+}
+
+// Parse: 入力をパースして文書ツリーを返す (HTML 化はしない)
+// Limits の上限を超えると *LimitError を返す
+func (x *Xatena) Parse(ctx context.Context, input string) (*Document, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return x.parseXatena(ctx, input)
+}
+
+// RenderDocument: Parse で得た文書ツリーを HTML 化する。出力は ToHTML と同じ
+func (x *Xatena) RenderDocument(ctx context.Context, doc *Document) string {
+	var sb strings.Builder
+	x.WriteDocument(ctx, &sb, doc)
+	return sb.String()
+}
+
+// WriteDocument: 文書ツリーを HTML 化して w へ直接書き出す
+// 脚注やタイトル取得の数が Limits の上限を超えるか ctx が終了すると、そこで書き出しをやめてその理由を返す
+// 上限を超えた場合も、それまでに番号を振った脚注は脚注ブロックとして書き出す
+func (x *Xatena) WriteDocument(ctx context.Context, w io.Writer, doc *Document) error {
+	ctx, done := x.beginRender(ctx, doc)
+	return done(x.newRenderSession().writeDocument(ctx, w, doc))
+}
+
+func (session *renderSession) writeDocument(ctx context.Context, w io.Writer, doc *Document) error {
+	err := doc.WriteHTML(ctx, w, session, syntax.CallerOptions{})
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		// 上限で止めたときも、本文の <a href="#fnN"> の参照先がなくならないよう番号を振った脚注は書き出す
+		var le *LimitError
+		if errors.Is(err, context.Canceled) && errors.As(context.Cause(ctx), &le) {
+			if ferr := session.writeFootnotes(ctx, w); ferr != nil {
+				return ferr
+			}
+		}
+		return err
+	}
+	return session.writeFootnotes(ctx, w)
+}
+
+var defaultXatena = NewXatena()
+
+// Parse: デフォルト設定の Xatena で入力をパースする
+func Parse(ctx context.Context, input string) (*Document, error) {
+	return defaultXatena.Parse(ctx, input)
+}
+
+// Render: デフォルト設定の Xatena で文書ツリーを HTML 化する
+func Render(ctx context.Context, doc *Document) string {
+	return defaultXatena.RenderDocument(ctx, doc)
+}
+
+// inlineParser はインライン記法を要素に分けられる Inline 実装 (InlineFormatter) が満たすインターフェース
+type inlineParser interface {
+	Parse(ctx context.Context, s string) []InlineNode
+}
+
+// ParseInlines: 文書ツリーの各ブロックのテキストをインライン要素にパースし、ノードの Inlines などに設定する
+// 設定した要素は HTML 化でテキストの代わりに使われるので、書き換えると出力に反映される
+// 段落は ToHTML と同じく連続する Text の行を改行でつないでパースし、先頭の Text の Inlines に設定する
+func (x *Xatena) ParseInlines(ctx context.Context, doc *Document) error {
+	p, ok := x.Inline.(inlineParser)
+	if !ok {
+		return fmt.Errorf("inline %T does not support parsing", x.Inline)
+	}
+	Walk(doc, func(n Node) bool {
+		switch v := n.(type) {
+		case *Section:
+			v.TitleInlines = p.Parse(ctx, v.Title)
+		case *List:
+			for _, l := range v.Items {
+				parseListInlines(ctx, p, l)
+			}
+		case *Table:
+			for _, row := range v.Rows {
+				for i := range row {
+					row[i].Inlines = p.Parse(ctx, row[i].Content)
+				}
+			}
+		case *DefinitionList:
+			for i := range v.Items {
+				item := &v.Items[i]
+				item.TermInlines = p.Parse(ctx, item.Term)
+				item.DescInlines = make([][]InlineNode, len(item.Descs))
+				for j, d := range item.Descs {
+					item.DescInlines[j] = p.Parse(ctx, d)
+				}
+			}
+		}
+		if c, ok := n.(HasContent); ok {
+			for _, para := range paragraphs(c.GetContent()) {
+				para[0].Inlines = p.Parse(ctx, paragraphText(para))
+				for _, t := range para[1:] {
+					t.Inlines = nil
+				}
+			}
+		}
+		return true
+	})
+	return nil
+}
+
+// paragraphs: 連続する Text を、HTML 化で1つの段落として扱う単位にまとめる
+func paragraphs(nodes []Node) [][]*Text {
+	var paras [][]*Text
+	var cur []*Text
+	for _, n := range nodes {
+		if t, ok := n.(*Text); ok {
+			cur = append(cur, t)
+			continue
+		}
+		if len(cur) > 0 {
+			paras = append(paras, cur)
+			cur = nil
+		}
+	}
+	if len(cur) > 0 {
+		paras = append(paras, cur)
+	}
+	return paras
+}
+
+// paragraphText: 段落の行を改行でつないだテキスト (Inline.Format に渡すもの)
+func paragraphText(lines []*Text) string {
+	texts := make([]string, len(lines))
+	for i, t := range lines {
+		texts[i] = t.Text
+	}
+	return strings.Join(texts, "\n")
+}
+
+func parseListInlines(ctx context.Context, p inlineParser, l *ListStruct) {
+	for _, item := range l.Items {
+		for _, c := range item.Content {
+			switch v := c.(type) {
+			case string:
+				item.Inlines = p.Parse(ctx, v)
+			case *ListStruct:
+				parseListInlines(ctx, p, v)
+			}
+		}
+	}
+}
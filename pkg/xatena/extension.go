@@ -0,0 +1,165 @@
+package xatena
+
+import (
+	"fmt"
+	htmltpl "html/template"
+
+	"github.com/cho45/xatena-go/internal/syntax"
+)
+
+// Extension は1つの記法に必要なブロックパーサ・インラインルール・テンプレートをまとめて登録する
+// Extend の中では AddBlockParser / InsertBlockParserBefore、AddInlineRule、SetDefaultTemplate などを使う
+type Extension interface {
+	Name() string
+	Extend(x *Xatena) error
+}
+
+// Option は NewXatena の設定
+type Option func(*options)
+
+type options struct {
+	inline           syntax.Inline
+	hatenaCompatible bool
+	extensions       []Extension
+	disabled         map[string]bool
+	disabledInline   []string
+	limits           Limits
+	titleResolver    TitleResolver
+	titleWorkers     int
+	highlighter      Highlighter
+	superPreHandlers map[string]SuperPreHandler
+}
+
+// WithExtensions: 組み込みの記法のあとに拡張を登録する
+func WithExtensions(exts ...Extension) Option {
+	return func(o *options) {
+		o.extensions = append(o.extensions, exts...)
+	}
+}
+
+// WithoutExtensions: 名前を指定して組み込みの記法を無効にする (BlockTable など)
+// 無効にした記法の行はただの段落のテキストになる
+func WithoutExtensions(names ...string) Option {
+	return func(o *options) {
+		if o.disabled == nil {
+			o.disabled = map[string]bool{}
+		}
+		for _, name := range names {
+			o.disabled[name] = true
+		}
+	}
+}
+
+// WithoutInlineRules: 名前を指定してインライン記法を無効にする (InlineHTML など)
+// 無効にした記法はそのまま文字列として出力する。Inline が InlineFormatter でなければ NewXatenaE はエラーを返す (NewXatena は panic する)
+func WithoutInlineRules(names ...string) Option {
+	return func(o *options) {
+		o.disabledInline = append(o.disabledInline, names...)
+	}
+}
+
+// WithInline: インライン記法の処理を差し替える (既定は NewInlineFormatter)
+func WithInline(inline syntax.Inline) Option {
+	return func(o *options) {
+		o.inline = inline
+	}
+}
+
+// WithHatenaCompatible: はてな互換モードにする
+func WithHatenaCompatible(compatible bool) Option {
+	return func(o *options) {
+		o.hatenaCompatible = compatible
+	}
+}
+
+// Use: 拡張を順に登録する。同じ名前の拡張は2度登録できない
+func (x *Xatena) Use(exts ...Extension) error {
+	for _, ext := range exts {
+		name := ext.Name()
+		for _, registered := range x.extensions {
+			if registered == name {
+				return fmt.Errorf("extension already registered: %s", name)
+			}
+		}
+		if err := ext.Extend(x); err != nil {
+			return fmt.Errorf("extension %s: %w", name, err)
+		}
+		x.extensions = append(x.extensions, name)
+	}
+	return nil
+}
+
+// Extensions: 登録されている拡張の名前を登録順に返す
+func (x *Xatena) Extensions() []string {
+	return append([]string(nil), x.extensions...)
+}
+
+// SetDefaultTemplate: テンプレートがまだ設定されていなければ設定する
+// 利用者が先に Templates を差し替えていればそちらを優先する
+func (x *Xatena) SetDefaultTemplate(name string, tmpl *htmltpl.Template) {
+	if _, ok := x.Templates[name]; !ok {
+		x.Templates[name] = tmpl
+	}
+}
+
+// inlineRuleAdder はルールを追加できる Inline 実装 (InlineFormatter) が満たすインターフェース
+type inlineRuleAdder interface {
+	AddRule(rule InlineRule)
+}
+
+// AddInlineRule: インライン記法のルールを追加する。Inline がルールの追加に対応していなければエラー
+func (x *Xatena) AddInlineRule(rule InlineRule) error {
+	f, ok := x.Inline.(inlineRuleAdder)
+	if !ok {
+		return fmt.Errorf("inline %T does not support adding rules", x.Inline)
+	}
+	f.AddRule(rule)
+	return nil
+}
+
+// blockExtension は組み込みのブロック記法1つ分の拡張
+type blockExtension struct {
+	name      string
+	parser    func() syntax.BlockParser
+	templates func(x *Xatena) map[string]*htmltpl.Template
+}
+
+func (e *blockExtension) Name() string { return e.name }
+
+func (e *blockExtension) Extend(x *Xatena) error {
+	if err := x.AddBlockParser(e.name, e.parser()); err != nil {
+		return err
+	}
+	for name, tmpl := range e.templates(x) {
+		x.SetDefaultTemplate(name, tmpl)
+	}
+	return nil
+}
+
+func staticTemplate(name string, tmpl *htmltpl.Template) func(*Xatena) map[string]*htmltpl.Template {
+	return func(*Xatena) map[string]*htmltpl.Template {
+		return map[string]*htmltpl.Template{name: tmpl}
+	}
+}
+
+// BuiltinExtensions: 組み込みのブロック記法を、パーサを試す順に返す
+// 名前は BlockSection などの定数と同じ
+func BuiltinExtensions() []Extension {
+	return []Extension{
+		&blockExtension{BlockSeeMore, func() syntax.BlockParser { return &syntax.SeeMoreParser{} }, staticTemplate("seemore", syntax.SeeMoreTemplate)},
+		&blockExtension{BlockSuperPre, func() syntax.BlockParser { return &syntax.SuperPreParser{} }, staticTemplate("superpre", syntax.SuperPreTemplate)},
+		&blockExtension{BlockStopP, func() syntax.BlockParser { return &syntax.StopPParser{} }, staticTemplate("stopp", syntax.StopPTemplate)},
+		&blockExtension{BlockBlockquote, func() syntax.BlockParser { return &syntax.BlockquoteParser{} }, staticTemplate("blockquote", syntax.BlockquoteTemplate)},
+		&blockExtension{BlockPre, func() syntax.BlockParser { return &syntax.PreParser{} }, staticTemplate("pre", syntax.PreTemplate)},
+		&blockExtension{BlockList, func() syntax.BlockParser { return &syntax.ListParser{} }, staticTemplate("list", syntax.ListTemplate)},
+		&blockExtension{BlockDefinitionList, func() syntax.BlockParser { return &syntax.DefinitionListParser{} }, staticTemplate("definitionlist", syntax.DefinitionListTemplate)},
+		&blockExtension{BlockTable, func() syntax.BlockParser { return &syntax.TableParser{} }, staticTemplate("table", syntax.TableTemplate)},
+		&blockExtension{BlockSection, func() syntax.BlockParser { return &syntax.SectionParser{} }, func(x *Xatena) map[string]*htmltpl.Template {
+			if x.HatenaCompatible {
+				return map[string]*htmltpl.Template{"section": syntax.HatenaCompatibleSectionTemplate}
+			}
+			return map[string]*htmltpl.Template{"section": syntax.SectionTemplate}
+		}},
+		&blockExtension{BlockComment, func() syntax.BlockParser { return &syntax.CommentParser{} }, staticTemplate("comment", syntax.CommentTemplate)},
+	}
+}
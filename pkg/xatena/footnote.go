@@ -0,0 +1,50 @@
+package xatena
+
+import (
+	"context"
+	htmltpl "html/template"
+	"io"
+)
+
+// FootnoteTemplate は文書末尾に出力する脚注ブロックのテンプレート
+// Text::Xatena と同じマークアップで、本文中の <a href="#fnN"> に対応するアンカーを持つ
+var FootnoteTemplate = htmltpl.Must(htmltpl.New("footnote").Parse(`
+<div class="footnote">
+{{- range .Footnotes}}
+<p class="footnote"><a href="#fn{{.Number}}" id="fn{{.Number}}">*{{.Number}}</a>: {{.Note}}</p>
+{{- end}}
+</div>
+`))
+
+// footnoteCollector は脚注を収集する Inline 実装が満たすインターフェース
+type footnoteCollector interface {
+	Footnotes() []Footnote
+}
+
+// writeFootnotes: セッションの Inline が収集した脚注を "footnote" テンプレートで書き出す
+func (s *renderSession) writeFootnotes(ctx context.Context, w io.Writer) error {
+	fc, ok := s.inline.(footnoteCollector)
+	if !ok {
+		return nil
+	}
+	footnotes := fc.Footnotes()
+	if len(footnotes) == 0 {
+		return nil
+	}
+	type footnote struct {
+		Number int
+		Title  string
+		Note   htmltpl.HTML
+	}
+	items := make([]footnote, 0, len(footnotes))
+	for _, fn := range footnotes {
+		items = append(items, footnote{
+			Number: fn.Number,
+			Title:  fn.Title,
+			Note:   htmltpl.HTML(s.GetInline().Format(ctx, fn.Note)),
+		})
+	}
+	return s.WriteTemplate(w, "footnote", map[string]interface{}{
+		"Footnotes": items,
+	})
+}
@@ -0,0 +1,34 @@
+package xatena
+
+import "github.com/cho45/xatena-go/pkg/highlight"
+
+// Highlighter は super pre (>|lang| ... ||<) のコードを色分けした HTML にする
+// lang が空のブロックでは呼ばれない。ok が false なら対応していない言語として、エスケープだけして出力する
+type Highlighter interface {
+	Highlight(lang, code string) (html string, ok bool)
+}
+
+// HighlighterFunc は関数を Highlighter にする
+type HighlighterFunc func(lang, code string) (string, bool)
+
+func (f HighlighterFunc) Highlight(lang, code string) (string, bool) {
+	return f(lang, code)
+}
+
+// BuiltinHighlighter は highlight パッケージの字句解析器 (Go・Perl・JavaScript・Python・シェル・diff・JSON) で色分けする
+var BuiltinHighlighter Highlighter = HighlighterFunc(highlight.HTML)
+
+// WithHighlighter: super pre のコードを h で色分けする (既定では色分けしない)
+func WithHighlighter(h Highlighter) Option {
+	return func(o *options) {
+		o.highlighter = h
+	}
+}
+
+// HighlightCode: Highlighter があればそれで色分けする (syntax.CodeHighlighter)
+func (x *Xatena) HighlightCode(lang, code string) (string, bool) {
+	if x.Highlighter == nil {
+		return "", false
+	}
+	return x.Highlighter.Highlight(lang, code)
+}
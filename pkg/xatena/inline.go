@@ -4,21 +4,46 @@ import (
 	"context"
 	"fmt"
 	"html"
-	"net/url"
 	"regexp"
 	"strings"
+	"sync"
+
+	"github.com/cho45/xatena-go/internal/syntax"
+)
+
+// 組み込みのインライン記法の名前 (WithoutInlineRules で無効にするときに使う)
+const (
+	InlineEscape      = "escape"       // []...[]
+	InlineFootnote    = "footnote"     // ((...))
+	InlineHTML        = "html"         // 生の HTML タグ
+	InlineHTMLComment = "html-comment" // <!-- ... -->
+	InlineBarcode     = "barcode"      // [url:barcode]
+	InlineLink        = "link"         // [url], [url:title], [url:title=...]
+	InlineMailto      = "mailto"       // [mailto:...]
+	InlineTex         = "tex"          // [tex:...]
+	InlineAutoLink    = "autolink"     // 括弧のない URL
 )
 
 type InlineRule struct {
+	Name    string // 記法の名前。同じ名前のルールはまとめて無効にできる
 	Pattern *regexp.Regexp
 	Handler func(ctx context.Context, f *InlineFormatter, m []string) string
+	Node    func(m []string) InlineNode // 指定すると Handler の代わりにパース結果の要素を作る
 }
 
+// InlineFormatter はインライン記法を HTML に整形する
+// 脚注など文書ごとの状態を持つため、Xatena は ToHTML ごとに NewSession で複製したものを使う
 type InlineFormatter struct {
+	mu           sync.Mutex // rules / matcher の更新を保護する
 	footnotes    []Footnote
 	rules        []InlineRule
-	bigRe        *regexp.Regexp
+	disabled     map[string]bool // 無効にしたルールの名前
+	matcher      *inlineMatcher  // rules から無効なものを除いてまとめたもの (必要になったときに作る)
 	titleHandler func(ctx context.Context, uri string) string
+	titleCache   TitleCache          // nil 以外ならタイトルハンドラで取得したタイトルを保存して使い回す
+	barcode      *BarcodeOptions     // nil なら DefaultBarcodeOptions
+	texRenderer  TexRenderer         // nil なら MathMLTexRenderer
+	escapeText   func(string) string // 記法以外のテキストの変換 (nil ならそのまま出力する)
 }
 
 type Footnote struct {
@@ -28,22 +53,40 @@ type Footnote struct {
 }
 
 func (f *InlineFormatter) AddRule(rule InlineRule) {
-	f.rules = append(f.rules, rule)
-	f.bigRe = nil // Reset the big regex cache
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// セッションが古い rules を参照していても壊さないよう、常に新しいスライスを作る
+	rules := make([]InlineRule, 0, len(f.rules)+1)
+	f.rules = append(append(rules, f.rules...), rule)
+	f.matcher = nil
 }
 
 func (f *InlineFormatter) AddRuleAt(index int, rule InlineRule) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	if index < 0 || index > len(f.rules) {
 		index = len(f.rules)
 	}
-	f.rules = append(f.rules[:index], append([]InlineRule{rule}, f.rules[index:]...)...)
-	f.bigRe = nil // Reset the big regex cache
+	rules := make([]InlineRule, 0, len(f.rules)+1)
+	rules = append(rules, f.rules[:index]...)
+	rules = append(rules, rule)
+	f.rules = append(rules, f.rules[index:]...)
+	f.matcher = nil
 }
 
 func defaultTitleHandler(ctx context.Context, uri string) string {
 	return uri
 }
 
+// inlineParserOf: Markdown やテキストの出力でインライン記法をパースする InlineFormatter
+// base が InlineFormatter ならそれを使い、独自のルールや無効にしたルールも HTML と同じにする
+func inlineParserOf(base syntax.Inline) *InlineFormatter {
+	if f, ok := base.(*InlineFormatter); ok {
+		return f
+	}
+	return NewInlineFormatter()
+}
+
 func NewInlineFormatter(opts ...func(*InlineFormatter)) *InlineFormatter {
 	f := &InlineFormatter{
 		footnotes:    []Footnote{},
@@ -59,118 +102,330 @@ func NewInlineFormatter(opts ...func(*InlineFormatter)) *InlineFormatter {
 func defaultInlineRules(f *InlineFormatter) []InlineRule {
 	return []InlineRule{
 		{
+			Name:    InlineEscape,
 			Pattern: regexp.MustCompile(`\[\]([\s\S]*?)\[\]`),
-			Handler: func(ctx context.Context, f *InlineFormatter, m []string) string { return m[1] },
+			Node:    func(m []string) InlineNode { return &InlineText{Text: m[1]} },
 		},
 		{
+			Name:    InlineFootnote,
 			Pattern: regexp.MustCompile(`\(\(\(.*?\)\)\)`),
-			Handler: func(ctx context.Context, f *InlineFormatter, m []string) string { return m[0][1 : len(m[0])-1] },
+			Node:    func(m []string) InlineNode { return &InlineText{Text: m[0][1 : len(m[0])-1]} },
 		},
 		{
+			Name:    InlineFootnote,
 			Pattern: regexp.MustCompile(`\)\(\(.*?\)\)\(`),
-			Handler: func(ctx context.Context, f *InlineFormatter, m []string) string { return m[0][1 : len(m[0])-1] },
+			Node:    func(m []string) InlineNode { return &InlineText{Text: m[0][1 : len(m[0])-1]} },
 		},
 		{
+			Name:    InlineFootnote,
 			Pattern: regexp.MustCompile(`\(\((.+?)\)\)`),
-			Handler: func(ctx context.Context, f *InlineFormatter, m []string) string {
-				note := m[1]
-				title := html.EscapeString(note)
-				f.footnotes = append(f.footnotes, Footnote{Number: len(f.footnotes) + 1, Note: note, Title: title})
-				return fmt.Sprintf(`<a href="#fn%d" title="%s">*%d</a>`, len(f.footnotes), html.EscapeString(title), len(f.footnotes))
-			},
+			Node:    func(m []string) InlineNode { return &FootnoteRef{Note: m[1]} },
 		},
 		{
+			Name:    InlineHTML,
 			Pattern: regexp.MustCompile(`(?i)<a[^>]+>[\s\S]*?</a>`),
-			Handler: func(ctx context.Context, f *InlineFormatter, m []string) string { return m[0] },
+			Node:    func(m []string) InlineNode { return &RawHTML{HTML: m[0]} },
 		},
 		{
+			Name:    InlineHTMLComment,
 			Pattern: regexp.MustCompile(`<!--.*?-->`),
-			Handler: func(ctx context.Context, f *InlineFormatter, m []string) string { return "<!-- -->" },
+			Node:    func(m []string) InlineNode { return &HTMLComment{} },
 		},
 		{
+			Name:    InlineHTML,
 			Pattern: regexp.MustCompile(`(?i)<[^>]+>`),
-			Handler: func(ctx context.Context, f *InlineFormatter, m []string) string { return m[0] },
+			Node:    func(m []string) InlineNode { return &RawHTML{HTML: m[0]} },
 		},
 		{
-			Pattern: regexp.MustCompile(`\[((?:https?|ftp)://[^\s:]+(?:\:\d+)?[^\s:]+)(:(?:title(?:=([^\]]+))?|barcode))?\]`),
-			Handler: func(ctx context.Context, f *InlineFormatter, m []string) string {
-				uri, opt, title := m[1], m[2], m[3]
-				if opt == ":barcode" {
-					return fmt.Sprintf(`<img src="http://chart.apis.google.com/chart?chs=150x150&cht=qr&chl=%s" title="%s"/>`, url.QueryEscape(uri), html.EscapeString(uri))
-				}
-				if strings.HasPrefix(opt, ":title") {
-					if title != "" {
-						return fmt.Sprintf(`<a href="%s">%s</a>`, uri, html.EscapeString(title))
-					}
-					return fmt.Sprintf(`<a href="%s">%s</a>`, uri, html.EscapeString(f.titleHandler(ctx, uri)))
-				}
-				return fmt.Sprintf(`<a href="%s">%s</a>`, uri, html.EscapeString(uri))
+			Name:    InlineBarcode,
+			Pattern: regexp.MustCompile(`\[((?:https?|ftp)://[^\s:]+(?:\:\d+)?[^\s:]+):barcode\]`),
+			Node:    func(m []string) InlineNode { return &Barcode{URL: m[1]} },
+		},
+		{
+			Name:    InlineLink,
+			Pattern: regexp.MustCompile(`\[((?:https?|ftp)://[^\s:]+(?:\:\d+)?[^\s:]+)(:title(?:=([^\]]+))?)?\]`),
+			Node: func(m []string) InlineNode {
+				return &Link{URL: m[1], Title: m[3], FetchTitle: m[2] != "" && m[3] == ""}
 			},
 		},
 		{
+			Name:    InlineLink,
 			Pattern: regexp.MustCompile(`\[((?:https?|ftp):[^\s<>\]]+)\]`),
-			Handler: func(ctx context.Context, f *InlineFormatter, m []string) string {
+			Node: func(m []string) InlineNode {
 				uri := m[1]
 				if strings.HasSuffix(uri, ":barcode") || strings.HasPrefix(uri, ":title") {
-					return m[0]
+					return &InlineText{Text: m[0]}
 				}
-				return fmt.Sprintf(`<a href="%s">%s</a>`, uri, uri)
+				return &Link{URL: uri}
 			},
 		},
 		{
+			Name:    InlineMailto,
 			Pattern: regexp.MustCompile(`\[mailto:([^\s\@:?]+\@[^\s\@:?]+(\?[^\s]+)?)\]`),
-			Handler: func(ctx context.Context, f *InlineFormatter, m []string) string {
-				uri := m[1]
-				return fmt.Sprintf(`<a href="mailto:%s">%s</a>`, uri, uri)
-			},
+			Node:    func(m []string) InlineNode { return &MailTo{Address: m[1]} },
 		},
 		{
+			Name:    InlineTex,
 			Pattern: regexp.MustCompile(`\[tex:([^\]]+)\]`),
-			Handler: func(ctx context.Context, f *InlineFormatter, m []string) string {
-				tex := m[1]
-				return fmt.Sprintf(`<img src="http://chart.apis.google.com/chart?cht=tx&chl=%s" alt="%s"/>`, url.QueryEscape(tex), html.EscapeString(tex))
-			},
+			Node:    func(m []string) InlineNode { return &Tex{Expr: m[1]} },
 		},
 		{
+			Name:    InlineAutoLink,
 			Pattern: regexp.MustCompile(`((?:https?|ftp):[^\s<>\"]+)`),
-			Handler: func(ctx context.Context, f *InlineFormatter, m []string) string {
+			Node: func(m []string) InlineNode {
 				uri := m[1]
 				if strings.HasSuffix(uri, ":barcode") || strings.HasPrefix(uri, ":title") {
-					return m[0]
+					return &InlineText{Text: m[0]}
 				}
-				return fmt.Sprintf(`<a href="%s">%s</a>`, uri, uri)
+				return &Link{URL: uri, Auto: true}
 			},
 		},
 	}
 }
 
-func (f *InlineFormatter) Format(ctx context.Context, s string) string {
-	s = strings.TrimPrefix(s, "\n")
+// compiled: 有効なルールをまとめた inlineMatcher を返す (必要なら構築する)
+func (f *InlineFormatter) compiled() *inlineMatcher {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	if len(f.rules) == 0 {
 		f.rules = defaultInlineRules(f)
 	}
-	var patterns []string
-	for _, r := range f.rules {
-		patterns = append(patterns, r.Pattern.String())
+	if f.matcher == nil {
+		var active []InlineRule
+		for _, r := range f.rules {
+			if !f.disabled[r.Name] {
+				active = append(active, r)
+			}
+		}
+		f.matcher = newInlineMatcher(active)
 	}
-	if f.bigRe == nil {
-		f.bigRe = regexp.MustCompile(strings.Join(patterns, "|"))
+	return f.matcher
+}
+
+// WithoutRules: 名前を指定してルールを無効にした複製を返す
+// InlineHTML を無効にすると、記法以外のテキストは HTML エスケープして出力する
+func (f *InlineFormatter) WithoutRules(names ...string) *InlineFormatter {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.rules) == 0 {
+		f.rules = defaultInlineRules(f)
 	}
-	result := f.bigRe.ReplaceAllStringFunc(s, func(m string) string {
-		for _, r := range f.rules {
-			if sub := r.Pattern.FindStringSubmatch(m); sub != nil {
-				return r.Handler(ctx, f, sub)
+	disabled := map[string]bool{}
+	for name := range f.disabled {
+		disabled[name] = true
+	}
+	for _, name := range names {
+		disabled[name] = true
+	}
+	clone := &InlineFormatter{
+		footnotes:    []Footnote{},
+		rules:        f.rules,
+		disabled:     disabled,
+		titleHandler: f.titleHandler,
+		titleCache:   f.titleCache,
+		barcode:      f.barcode,
+		texRenderer:  f.texRenderer,
+		escapeText:   f.escapeText,
+	}
+	if disabled[InlineHTML] && clone.escapeText == nil {
+		clone.escapeText = html.EscapeString
+	}
+	return clone
+}
+
+// RuleNames: 有効なルールの名前を重複なく返す
+func (f *InlineFormatter) RuleNames() []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, r := range f.compiled().rules {
+		if !seen[r.Name] {
+			seen[r.Name] = true
+			names = append(names, r.Name)
+		}
+	}
+	return names
+}
+
+// NewSession: ルールとタイトルハンドラを共有し、脚注だけを空にした複製を返す
+// 返り値は1つの文書の整形にのみ使い、goroutine 間で共有しないこと
+func (f *InlineFormatter) NewSession() syntax.Inline {
+	matcher := f.compiled()
+	return &InlineFormatter{
+		footnotes:    []Footnote{},
+		rules:        f.rules,
+		disabled:     f.disabled,
+		matcher:      matcher,
+		titleHandler: f.titleHandler,
+		titleCache:   f.titleCache,
+		barcode:      f.barcode,
+		texRenderer:  f.texRenderer,
+		escapeText:   f.escapeText,
+	}
+}
+
+func (f *InlineFormatter) Format(ctx context.Context, s string) string {
+	return f.Render(ctx, f.Parse(ctx, s))
+}
+
+// Parse: s をインライン要素に分ける。記法以外の部分は InlineText になる
+// Node を持たないルールは、その場で Handler を呼んだ結果を RawHTML にする
+// 各要素は s の中での位置 (InlinePos) を持つ
+func (f *InlineFormatter) Parse(ctx context.Context, s string) []InlineNode {
+	// 位置は先頭の改行を取り除く前の s に対するものにする
+	base := 0
+	if strings.HasPrefix(s, "\n") {
+		s, base = s[1:], 1
+	}
+	matcher := f.compiled()
+	report := syntax.DiagnosticReporter(ctx)
+	nodes := []InlineNode{}
+	add := func(n InlineNode, start, end int) {
+		n.SetInlinePos(InlinePos{Start: base + start, End: base + end})
+		nodes = append(nodes, n)
+	}
+	text := func(start, end int) {
+		t := s[start:end]
+		if report != nil {
+			reportUnknownNotations(t, report)
+		}
+		if t != "" {
+			add(&InlineText{Text: t}, start, end)
+		}
+	}
+	last := 0
+	matcher.each(s, func(start, end, rule int, sub []string) {
+		text(last, start)
+		if r := matcher.rules[rule]; r.Node != nil {
+			add(r.Node(sub), start, end)
+		} else {
+			add(&RawHTML{HTML: r.Handler(ctx, f, sub)}, start, end)
+		}
+		last = end
+	})
+	text(last, len(s))
+	return nodes
+}
+
+// Render: インライン要素を HTML にする。脚注はここで番号を振って収集する
+func (f *InlineFormatter) Render(ctx context.Context, nodes []InlineNode) string {
+	var b strings.Builder
+	for _, n := range nodes {
+		switch v := n.(type) {
+		case *InlineText:
+			b.WriteString(f.text(v.Text))
+		case *Link:
+			title := v.Title
+			if title == "" {
+				if v.FetchTitle {
+					title = f.fetchTitle(ctx, v.URL)
+				} else {
+					title = v.URL
+				}
+			}
+			fmt.Fprintf(&b, `<a href="%s">%s</a>`, html.EscapeString(v.URL), html.EscapeString(title))
+		case *FootnoteRef:
+			if !allowFootnote(ctx) {
+				// 上限を超えた脚注だけを落とし、行の残りは出力する
+				continue
 			}
+			title := html.EscapeString(v.Note)
+			f.footnotes = append(f.footnotes, Footnote{Number: len(f.footnotes) + 1, Note: v.Note, Title: title})
+			fmt.Fprintf(&b, `<a href="#fn%d" title="%s">*%d</a>`, len(f.footnotes), html.EscapeString(title), len(f.footnotes))
+		case *RawHTML:
+			b.WriteString(v.HTML)
+		case *HTMLComment:
+			b.WriteString("<!-- -->")
+		case *Tex:
+			b.WriteString(f.renderTex(ctx, v.Expr))
+		case *MailTo:
+			fmt.Fprintf(&b, `<a href="mailto:%s">%s</a>`, html.EscapeString(v.Address), html.EscapeString(v.Address))
+		case *Barcode:
+			b.WriteString(renderBarcode(f.barcodeOptions(), v.URL))
 		}
-		return m
+	}
+	return b.String()
+}
+
+func (f *InlineFormatter) text(s string) string {
+	if f.escapeText == nil || s == "" {
+		return s
+	}
+	return f.escapeText(s)
+}
+
+// InlineSpan はインライン記法1つ分の、整形前テキスト中のバイト範囲 [Start, End)
+// 1行のテキストなら TextNode.Offset を足すと入力全体でのオフセットになる
+type InlineSpan struct {
+	Start int
+	End   int
+	Rule  int    // マッチしたルールの、有効なルールの中での添字
+	Name  string // マッチしたルールの名前
+	Text  string // 記法の元テキスト
+}
+
+// Spans: Format が置き換えるインライン記法の位置を返す。s は Format に渡すものと同じ文字列
+func (f *InlineFormatter) Spans(s string) []InlineSpan {
+	matcher := f.compiled()
+	base := 0
+	if strings.HasPrefix(s, "\n") {
+		base = 1
+	}
+	var spans []InlineSpan
+	matcher.each(s[base:], func(start, end, rule int, sub []string) {
+		spans = append(spans, InlineSpan{Start: base + start, End: base + end, Rule: rule, Name: matcher.rules[rule].Name, Text: sub[0]})
 	})
-	return result
+	return spans
+}
+
+// はてな記法らしいがどのルールにもマッチしなかった [name:...] を見つける
+var reUnknownNotation = regexp.MustCompile(`\[([a-zA-Z][\w-]*):[^\s\]][^\]\n]*\]`)
+
+func reportUnknownNotations(text string, report func(syntax.Diagnostic)) {
+	for _, m := range reUnknownNotation.FindAllString(text, -1) {
+		report(syntax.Diagnostic{Severity: syntax.SeverityWarning, Message: "unknown inline notation " + m})
+	}
 }
 
 func (f *InlineFormatter) Footnotes() []Footnote {
 	return f.footnotes
 }
 
+// fetchTitle: uri のタイトルを取得する。Xatena.TitleResolver があればそちらで、なければタイトルハンドラで取得する
+// 事前に取得したものとキャッシュにあるものはそのまま使う。それ以外を取得する URL の数が Limits の上限を超えたか
+// ctx が終了していれば、取得せずに uri を返す
+func (f *InlineFormatter) fetchTitle(ctx context.Context, uri string) string {
+	if ctx.Err() != nil {
+		return uri
+	}
+	if r := prefetchedTitles(ctx); r != nil {
+		if title, ok := r.titles[uri]; ok {
+			return title
+		}
+		return fetchTitleOnce(ctx, uri, TitleHandler(r.resolver))
+	}
+	if f.titleCache != nil {
+		if title, ok := f.titleCache.Get(uri); ok {
+			return title
+		}
+	}
+	return fetchTitleOnce(ctx, uri, f.cachedTitle)
+}
+
+// cachedTitle: タイトルハンドラで取得し、タイトルのキャッシュがあれば保存する
+// 取得に失敗した (uri がそのまま返った) ものは保存しない
+func (f *InlineFormatter) cachedTitle(ctx context.Context, uri string) string {
+	title := f.titleHandler(ctx, uri)
+	if f.titleCache != nil && title != "" && title != uri {
+		f.titleCache.Set(uri, title)
+	}
+	return title
+}
+
 func (f *InlineFormatter) SetTitleHandler(handler func(ctx context.Context, uri string) string) {
 	f.titleHandler = handler
 }
+
+// SetTitleCache: タイトルハンドラで取得したタイトルを cache に保存し、次からはそちらを使う (nil で無効)
+func (f *InlineFormatter) SetTitleCache(cache TitleCache) {
+	f.titleCache = cache
+}
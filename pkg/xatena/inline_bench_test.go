@@ -0,0 +1,148 @@
+package xatena
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// go test -bench Inline -benchmem ./pkg/xatena で、1度の走査でルールを決める inlineMatcher と
+// 以前の実装 (結合した正規表現でマッチを探し、各ルールの正規表現で試し直す) を比べる
+
+// rematchParse は以前の実装と同じ方法で s をインライン要素に分ける (比較用)
+func rematchParse(f *InlineFormatter, re *regexp.Regexp, s string) []InlineNode {
+	base := 0
+	if strings.HasPrefix(s, "\n") {
+		s, base = s[1:], 1
+	}
+	rules := f.compiled().rules
+	nodes := []InlineNode{}
+	add := func(n InlineNode, start, end int) {
+		n.SetInlinePos(InlinePos{Start: base + start, End: base + end})
+		nodes = append(nodes, n)
+	}
+	last := 0
+	for _, loc := range re.FindAllStringIndex(s, -1) {
+		if last < loc[0] {
+			add(&InlineText{Text: s[last:loc[0]]}, last, loc[0])
+		}
+		m := s[loc[0]:loc[1]]
+		for _, r := range rules {
+			if sub := r.Pattern.FindStringSubmatch(m); sub != nil {
+				add(r.Node(sub), loc[0], loc[1])
+				break
+			}
+		}
+		last = loc[1]
+	}
+	if last < len(s) {
+		add(&InlineText{Text: s[last:]}, last, len(s))
+	}
+	return nodes
+}
+
+func rematchRegexp(f *InlineFormatter) *regexp.Regexp {
+	var patterns []string
+	for _, r := range f.compiled().rules {
+		patterns = append(patterns, r.Pattern.String())
+	}
+	return regexp.MustCompile(strings.Join(patterns, "|"))
+}
+
+func sampleParagraphs(tb testing.TB) []string {
+	tb.Helper()
+	data, err := os.ReadFile("../../sample.txt")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return reParagraphSep.Split(string(data), -1)
+}
+
+func TestInlineMatcherSameAsRematch(t *testing.T) {
+	f := NewInlineFormatter()
+	re := rematchRegexp(f)
+	inputs := append(sampleParagraphs(t), allTestInputs()...)
+	inputs = append(inputs,
+		"[http://example.com/:barcode] [http://example.com/:title=a] [http://example.com/:title]",
+		"((a)) (((b))) )((c))( []((d))[] <a href=\"http://example.com/\">x</a> <!-- c -->",
+		"[mailto:a@example.com?subject=x] [tex:e^{i\\pi}] http://example.com/:barcode",
+	)
+	ctx := context.Background()
+	for _, input := range inputs {
+		got := f.Parse(ctx, input)
+		want := rematchParse(f, re, input)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("input %q: parse differs", input)
+		}
+	}
+}
+
+func BenchmarkInlineFormat(b *testing.B) {
+	paras := sampleParagraphs(b)
+	f := NewInlineFormatter()
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, p := range paras {
+			f.Render(ctx, f.Parse(ctx, p))
+		}
+		f.footnotes = f.footnotes[:0]
+	}
+}
+
+func BenchmarkInlineFormatRematch(b *testing.B) {
+	paras := sampleParagraphs(b)
+	f := NewInlineFormatter()
+	re := rematchRegexp(f)
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, p := range paras {
+			f.Render(ctx, rematchParse(f, re, p))
+		}
+		f.footnotes = f.footnotes[:0]
+	}
+}
+
+func TestInlineMatcherCustomRules(t *testing.T) {
+	ctx := context.Background()
+	f := NewInlineFormatter()
+	f.AddRuleAt(0, InlineRule{
+		Pattern: regexp.MustCompile(`(?i)\[kbd:([^\]]+)\]`),
+		Handler: func(ctx context.Context, f *InlineFormatter, m []string) string { return "<kbd>" + m[1] + "</kbd>" },
+	})
+	f.AddRule(InlineRule{
+		Pattern: regexp.MustCompile(`【(.+?)】`),
+		Handler: func(ctx context.Context, f *InlineFormatter, m []string) string { return "<b>" + m[1] + "</b>" },
+	})
+	if f.compiled().re != nil {
+		t.Fatalf("expected byte dispatch")
+	}
+	got := f.Format(ctx, "押す [KBD:Ctrl] と【強調】 http://example.com/")
+	want := `押す <kbd>Ctrl</kbd> と<b>強調</b> <a href="http://example.com/">http://example.com/</a>`
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestInlineMatcherContextualRule(t *testing.T) {
+	ctx := context.Background()
+	f := NewInlineFormatter()
+	f.AddRule(InlineRule{
+		Pattern: regexp.MustCompile(`\bTODO\b`),
+		Handler: func(ctx context.Context, f *InlineFormatter, m []string) string { return "<mark>TODO</mark>" },
+	})
+	if f.compiled().re == nil {
+		t.Fatalf("expected regexp fallback for \\b")
+	}
+	got := f.Format(ctx, "xTODO TODO [http://example.com/:title=a]")
+	want := `xTODO <mark>TODO</mark> <a href="http://example.com/">a</a>`
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
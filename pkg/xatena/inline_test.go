@@ -31,7 +31,7 @@ func TestInlineFormatter_Format(t *testing.T) {
 		{
 			name:   "tex",
 			input:  "[tex:E=mc^2]",
-			expect: `<img src="http://chart.apis.google.com/chart?cht=tx&chl=E%3Dmc%5E2" alt="E=mc^2"/>`,
+			expect: `<math xmlns="http://www.w3.org/1998/Math/MathML" alttext="E=mc^2"><mrow><mi>E</mi><mo>=</mo><mi>m</mi><msup><mi>c</mi><mn>2</mn></msup></mrow></math>`,
 		},
 		{
 			name:   "footnote",
@@ -41,7 +41,7 @@ func TestInlineFormatter_Format(t *testing.T) {
 		{
 			name:   "barcode",
 			input:  "[http://example.com/:barcode]",
-			expect: `<img src="http://chart.apis.google.com/chart?chs=150x150&cht=qr&chl=http%3A%2F%2Fexample.com%2F" title="http://example.com/"/>`,
+			expect: exampleBarcode,
 		},
 		{
 			name:   "title option",
@@ -180,7 +180,7 @@ func TestInlineFormatterSpecialPatterns(t *testing.T) {
 		{
 			name:   "URL with barcode suffix (should not match)",
 			input:  "[http://example.com/:barcode]",
-			expect: `<img src="http://chart.apis.google.com/chart?chs=150x150&cht=qr&chl=http%3A%2F%2Fexample.com%2F" title="http://example.com/"/>`,
+			expect: exampleBarcode,
 		},
 		{
 			name:   "URL with title prefix (should not match simple pattern)",
@@ -200,7 +200,7 @@ func TestInlineFormatterSpecialPatterns(t *testing.T) {
 		{
 			name:   "Bracketed URL with barcode suffix (should not match)",
 			input:  "[http://example.com/:barcode]",
-			expect: `<img src="http://chart.apis.google.com/chart?chs=150x150&cht=qr&chl=http%3A%2F%2Fexample.com%2F" title="http://example.com/"/>`,
+			expect: exampleBarcode,
 		},
 		{
 			name:   "Bracketed URL with title prefix (should not match)",
@@ -266,7 +266,7 @@ func TestInlineFormatterNoMatch(t *testing.T) {
 	
 	// Clear existing rules and add only our custom rule
 	f.rules = []InlineRule{customRule}
-	f.bigRe = nil // Reset cache
+	f.matcher = nil // Reset cache
 	
 	// Test with input that matches the pattern but handler returns original
 	input := "[nomatch:test]"
@@ -0,0 +1,232 @@
+package xatena
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// inlineMatcher は有効なルールを先頭から1度だけ走査してマッチさせる
+//
+// 各ルールのパターンからマッチの先頭になりうるバイトを求めておき、入力中でそのバイトが現れた位置でだけ、
+// 先頭に固定したパターンを優先順に試す。最初にマッチしたルールを採用するので、
+// 全ルールを | でつないだ正規表現 (同じ位置では先に登録したルールが勝つ) と同じ結果になる。
+// 行頭 (^) や単語境界 (\b) のように直前の文字に依存するルールがあるときは、
+// 全ルールを捕獲グループで囲んでつないだ正規表現で走査し、マッチしたグループの番号からルールを決める
+type inlineMatcher struct {
+	rules    []InlineRule
+	anchored []*regexp.Regexp // rules[i] を先頭に固定したもの
+	first    []*[256]bool     // rules[i] のマッチの先頭になりうるバイト (nil ならどのバイトでもよい)
+	trigger  [256]bool        // いずれかのルールの先頭になりうるバイト
+	anyStart bool             // どの位置からでもマッチしうるルールがある
+	re       *regexp.Regexp   // 直前の文字に依存するルールがあるときに使う
+	groups   []int            // re の中で rules[i] のパターンを囲むグループの番号
+}
+
+func newInlineMatcher(rules []InlineRule) *inlineMatcher {
+	m := &inlineMatcher{rules: rules}
+	contextual := false
+	for _, r := range rules {
+		first, ctx := firstBytes(r.Pattern.String())
+		if ctx {
+			contextual = true
+			break
+		}
+		m.anchored = append(m.anchored, regexp.MustCompile(`^(?:`+r.Pattern.String()+`)`))
+		m.first = append(m.first, first)
+		if first == nil {
+			m.anyStart = true
+			continue
+		}
+		for b, ok := range first {
+			if ok {
+				m.trigger[b] = true
+			}
+		}
+	}
+	if contextual {
+		m.anchored, m.first = nil, nil
+		m.groups = make([]int, len(rules))
+		var patterns []string
+		group := 1
+		for i, r := range rules {
+			m.groups[i] = group
+			group += 1 + r.Pattern.NumSubexp()
+			patterns = append(patterns, "("+r.Pattern.String()+")")
+		}
+		m.re = regexp.MustCompile(strings.Join(patterns, "|"))
+	}
+	return m
+}
+
+// each: s の中の記法を先頭から順に見つけ、範囲 [start, end)・ルールの添字・部分マッチを fn に渡す
+// sub はそのルールの Pattern で FindStringSubmatch したときと同じ形になる
+func (m *inlineMatcher) each(s string, fn func(start, end, rule int, sub []string)) {
+	if m.re != nil {
+		m.eachRegexp(s, fn)
+		return
+	}
+	for i := 0; i < len(s); {
+		if !m.anyStart {
+			// 記法の先頭になりうるバイトまで読み飛ばす
+			for i < len(s) && !m.trigger[s[i]] {
+				i++
+			}
+			if i == len(s) {
+				return
+			}
+		}
+		if end := m.matchAt(s, i, fn); end > i {
+			i = end
+		} else {
+			_, size := utf8.DecodeRuneInString(s[i:])
+			i += size
+		}
+	}
+}
+
+// matchAt: 位置 i から始まるルールを優先順に試し、マッチすれば fn を呼んでその終わりを返す
+func (m *inlineMatcher) matchAt(s string, i int, fn func(start, end, rule int, sub []string)) int {
+	c := s[i]
+	for r, re := range m.anchored {
+		if first := m.first[r]; first != nil && !first[c] {
+			continue
+		}
+		loc := re.FindStringSubmatchIndex(s[i:])
+		if loc == nil || loc[1] == 0 {
+			continue
+		}
+		sub := make([]string, len(loc)/2)
+		for k := range sub {
+			if loc[2*k] >= 0 {
+				sub[k] = s[i+loc[2*k] : i+loc[2*k+1]]
+			}
+		}
+		fn(i, i+loc[1], r, sub)
+		return i + loc[1]
+	}
+	return i
+}
+
+func (m *inlineMatcher) eachRegexp(s string, fn func(start, end, rule int, sub []string)) {
+	for _, loc := range m.re.FindAllStringSubmatchIndex(s, -1) {
+		for i, g := range m.groups {
+			if loc[2*g] < 0 {
+				continue
+			}
+			sub := make([]string, 1+m.rules[i].Pattern.NumSubexp())
+			for k := range sub {
+				if a, b := loc[2*(g+k)], loc[2*(g+k)+1]; a >= 0 {
+					sub[k] = s[a:b]
+				}
+			}
+			fn(loc[0], loc[1], i, sub)
+			break
+		}
+	}
+}
+
+// firstBytes: パターンのマッチの先頭になりうるバイトを返す。どのバイトでもありうるときは nil
+// contextual はパターンが行頭・単語境界など直前の文字に依存する場合に true
+func firstBytes(pattern string) (first *[256]bool, contextual bool) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil, false
+	}
+	fs := &firstSet{}
+	if nullable := fs.add(re.Simplify()); nullable || fs.any {
+		return nil, fs.contextual
+	}
+	return &fs.bytes, fs.contextual
+}
+
+type firstSet struct {
+	bytes      [256]bool
+	any        bool
+	contextual bool
+}
+
+// add: re の先頭になりうるバイトを加え、re が空文字列にマッチしうるかを返す
+func (fs *firstSet) add(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpNoMatch:
+		return false
+	case syntax.OpEmptyMatch, syntax.OpEndLine, syntax.OpEndText:
+		return true
+	case syntax.OpBeginLine, syntax.OpBeginText, syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+		fs.contextual = true
+		return true
+	case syntax.OpLiteral:
+		if len(re.Rune) == 0 {
+			return true
+		}
+		r := re.Rune[0]
+		fs.addRange(r, r)
+		if re.Flags&syntax.FoldCase != 0 {
+			for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+				fs.addRange(f, f)
+			}
+		}
+		return false
+	case syntax.OpCharClass:
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			fs.addRange(re.Rune[i], re.Rune[i+1])
+		}
+		return false
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		fs.any = true
+		return false
+	case syntax.OpCapture:
+		return fs.add(re.Sub[0])
+	case syntax.OpStar, syntax.OpQuest:
+		fs.add(re.Sub[0])
+		return true
+	case syntax.OpPlus:
+		return fs.add(re.Sub[0])
+	case syntax.OpRepeat:
+		nullable := fs.add(re.Sub[0])
+		return nullable || re.Min == 0
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			if !fs.add(sub) {
+				return false
+			}
+		}
+		return true
+	case syntax.OpAlternate:
+		nullable := false
+		for _, sub := range re.Sub {
+			if fs.add(sub) {
+				nullable = true
+			}
+		}
+		return nullable
+	}
+	fs.any = true
+	return false
+}
+
+// addRange: lo から hi までの文字を UTF-8 にしたときの先頭バイトを加える
+func (fs *firstSet) addRange(lo, hi rune) {
+	if hi > utf8.MaxRune {
+		hi = utf8.MaxRune
+	}
+	if lo > hi {
+		return
+	}
+	if lo <= utf8.RuneError && utf8.RuneError <= hi {
+		// 不正なバイト列も RuneError としてマッチするので、先頭のバイトを絞れない
+		fs.any = true
+		return
+	}
+	var buf [utf8.UTFMax]byte
+	utf8.EncodeRune(buf[:], lo)
+	from := buf[0]
+	utf8.EncodeRune(buf[:], hi)
+	to := buf[0]
+	for b := int(from); b <= int(to); b++ {
+		fs.bytes[b] = true
+	}
+}
@@ -0,0 +1,193 @@
+package xatena
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Limits は1回の変換で許す入力の大きさと記法の数。0 の項目は制限しない
+// 信頼できない入力 (ユーザーコメントなど) で変換に時間やメモリを使わせないために設定する
+type Limits struct {
+	MaxInputBytes   int // 入力のバイト数
+	MaxNestingDepth int // 引用 (>> <<)・見出しなど中身を持つブロックの入れ子の深さ
+	MaxListDepth    int // リストの入れ子の深さ (行頭の - や + の数)
+	MaxFootnotes    int // 脚注 ((...)) の数
+	MaxTitleFetches int // [url:title] でタイトルを取得する URL の数 (同じ URL は1つと数え、キャッシュにあるものは数えない)
+}
+
+// DefaultLimits: 信頼できない入力向けの上限
+func DefaultLimits() Limits {
+	return Limits{
+		MaxInputBytes:   1 << 20,
+		MaxNestingDepth: 32,
+		MaxListDepth:    16,
+		MaxFootnotes:    100,
+		MaxTitleFetches: 10,
+	}
+}
+
+// WithLimits: 変換の上限を設定する
+func WithLimits(limits Limits) Option {
+	return func(o *options) {
+		o.limits = limits
+	}
+}
+
+// LimitError.Limit の値
+const (
+	LimitInputBytes   = "input bytes"
+	LimitNestingDepth = "nesting depth"
+	LimitListDepth    = "list depth"
+	LimitFootnotes    = "footnotes"
+	LimitTitleFetches = "title fetches"
+)
+
+// LimitError は Limits の上限を超えたときに Parse・Render などが返すエラー
+type LimitError struct {
+	Limit string // LimitInputBytes など
+	Max   int
+	Line  int // 上限を超えた行 (1 始まり)。0 は位置不明
+}
+
+func (e *LimitError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("line %d: %s limit exceeded (max %d)", e.Line, e.Limit, e.Max)
+	}
+	return fmt.Sprintf("%s limit exceeded (max %d)", e.Limit, e.Max)
+}
+
+// checkListDepth: doc の中で MaxListDepth より深いリストがあれば LimitError を返す
+func (l Limits) checkListDepth(doc *Document) error {
+	if l.MaxListDepth <= 0 {
+		return nil
+	}
+	var err error
+	for _, n := range doc.Content {
+		Walk(n, func(n Node) bool {
+			if list, ok := n.(*List); ok && err == nil {
+				if line := deeperList(list.Items, l.MaxListDepth); line > 0 {
+					err = &LimitError{Limit: LimitListDepth, Max: l.MaxListDepth, Line: line}
+				}
+			}
+			return err == nil
+		})
+	}
+	return err
+}
+
+// deeperList: 入れ子が max を超えるリストがあればその開始行を返す。なければ 0
+// 深いリストでもスタックを使い切らないよう再帰しない
+func deeperList(lists []*ListStruct, max int) int {
+	type entry struct {
+		list  *ListStruct
+		depth int
+	}
+	var stack []entry
+	for _, l := range lists {
+		stack = append(stack, entry{l, 1})
+	}
+	for len(stack) > 0 {
+		e := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if e.depth > max {
+			return e.list.StartLine
+		}
+		for _, item := range e.list.Items {
+			for _, c := range item.Content {
+				if child, ok := c.(*ListStruct); ok {
+					stack = append(stack, entry{child, e.depth + 1})
+				}
+			}
+		}
+	}
+	return 0
+}
+
+// renderLimits は1回のレンダリングで脚注とタイトルの取得を数える
+type renderLimits struct {
+	limits       Limits
+	cancel       context.CancelCauseFunc
+	footnotes    int
+	titleFetches int
+	titles       map[string]string // このレンダリングで取得したタイトル
+}
+
+type renderLimitsKey struct{}
+
+// beginRender: doc の1回のレンダリングの上限を数える renderLimits を ctx に設定し、:title のタイトルを取得しておく
+// 上限を超えると返した ctx を LimitError を原因として終了させるので、ノードの書き出しはそこで止まる
+// 返した関数には書き出しの結果を渡す。ctx が終了していればその原因 (LimitError や context.Canceled) を返す
+func (x *Xatena) beginRender(ctx context.Context, doc *Document) (context.Context, func(err error) error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	l := &renderLimits{limits: x.Limits, cancel: cancel}
+	ctx = x.withResolvedTitles(context.WithValue(ctx, renderLimitsKey{}, l), doc)
+	return ctx, func(err error) error {
+		if cause := context.Cause(ctx); cause != nil {
+			err = cause
+		}
+		cancel(nil)
+		return err
+	}
+}
+
+// take: count を1つ増やし、max を超えたら ctx を終了させて false を返す
+func (l *renderLimits) take(count *int, max int, limit string) bool {
+	*count++
+	if max > 0 && *count > max {
+		l.cancel(&LimitError{Limit: limit, Max: max})
+		return false
+	}
+	return true
+}
+
+// allowFootnote: 脚注を1つ数える。上限を超えたら false
+func allowFootnote(ctx context.Context) bool {
+	l, _ := ctx.Value(renderLimitsKey{}).(*renderLimits)
+	if l == nil {
+		return true
+	}
+	return l.take(&l.footnotes, l.limits.MaxFootnotes, LimitFootnotes)
+}
+
+// countTitleFetches: TitleResolver で事前に取得する urls を取得した URL として数える
+func countTitleFetches(ctx context.Context, urls []string) {
+	if l, _ := ctx.Value(renderLimitsKey{}).(*renderLimits); l != nil {
+		l.titleFetches += len(urls)
+	}
+}
+
+// fetchTitleOnce: uri のタイトルを fetch で取得する。1回のレンダリングで同じ URL は1度だけ取得し、取得した URL の数を数える
+// ctx が終了しているか上限を超えたら、取得せずに uri を返す
+func fetchTitleOnce(ctx context.Context, uri string, fetch func(ctx context.Context, uri string) string) string {
+	if ctx.Err() != nil {
+		return uri
+	}
+	l, _ := ctx.Value(renderLimitsKey{}).(*renderLimits)
+	if l == nil {
+		return fetch(ctx, uri)
+	}
+	if title, ok := l.titles[uri]; ok {
+		return title
+	}
+	if !l.take(&l.titleFetches, l.limits.MaxTitleFetches, LimitTitleFetches) {
+		return uri
+	}
+	title := fetch(ctx, uri)
+	if l.titles == nil {
+		l.titles = map[string]string{}
+	}
+	l.titles[uri] = title
+	return title
+}
+
+// limitDiagnostic: 変換を止めたエラーを診断にする
+func limitDiagnostic(err error) Diagnostic {
+	d := Diagnostic{Severity: SeverityError, Message: err.Error()}
+	var le *LimitError
+	if errors.As(err, &le) {
+		d.Line = le.Line
+		d.Message = (&LimitError{Limit: le.Limit, Max: le.Max}).Error()
+	}
+	return d
+}
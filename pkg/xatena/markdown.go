@@ -0,0 +1,439 @@
+package xatena
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/cho45/xatena-go/internal/syntax"
+)
+
+// Markdown 出力
+// HTML と同じ文書ツリーを CommonMark (+ GFM のテーブル・脚注) に変換する。
+// 見出しレベルは記法の深さのまま * を #、** を ## とする。
+// 続きを読む・stopp・定義リストなど Markdown に対応する構文がないものは生 HTML で出力する。
+
+// ToMarkdown: 入力を Markdown に変換する
+// Limits の上限を超えたり ctx が終了した場合は空文字列を返す
+func (x *Xatena) ToMarkdown(ctx context.Context, input string) string {
+	doc, err := x.parseXatena(ctx, input)
+	if err != nil {
+		return ""
+	}
+	var sb strings.Builder
+	x.WriteMarkdown(ctx, &sb, doc)
+	return sb.String()
+}
+
+// WriteMarkdown: 文書ツリーを Markdown で w へ書き出す
+// 脚注やタイトル取得の数が Limits の上限を超えるか ctx が終了すると、何も書き出さずにその理由を返す
+func (x *Xatena) WriteMarkdown(ctx context.Context, w io.Writer, doc *Document) error {
+	m := newMarkdownRenderer(x)
+	ctx, done := x.beginRender(ctx, doc)
+	blocks := m.blocks(ctx, doc.Content)
+	if notes := m.footnotes(ctx); notes != "" {
+		blocks = append(blocks, notes)
+	}
+	if err := done(nil); err != nil {
+		return err
+	}
+	if len(blocks) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(w, strings.Join(blocks, "\n\n")+"\n")
+	return err
+}
+
+// RenderMarkdown: デフォルト設定の Xatena で文書ツリーを Markdown に変換する
+func RenderMarkdown(ctx context.Context, doc *Document) string {
+	var sb strings.Builder
+	defaultXatena.WriteMarkdown(ctx, &sb, doc)
+	return sb.String()
+}
+
+type markdownRenderer struct {
+	session *renderSession      // 生 HTML で出力するノード用
+	parser  *InlineFormatter    // インライン記法のパース・タイトルの取得・QR コードの設定に使う
+	escape  func(string) string // 記法ではない文字列のエスケープ
+	policy  *SanitizePolicy     // 安全モードなら生の HTML をこの許可リストでサニタイズする
+	notes   []Footnote          // 集めた脚注
+}
+
+func newMarkdownRenderer(x *Xatena) *markdownRenderer {
+	m := &markdownRenderer{
+		session: x.newRenderSession(),
+		parser:  inlineParserOf(x.Inline),
+		escape:  escapeMarkdown,
+		policy:  x.SafeMode,
+	}
+	if m.parser.disabled[InlineHTML] {
+		// Markdown の中の生の HTML も書けないようにする
+		m.escape = func(s string) string { return escapeMarkdown(html.EscapeString(s)) }
+	}
+	return m
+}
+
+// blocks: ノード列を Markdown のブロックの列にする。連続する TextNode は段落にまとめる
+func (m *markdownRenderer) blocks(ctx context.Context, nodes []Node) []string {
+	var blocks []string
+	var textBuf []*Text
+	flush := func() {
+		if len(textBuf) > 0 {
+			blocks = append(blocks, m.paragraphs(ctx, textBuf)...)
+			textBuf = nil
+		}
+	}
+	for _, n := range nodes {
+		if ctx.Err() != nil {
+			break
+		}
+		if t, ok := n.(*Text); ok {
+			textBuf = append(textBuf, t)
+			continue
+		}
+		flush()
+		if b := m.block(ctx, n); b != "" {
+			blocks = append(blocks, b)
+		}
+	}
+	flush()
+	return blocks
+}
+
+var reParagraphSep = regexp.MustCompile(`\n(?:[ \t]*\n)+`)
+
+// paragraphs: HTML と同じく連続する行をまとめて整形し、空行で段落に分ける
+// 段落内の改行は HTML 出力の <br /> に合わせてハードブレークにする
+func (m *markdownRenderer) paragraphs(ctx context.Context, lines []*Text) []string {
+	var paras []string
+	text := m.format(ctx, paragraphText(lines), lines[0].Inlines)
+	for _, para := range reParagraphSep.Split(text, -1) {
+		para = strings.TrimRight(strings.TrimLeft(para, "\n"), " \t\n")
+		if strings.TrimSpace(para) == "" {
+			continue
+		}
+		formatted := strings.Split(para, "\n")
+		for i := range formatted[:len(formatted)-1] {
+			formatted[i] += "\\"
+		}
+		paras = append(paras, strings.Join(formatted, "\n"))
+	}
+	return paras
+}
+
+// format: パース済みのインライン要素があればそれを、なければ text をパースして Markdown にする
+func (m *markdownRenderer) format(ctx context.Context, text string, nodes []InlineNode) string {
+	if nodes == nil {
+		nodes = m.parser.Parse(ctx, text)
+	}
+	return m.inlines(ctx, nodes)
+}
+
+// inlines: インライン要素を Markdown にする。脚注はここで番号を振って収集する
+func (m *markdownRenderer) inlines(ctx context.Context, nodes []InlineNode) string {
+	var b strings.Builder
+	// 安全モードでは、連続する文字列と生の HTML を ToHTML と同じくまとめてサニタイズする
+	var raw strings.Builder
+	flush := func() {
+		if raw.Len() > 0 {
+			b.WriteString(m.policy.Sanitize(raw.String()))
+			raw.Reset()
+		}
+	}
+	out := &b
+	if m.policy != nil {
+		out = &raw
+	}
+	lineStart := func() bool {
+		if raw.Len() > 0 {
+			return strings.HasSuffix(raw.String(), "\n")
+		}
+		return b.Len() == 0 || strings.HasSuffix(b.String(), "\n")
+	}
+	for _, n := range nodes {
+		switch v := n.(type) {
+		case *InlineText:
+			out.WriteString(m.text(v.Text, lineStart()))
+			continue
+		case *RawHTML:
+			out.WriteString(v.HTML)
+			continue
+		case *HTMLComment:
+			out.WriteString("<!-- -->")
+			continue
+		}
+		flush()
+		switch v := n.(type) {
+		case *Link:
+			switch {
+			case v.Title != "":
+				b.WriteString(m.link(v.Title, v.URL))
+			case v.FetchTitle:
+				b.WriteString(m.link(m.parser.fetchTitle(ctx, v.URL), v.URL))
+			case m.policy != nil && !m.policy.allowedURL(v.URL):
+				b.WriteString(m.escape(html.EscapeString(v.URL)))
+			default:
+				b.WriteString("<" + v.URL + ">")
+			}
+		case *FootnoteRef:
+			if !allowFootnote(ctx) {
+				continue
+			}
+			m.notes = append(m.notes, Footnote{Number: len(m.notes) + 1, Note: v.Note, Title: v.Note})
+			fmt.Fprintf(&b, "[^%d]", len(m.notes))
+		case *Tex:
+			expr := v.Expr
+			if m.policy != nil {
+				expr = html.EscapeString(expr)
+			}
+			b.WriteString("$" + expr + "$")
+		case *MailTo:
+			b.WriteString(m.link(v.Address, "mailto:"+v.Address))
+		case *Barcode:
+			if src := barcodeDataURI(m.parser.barcodeOptions(), v.URL); src != "" {
+				// data: URI はライブラリが生成したものなので安全モードでもそのまま使う
+				alt := v.URL
+				if m.policy != nil {
+					alt = html.EscapeString(alt)
+				}
+				b.WriteString(markdownImage(alt, src))
+			} else {
+				b.WriteString(m.link(v.URL, v.URL))
+			}
+		}
+	}
+	flush()
+	return b.String()
+}
+
+// link: Markdown のリンク。安全モードではリンクの文字列の HTML をエスケープし、許可されない URL はリンクにしない
+func (m *markdownRenderer) link(text, uri string) string {
+	if m.policy == nil {
+		return markdownLink(text, uri)
+	}
+	text = html.EscapeString(text)
+	if !m.policy.allowedURL(uri) {
+		return escapeMarkdown(text)
+	}
+	return markdownLink(text, uri)
+}
+
+// text: 記法ではない文字列をエスケープする。行頭の空白はインデントコードブロックになってしまうので落とす
+func (m *markdownRenderer) text(s string, lineStart bool) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if i > 0 || lineStart {
+			lines[i] = strings.TrimLeft(line, " \t")
+		}
+	}
+	return m.escape(strings.Join(lines, "\n"))
+}
+
+func (m *markdownRenderer) block(ctx context.Context, n Node) string {
+	switch v := n.(type) {
+	case *Section:
+		head := strings.Repeat("#", v.Level) + " " + m.format(ctx, v.Title, v.TitleInlines)
+		return strings.Join(append([]string{head}, m.blocks(ctx, v.Content)...), "\n\n")
+	case *List:
+		var lists []string
+		for _, l := range v.Items {
+			lists = append(lists, m.list(ctx, l))
+		}
+		// 種類の違うリストが続く場合に1つのリストとして読まれないよう HTML コメントで区切る
+		return strings.Join(lists, "\n\n<!-- -->\n\n")
+	case *Table:
+		if s, ok := m.table(ctx, v); ok {
+			return s
+		}
+	case *SuperPre:
+		return codeFence(v.Lang, v.RawText)
+	case *Blockquote:
+		return m.blockquote(ctx, v)
+	case *SeeMore:
+		inner := m.blocks(ctx, v.Content)
+		return strings.Join(append(append([]string{`<div class="seemore">`}, inner...), "</div>"), "\n\n")
+	case *Comment:
+		return "<!-- -->"
+	}
+	return m.rawHTML(ctx, n)
+}
+
+// rawHTML: Markdown に対応する構文がないノードを HTML ブロックとして出力する
+// <pre> 以外の HTML ブロックは空行で終わってしまうので空行を取り除く
+func (m *markdownRenderer) rawHTML(ctx context.Context, n Node) string {
+	s := strings.TrimSpace(n.ToHTML(ctx, m.session, syntax.CallerOptions{}))
+	if _, ok := n.(*Pre); ok {
+		return s
+	}
+	lines := strings.Split(s, "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		if strings.TrimSpace(line) != "" {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
+
+func (m *markdownRenderer) list(ctx context.Context, l *ListStruct) string {
+	marker := "-"
+	if l.Name == "ol" {
+		marker = "1."
+	}
+	indent := strings.Repeat(" ", len(marker)+1)
+	var lines []string
+	for _, item := range l.Items {
+		first := true
+		for _, c := range item.Content {
+			var s string
+			switch v := c.(type) {
+			case string:
+				s = m.format(ctx, v, item.Inlines)
+			case *ListStruct:
+				s = m.list(ctx, v)
+			}
+			for i, line := range strings.Split(s, "\n") {
+				switch {
+				case first && i == 0:
+					if _, nested := c.(*ListStruct); nested {
+						// テキストのない項目: 空の項目の下に入れ子のリストを置く
+						lines = append(lines, marker)
+						lines = append(lines, indent+line)
+					} else {
+						lines = append(lines, marker+" "+line)
+					}
+				case line == "":
+					lines = append(lines, "")
+				default:
+					lines = append(lines, indent+line)
+				}
+			}
+			first = false
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// table: 1行目がすべて見出しセルで、他に見出しセルがないときだけ GFM のテーブルにする
+func (m *markdownRenderer) table(ctx context.Context, t *Table) (string, bool) {
+	if len(t.Rows) == 0 {
+		return "", false
+	}
+	cols := 0
+	for i, row := range t.Rows {
+		for _, c := range row {
+			if c.IsHeader != (i == 0) {
+				return "", false
+			}
+		}
+		if len(row) > cols {
+			cols = len(row)
+		}
+	}
+	var lines []string
+	for i, row := range t.Rows {
+		cells := make([]string, cols)
+		for j, c := range row {
+			cells[j] = strings.ReplaceAll(m.format(ctx, c.Content, c.Inlines), "|", `\|`)
+		}
+		lines = append(lines, "| "+strings.Join(cells, " | ")+" |")
+		if i == 0 {
+			lines = append(lines, "|"+strings.Repeat(" --- |", cols))
+		}
+	}
+	return strings.Join(lines, "\n"), true
+}
+
+func (m *markdownRenderer) blockquote(ctx context.Context, b *Blockquote) string {
+	blocks := m.blocks(ctx, b.Content)
+	if b.Cite != "" {
+		cite := b.Cite
+		if isURL(cite) {
+			cite = "[" + cite + "]"
+		}
+		blocks = append(blocks, "— "+m.format(ctx, cite, nil))
+	}
+	var lines []string
+	for _, line := range strings.Split(strings.Join(blocks, "\n\n"), "\n") {
+		if line == "" {
+			lines = append(lines, ">")
+		} else {
+			lines = append(lines, "> "+line)
+		}
+	}
+	if len(lines) == 0 {
+		return ">"
+	}
+	return strings.Join(lines, "\n")
+}
+
+func isURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+var reBacktickRun = regexp.MustCompile("`{3,}")
+
+// codeFence: 中身に含まれるどのバッククォートの連続よりも長いフェンスで囲む
+func codeFence(lang, text string) string {
+	fence := "```"
+	for _, run := range reBacktickRun.FindAllString(text, -1) {
+		if len(run) >= len(fence) {
+			fence = strings.Repeat("`", len(run)+1)
+		}
+	}
+	if text != "" {
+		text += "\n"
+	}
+	return fence + lang + "\n" + text + fence
+}
+
+func (m *markdownRenderer) footnotes(ctx context.Context) string {
+	var lines []string
+	for _, fn := range m.notes {
+		lines = append(lines, fmt.Sprintf("[^%d]: %s", fn.Number, m.format(ctx, fn.Note, nil)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func markdownLink(text, uri string) string {
+	return "[" + escapeMarkdown(text) + "](" + markdownDestination(uri) + ")"
+}
+
+func markdownImage(alt, uri string) string {
+	return "!" + markdownLink(alt, uri)
+}
+
+// markdownDestination: リンク先に使えない空白と括弧をパーセントエンコードする
+var markdownDestinationReplacer = strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29", "<", "%3C", ">", "%3E")
+
+func markdownDestination(uri string) string {
+	return markdownDestinationReplacer.Replace(uri)
+}
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"`", "\\`",
+	`*`, `\*`,
+	`_`, `\_`,
+	`[`, `\[`,
+	`]`, `\]`,
+	`~`, `\~`,
+)
+
+// 行頭にあるとブロック構文として解釈される文字列
+var reMarkdownLineStart = regexp.MustCompile(`(?m)^(?:#{1,6}(?:[ \t]|$)|>|[-+](?:[ \t]|$)|=+[ \t]*$|-+[ \t]*$|\d{1,9}[.)](?:[ \t]|$))`)
+
+// escapeMarkdown: テキストが Markdown の構文として解釈されないようにエスケープする
+// 行頭の判定はテキストの先頭も行頭とみなすので、行の途中では余分にエスケープすることがある
+func escapeMarkdown(s string) string {
+	s = markdownEscaper.Replace(s)
+	return reMarkdownLineStart.ReplaceAllStringFunc(s, func(m string) string {
+		if i := strings.IndexAny(m, ".)"); i > 0 && m[0] >= '0' && m[0] <= '9' {
+			return m[:i] + `\` + m[i:]
+		}
+		return `\` + m
+	})
+}
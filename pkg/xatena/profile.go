@@ -0,0 +1,40 @@
+package xatena
+
+// Profile は用途ごとに使える記法の組み合わせ
+// 無効にしたブロック記法の行は段落のテキストに、インライン記法はそのままの文字列になる
+type Profile struct {
+	DisabledBlocks      []string // 無効にするブロック記法 (BlockTable など)
+	DisabledInlineRules []string // 無効にするインライン記法 (InlineHTML など)
+}
+
+// WithProfile: プロファイルで指定した記法を無効にする
+func WithProfile(p Profile) Option {
+	return func(o *options) {
+		WithoutExtensions(p.DisabledBlocks...)(o)
+		WithoutInlineRules(p.DisabledInlineRules...)(o)
+	}
+}
+
+// ArticleProfile: 記事本文向け。すべての記法を使える
+func ArticleProfile() Profile {
+	return Profile{}
+}
+
+// CommentProfile: コメント向け。見出し・テーブル・続きを読むと、生の HTML・バーコード・脚注を使えない
+func CommentProfile() Profile {
+	return Profile{
+		DisabledBlocks:      []string{BlockSection, BlockTable, BlockStopP, BlockSeeMore},
+		DisabledInlineRules: []string{InlineHTML, InlineBarcode, InlineFootnote},
+	}
+}
+
+// BioProfile: プロフィール文向け。ブロック記法はすべて使えず、インラインもリンク程度に限る
+func BioProfile() Profile {
+	return Profile{
+		DisabledBlocks: []string{
+			BlockSeeMore, BlockSuperPre, BlockStopP, BlockBlockquote, BlockPre,
+			BlockList, BlockDefinitionList, BlockTable, BlockSection, BlockComment,
+		},
+		DisabledInlineRules: []string{InlineHTML, InlineBarcode, InlineFootnote, InlineTex},
+	}
+}
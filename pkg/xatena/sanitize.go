@@ -0,0 +1,239 @@
+package xatena
+
+import (
+	"context"
+	"strings"
+
+	"golang.org/x/net/html"
+
+	"github.com/cho45/xatena-go/internal/syntax"
+)
+
+// SanitizePolicy は安全モードで許可するタグ・属性・URL スキームの許可リスト
+// Xatena.SafeMode に設定すると、インライン記法の出力 (生 HTML を含む) をこの許可リストで絞り込む
+type SanitizePolicy struct {
+	// AllowedTags はタグ名 (小文字) → 許可する属性名の一覧
+	AllowedTags map[string][]string
+	// AllowedSchemes は href / src / cite で許可する URL スキーム。相対 URL は常に許可する
+	AllowedSchemes []string
+}
+
+// DefaultSanitizePolicy: ユーザーコメントなど信頼できない入力向けの既定の許可リストを返す
+func DefaultSanitizePolicy() *SanitizePolicy {
+	return &SanitizePolicy{
+		AllowedTags: map[string][]string{
+			"a":          {"href", "title"},
+			"abbr":       {"title"},
+			"b":          nil,
+			"blockquote": {"cite"},
+			"br":         nil,
+			"cite":       nil,
+			"code":       nil,
+			"dd":         nil,
+			"del":        {"datetime"},
+			"div":        {"class"},
+			"dl":         nil,
+			"dt":         nil,
+			"em":         nil,
+			"i":          nil,
+			"img":        {"src", "alt", "title", "width", "height"},
+			"ins":        {"datetime"},
+			"kbd":        nil,
+			"li":         nil,
+			"ol":         nil,
+			"p":          {"class"},
+			"pre":        {"class"},
+			"q":          {"cite"},
+			"rp":         nil,
+			"rt":         nil,
+			"ruby":       nil,
+			"s":          nil,
+			"small":      nil,
+			"span":       {"class"},
+			"strong":     nil,
+			"sub":        nil,
+			"sup":        nil,
+			"table":      nil,
+			"td":         nil,
+			"th":         nil,
+			"tr":         nil,
+			"u":          nil,
+			"ul":         nil,
+			"var":        nil,
+		},
+		AllowedSchemes: []string{"http", "https", "ftp", "mailto"},
+	}
+}
+
+// 中身ごと捨てる要素 (許可されていない場合)
+var sanitizeDropContent = map[string]bool{
+	"script":   true,
+	"style":    true,
+	"iframe":   true,
+	"noscript": true,
+	"noembed":  true,
+	"noframes": true,
+	"xmp":      true,
+	"textarea": true,
+	"title":    true,
+	"template": true,
+	"object":   true,
+}
+
+var sanitizeURLAttrs = map[string]bool{
+	"href": true,
+	"src":  true,
+	"cite": true,
+}
+
+// Sanitize: HTML 断片を許可リストに従って書き直す
+// 許可されないタグは取り除き (テキストは残す)、許可されない属性・危険な URL は落とす
+func (p *SanitizePolicy) Sanitize(s string) string {
+	z := html.NewTokenizer(strings.NewReader(s))
+	var b strings.Builder
+	b.Grow(len(s))
+	dropping := ""
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			return b.String()
+		case html.TextToken:
+			if dropping == "" {
+				b.WriteString(escapeText(string(z.Text())))
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			t := z.Token()
+			if dropping != "" {
+				continue
+			}
+			allowed, ok := p.AllowedTags[t.Data]
+			if !ok {
+				if tt == html.StartTagToken && sanitizeDropContent[t.Data] {
+					dropping = t.Data
+				}
+				continue
+			}
+			b.WriteString("<" + t.Data)
+			for _, a := range t.Attr {
+				if a.Namespace != "" || !containsString(allowed, a.Key) || strings.HasPrefix(a.Key, "on") {
+					continue
+				}
+				if sanitizeURLAttrs[a.Key] && !p.allowedURL(a.Val) {
+					continue
+				}
+				b.WriteString(" " + a.Key + `="` + html.EscapeString(a.Val) + `"`)
+			}
+			if tt == html.SelfClosingTagToken {
+				b.WriteString(" />")
+			} else {
+				b.WriteString(">")
+			}
+		case html.EndTagToken:
+			t := z.Token()
+			if dropping != "" {
+				if t.Data == dropping {
+					dropping = ""
+				}
+				continue
+			}
+			if _, ok := p.AllowedTags[t.Data]; ok {
+				b.WriteString("</" + t.Data + ">")
+			}
+		case html.CommentToken:
+			if dropping == "" {
+				b.WriteString("<!-- -->")
+			}
+		}
+	}
+}
+
+// allowedURL: スキームが許可リストにあるか、スキームを持たない相対 URL なら true
+func (p *SanitizePolicy) allowedURL(v string) bool {
+	// ブラウザはスキーム中の空白・制御文字を無視するので取り除いてから判定する
+	v = strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, v)
+	i := strings.IndexAny(v, ":/?#")
+	if i < 0 || v[i] != ':' {
+		return true
+	}
+	return containsString(p.AllowedSchemes, strings.ToLower(v[:i]))
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+var textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// escapeText: テキストノード用の最小限のエスケープ (引用符はそのまま残す)
+func escapeText(s string) string {
+	return textEscaper.Replace(s)
+}
+
+// sanitizingInline はインライン整形結果をサニタイズする Inline
+type sanitizingInline struct {
+	syntax.Inline
+	policy *SanitizePolicy
+}
+
+// Format: InlineFormatter なら要素ごとに整形し、ライブラリ自身が組み立てる要素 (QR コードなど) はサニタイズしない
+// それ以外の要素はまとめてサニタイズする
+func (s sanitizingInline) Format(ctx context.Context, text string) string {
+	if f, ok := s.Inline.(*InlineFormatter); ok {
+		return s.render(ctx, f, f.Parse(ctx, text))
+	}
+	return s.policy.Sanitize(s.Inline.Format(ctx, text))
+}
+
+// render: nodes を r で出力する。信頼できない要素は連続するものをまとめてサニタイズする
+func (s sanitizingInline) render(ctx context.Context, r syntax.InlineRenderer, nodes []InlineNode) string {
+	var b strings.Builder
+	var untrusted []InlineNode
+	flush := func() {
+		if len(untrusted) > 0 {
+			b.WriteString(s.policy.Sanitize(r.Render(ctx, untrusted)))
+			untrusted = nil
+		}
+	}
+	for _, n := range nodes {
+		if !trustedInline(n) {
+			untrusted = append(untrusted, n)
+			continue
+		}
+		flush()
+		b.WriteString(r.Render(ctx, []InlineNode{n}))
+	}
+	flush()
+	return b.String()
+}
+
+// sanitizingRenderer はパース済みの要素を出力できる Inline 用の sanitizingInline
+// ParseInlines で設定・書き換えた要素も Format と同じようにサニタイズして出力する
+type sanitizingRenderer struct {
+	sanitizingInline
+}
+
+func (s sanitizingRenderer) Render(ctx context.Context, nodes []InlineNode) string {
+	return s.render(ctx, s.Inline.(syntax.InlineRenderer), nodes)
+}
+
+// trustedInline: 出力をライブラリが組み立て、入力の文字列はエスケープして埋め込むインライン要素なら true
+// QR コードの data: URI の <img> や数式の MathML は許可リストでは通せないので、サニタイズせずにそのまま出力する
+// (数式は TexRenderer の出力をそのまま使うので、独自の TexRenderer は安全な HTML を返すこと)
+func trustedInline(n InlineNode) bool {
+	switch n.(type) {
+	case *Barcode, *Tex:
+		return true
+	}
+	return false
+}
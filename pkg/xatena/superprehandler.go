@@ -0,0 +1,142 @@
+package xatena
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/cho45/xatena-go/internal/syntax"
+	"github.com/cho45/xatena-go/pkg/texmath"
+)
+
+// SuperPreHandler は >|lang| ... ||< のブロックを言語ごとに HTML にする
+// エラーを返すと通常どおり <pre class="code"> として出力する
+type SuperPreHandler interface {
+	HandleSuperPre(ctx context.Context, x *Xatena, block *SuperPre) (string, error)
+}
+
+// SuperPreHandlerFunc は関数を SuperPreHandler にする
+type SuperPreHandlerFunc func(ctx context.Context, x *Xatena, block *SuperPre) (string, error)
+
+func (f SuperPreHandlerFunc) HandleSuperPre(ctx context.Context, x *Xatena, block *SuperPre) (string, error) {
+	return f(ctx, x, block)
+}
+
+// WithSuperPreHandlers: 言語名ごとに super pre の出力を handlers に任せる (既定ではどの言語も <pre class="code">)
+func WithSuperPreHandlers(handlers map[string]SuperPreHandler) Option {
+	return func(o *options) {
+		if o.superPreHandlers == nil {
+			o.superPreHandlers = map[string]SuperPreHandler{}
+		}
+		for lang, h := range handlers {
+			o.superPreHandlers[strings.ToLower(lang)] = h
+		}
+	}
+}
+
+// SetSuperPreHandler: >|lang| のブロックを h で出力する (nil で登録を取り消す)。言語名の大文字と小文字は区別しない
+func (x *Xatena) SetSuperPreHandler(lang string, h SuperPreHandler) {
+	lang = strings.ToLower(lang)
+	if h == nil {
+		delete(x.SuperPreHandlers, lang)
+		return
+	}
+	if x.SuperPreHandlers == nil {
+		x.SuperPreHandlers = map[string]SuperPreHandler{}
+	}
+	x.SuperPreHandlers[lang] = h
+}
+
+// RenderSuperPre: 言語に SuperPreHandler が登録されていればそれで出力する (syntax.SuperPreRenderer)
+func (x *Xatena) RenderSuperPre(ctx context.Context, s *syntax.SuperPreNode) (string, bool) {
+	h, ok := x.SuperPreHandlers[strings.ToLower(s.Lang)]
+	if !ok {
+		return "", false
+	}
+	out, err := h.HandleSuperPre(ctx, x, s)
+	if err != nil {
+		return "", false
+	}
+	return "\n" + out + "\n", true
+}
+
+// BuiltinSuperPreHandlers: csv・tsv を表、math を数式、mermaid・dot を図の <div> にする
+// 生の HTML をそのまま出力する RawHTMLHandler は含まない (信頼できる入力でだけ登録する)
+func BuiltinSuperPreHandlers() map[string]SuperPreHandler {
+	return map[string]SuperPreHandler{
+		"csv":     TableHandler{Comma: ',', Header: true},
+		"tsv":     TableHandler{Comma: '\t', Header: true},
+		"math":    DisplayMathHandler,
+		"mermaid": DiagramHandler,
+		"dot":     DiagramHandler,
+	}
+}
+
+// sourcePosAttr: SourcePos オプションが有効なときの data-sourcepos 属性
+func sourcePosAttr(x *Xatena, p Position) string {
+	if pos := SourcePos(x, p); pos != "" {
+		return ` data-sourcepos="` + pos + `"`
+	}
+	return ""
+}
+
+// TableHandler は CSV・TSV のブロックを <table class="lang-csv"> にする。セルの中身はエスケープだけする
+type TableHandler struct {
+	Comma  rune // 区切り文字 (0 なら ',')
+	Header bool // true なら1行目を見出し (<th>) にする
+}
+
+func (t TableHandler) HandleSuperPre(ctx context.Context, x *Xatena, block *SuperPre) (string, error) {
+	r := csv.NewReader(strings.NewReader(block.RawText))
+	if t.Comma != 0 {
+		r.Comma = t.Comma
+	}
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	b.WriteString(`<table class="lang-` + html.EscapeString(strings.ToLower(block.Lang)) + `"` + sourcePosAttr(x, block.Position) + ">")
+	for i, record := range records {
+		cell := "td"
+		if i == 0 && t.Header {
+			cell = "th"
+		}
+		b.WriteString("\n  <tr>")
+		for _, field := range record {
+			b.WriteString("\n    <" + cell + ">" + html.EscapeString(field) + "</" + cell + ">")
+		}
+		b.WriteString("\n  </tr>")
+	}
+	b.WriteString("\n</table>")
+	return b.String(), nil
+}
+
+// DisplayMathHandler はブロック全体を1つの数式として <div class="math"> の中の MathML (display="block") にする
+var DisplayMathHandler SuperPreHandler = SuperPreHandlerFunc(func(ctx context.Context, x *Xatena, block *SuperPre) (string, error) {
+	math, err := texmath.ToDisplayMathML(block.RawText)
+	if err != nil {
+		return "", err
+	}
+	return `<div class="math"` + sourcePosAttr(x, block.Position) + ">" + math + "</div>", nil
+})
+
+// DiagramHandler はブロックをエスケープして <div class="diagram mermaid"> (言語名を class に付ける) に入れる
+// mermaid.js などのクライアント側のツールで図にする
+var DiagramHandler SuperPreHandler = SuperPreHandlerFunc(func(ctx context.Context, x *Xatena, block *SuperPre) (string, error) {
+	return fmt.Sprintf(`<div class="diagram %s"%s>%s</div>`,
+		html.EscapeString(strings.ToLower(block.Lang)), sourcePosAttr(x, block.Position), html.EscapeString(block.RawText)), nil
+})
+
+// RawHTMLHandler はブロックの中身を HTML としてそのまま出力する。安全モード (SafeMode) では使えない
+var RawHTMLHandler SuperPreHandler = SuperPreHandlerFunc(func(ctx context.Context, x *Xatena, block *SuperPre) (string, error) {
+	if x.SafeMode != nil {
+		return "", errors.New("raw html block is not allowed in safe mode")
+	}
+	return block.RawText, nil
+})
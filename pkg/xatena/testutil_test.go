@@ -53,6 +53,25 @@ func parseTestBlocks(data string) []TestBlock {
 	return parseTestBlocksWithDelim(data, "===", "---")
 }
 
+// allTestInputs は各記法のテストフィクスチャの input をすべて返す
+// (ToHTML と別経路の出力が一致するかを確かめるテストで使う)
+func allTestInputs() []string {
+	var inputs []string
+	for _, data := range []string{
+		blockquoteTestData, commentTestData, complexTestData, definitionListTestData,
+		footnoteTestData, listTestData, paragraphTestData, preTestData, sectionTestData,
+		seeMoreTestData, stoppTestData, superPreTestData, tableTestData,
+	} {
+		for _, b := range parseTestBlocks(data) {
+			inputs = append(inputs, b.Sections["input"])
+		}
+	}
+	for _, b := range parseTestBlocksWithDelim(superPre2TestData, "###", ":::") {
+		inputs = append(inputs, b.Sections["input"])
+	}
+	return inputs
+}
+
 // EqualHTML は2つのHTML文字列を構造的に比較し、異なればt.Errorfで詳細を出力する
 func EqualHTML(t *testing.T, a, b string) {
 	nodeA, errA := html.Parse(strings.NewReader(a))
@@ -0,0 +1,44 @@
+package xatena
+
+import (
+	"context"
+	"html"
+
+	"github.com/cho45/xatena-go/pkg/texmath"
+)
+
+// TexRenderer は [tex:...] の数式を HTML にする
+// エラーを返すと数式の元の文字列を <code class="tex"> に入れて出力する
+type TexRenderer interface {
+	RenderTex(ctx context.Context, expr string) (string, error)
+}
+
+// TexRendererFunc は関数を TexRenderer にする
+type TexRendererFunc func(ctx context.Context, expr string) (string, error)
+
+func (f TexRendererFunc) RenderTex(ctx context.Context, expr string) (string, error) {
+	return f(ctx, expr)
+}
+
+// MathMLTexRenderer は texmath で数式を MathML にする (既定の TexRenderer)
+var MathMLTexRenderer TexRenderer = TexRendererFunc(func(ctx context.Context, expr string) (string, error) {
+	return texmath.ToMathML(expr)
+})
+
+// SetTexRenderer: [tex:...] の出力に使う TexRenderer を変える (nil で MathMLTexRenderer に戻す)
+func (f *InlineFormatter) SetTexRenderer(r TexRenderer) {
+	f.texRenderer = r
+}
+
+// renderTex: 数式を HTML にする。変換できなければエスケープした元の文字列を <code class="tex"> に入れる
+func (f *InlineFormatter) renderTex(ctx context.Context, expr string) string {
+	r := f.texRenderer
+	if r == nil {
+		r = MathMLTexRenderer
+	}
+	out, err := r.RenderTex(ctx, expr)
+	if err != nil {
+		return `<code class="tex">` + html.EscapeString(expr) + `</code>`
+	}
+	return out
+}
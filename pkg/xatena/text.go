@@ -0,0 +1,339 @@
+package xatena
+
+import (
+	"context"
+	"html"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// プレーンテキスト出力
+// 検索インデックスや <meta name="description"> の抜粋向けに、マークアップを落としたテキストにする。
+// リストとテーブルは行の形を保ち、コメントと脚注は出力しない。
+
+// TextOptions は ToText の出力を調整する
+type TextOptions struct {
+	StopAtSeeMore bool   // 最初の続きを読む (====) より後を出力しない
+	MaxChars      int    // 0 より大きければこの文字数 (rune 数) 以内に切り詰める
+	Ellipsis      string // 切り詰めたときに末尾に付ける文字列 (MaxChars に含める)
+}
+
+// ToText: 入力をプレーンテキストに変換する
+// Limits の上限を超えたり ctx が終了した場合は空文字列を返す
+func (x *Xatena) ToText(ctx context.Context, input string, opts TextOptions) string {
+	doc, err := x.parseXatena(ctx, input)
+	if err != nil {
+		return ""
+	}
+	var sb strings.Builder
+	x.WriteText(ctx, &sb, doc, opts)
+	return sb.String()
+}
+
+// WriteText: 文書ツリーをプレーンテキストで w へ書き出す
+// タイトル取得の数が Limits の上限を超えるか ctx が終了すると、何も書き出さずにその理由を返す
+func (x *Xatena) WriteText(ctx context.Context, w io.Writer, doc *Document, opts TextOptions) error {
+	t := &textRenderer{
+		parser: inlineParserOf(x.Inline),
+		plain:  plainText,
+		opts:   opts,
+	}
+	if t.parser.disabled[InlineHTML] {
+		// HTML を書けない設定ではタグも本文の文字列として残す
+		t.plain = stripTextMarkers
+	}
+	ctx, done := x.beginRender(ctx, doc)
+	blocks := t.blocks(ctx, doc.Content)
+	if err := done(nil); err != nil {
+		return err
+	}
+	text := truncateText(strings.Join(blocks, "\n\n"), opts.MaxChars, opts.Ellipsis)
+	if text == "" {
+		return nil
+	}
+	_, err := io.WriteString(w, text)
+	return err
+}
+
+// RenderText: デフォルト設定の Xatena で文書ツリーをプレーンテキストに変換する
+func RenderText(ctx context.Context, doc *Document, opts TextOptions) string {
+	var sb strings.Builder
+	defaultXatena.WriteText(ctx, &sb, doc, opts)
+	return sb.String()
+}
+
+type textRenderer struct {
+	parser  *InlineFormatter    // インライン記法のパースとタイトルの取得に使う
+	plain   func(string) string // 記法ではない文字列の変換
+	opts    TextOptions
+	stopped bool // StopAtSeeMore で続きを読むに達した
+}
+
+func (t *textRenderer) blocks(ctx context.Context, nodes []Node) []string {
+	var blocks []string
+	var textBuf []*Text
+	flush := func() {
+		if len(textBuf) > 0 {
+			blocks = append(blocks, t.paragraphs(ctx, textBuf)...)
+			textBuf = nil
+		}
+	}
+	for _, n := range nodes {
+		if t.stopped || ctx.Err() != nil {
+			break
+		}
+		if tn, ok := n.(*Text); ok {
+			textBuf = append(textBuf, tn)
+			continue
+		}
+		flush()
+		if b := t.block(ctx, n); strings.TrimSpace(b) != "" {
+			blocks = append(blocks, b)
+		}
+	}
+	flush()
+	return blocks
+}
+
+// paragraphs: HTML と同じく連続する行をまとめて整形し、空行で段落に分ける
+func (t *textRenderer) paragraphs(ctx context.Context, lines []*Text) []string {
+	var paras []string
+	for _, para := range reParagraphSep.Split(t.format(ctx, paragraphText(lines), lines[0].Inlines), -1) {
+		lines := strings.Split(para, "\n")
+		kept := lines[:0]
+		for _, line := range lines {
+			if line = strings.TrimSpace(line); line != "" {
+				kept = append(kept, line)
+			}
+		}
+		if len(kept) > 0 {
+			paras = append(paras, strings.Join(kept, "\n"))
+		}
+	}
+	return paras
+}
+
+// format: パース済みのインライン要素があればそれを、なければ text をパースしてプレーンテキストにする
+func (t *textRenderer) format(ctx context.Context, text string, nodes []InlineNode) string {
+	if nodes == nil {
+		nodes = t.parser.Parse(ctx, text)
+	}
+	return t.inlines(ctx, nodes)
+}
+
+// inlines: インライン要素からマークアップを落とす。脚注とコメントは出力しない
+func (t *textRenderer) inlines(ctx context.Context, nodes []InlineNode) string {
+	var b strings.Builder
+	for _, n := range nodes {
+		switch v := n.(type) {
+		case *InlineText:
+			b.WriteString(t.plain(v.Text))
+		case *Link:
+			title := v.URL
+			switch {
+			case v.Title != "":
+				title = v.Title
+			case v.FetchTitle:
+				title = t.parser.fetchTitle(ctx, v.URL)
+			}
+			b.WriteString(textAtom(stripTextMarkers(title)))
+		case *RawHTML:
+			// <a>...</a> はリンクの文字列を残し、単独のタグは取り除く
+			b.WriteString(textAtom(plainText(v.HTML)))
+		case *Tex:
+			b.WriteString(textAtom(v.Expr))
+		case *MailTo:
+			b.WriteString(textAtom(v.Address))
+		case *Barcode:
+			b.WriteString(textAtom(v.URL))
+		}
+	}
+	return b.String()
+}
+
+func (t *textRenderer) block(ctx context.Context, n Node) string {
+	switch v := n.(type) {
+	case *Section:
+		title := strings.TrimSpace(t.format(ctx, v.Title, v.TitleInlines))
+		return strings.Join(append([]string{title}, t.blocks(ctx, v.Content)...), "\n\n")
+	case *List:
+		var lists []string
+		for _, l := range v.Items {
+			lists = append(lists, t.list(ctx, l, ""))
+		}
+		return strings.Join(lists, "\n")
+	case *Table:
+		var rows []string
+		for _, row := range v.Rows {
+			cells := make([]string, 0, len(row))
+			for _, c := range row {
+				cells = append(cells, strings.TrimSpace(t.format(ctx, c.Content, c.Inlines)))
+			}
+			rows = append(rows, strings.Join(cells, " | "))
+		}
+		return strings.Join(rows, "\n")
+	case *DefinitionList:
+		var lines []string
+		for _, item := range v.Items {
+			term := strings.TrimSpace(t.format(ctx, item.Term, item.TermInlines))
+			var descs []string
+			for i, d := range item.Descs {
+				var inlines []InlineNode
+				if i < len(item.DescInlines) {
+					inlines = item.DescInlines[i]
+				}
+				descs = append(descs, strings.TrimSpace(t.format(ctx, d, inlines)))
+			}
+			switch {
+			case term == "":
+				lines = append(lines, descs...)
+			case len(descs) == 0:
+				lines = append(lines, term)
+			default:
+				lines = append(lines, term+": "+strings.Join(descs, " "))
+			}
+		}
+		return strings.Join(lines, "\n")
+	case *SuperPre:
+		return stripTextMarkers(v.RawText)
+	case *Pre:
+		// 整形済みテキストなので段落に分けず行をそのまま残す (連続する行は HTML と同じくまとめて整形する)
+		var lines []string
+		var run []*Text
+		flush := func() {
+			if len(run) > 0 {
+				lines = append(lines, t.format(ctx, paragraphText(run), run[0].Inlines))
+				run = nil
+			}
+		}
+		for _, c := range v.Content {
+			if tn, ok := c.(*Text); ok {
+				run = append(run, tn)
+				continue
+			}
+			flush()
+			if b := t.block(ctx, c); b != "" {
+				lines = append(lines, b)
+			}
+		}
+		flush()
+		return strings.Trim(strings.Join(lines, "\n"), "\n")
+	case *Blockquote:
+		blocks := t.blocks(ctx, v.Content)
+		if v.Cite != "" {
+			cite := v.Cite
+			if isURL(cite) {
+				cite = "[" + cite + "]"
+			}
+			blocks = append(blocks, "— "+strings.TrimSpace(t.format(ctx, cite, nil)))
+		}
+		return strings.Join(blocks, "\n\n")
+	case *SeeMore:
+		if t.opts.StopAtSeeMore {
+			t.stopped = true
+			return ""
+		}
+		return strings.Join(t.blocks(ctx, v.Content), "\n\n")
+	case *Comment:
+		return ""
+	case HasContent:
+		return strings.Join(t.blocks(ctx, v.GetContent()), "\n\n")
+	}
+	return ""
+}
+
+func (t *textRenderer) list(ctx context.Context, l *ListStruct, indent string) string {
+	var lines []string
+	for i, item := range l.Items {
+		marker := "-"
+		if l.Name == "ol" {
+			marker = strconv.Itoa(i+1) + "."
+		}
+		first := true
+		for _, c := range item.Content {
+			switch v := c.(type) {
+			case string:
+				lines = append(lines, indent+marker+" "+strings.TrimSpace(t.format(ctx, v, item.Inlines)))
+			case *ListStruct:
+				if first {
+					lines = append(lines, indent+marker)
+				}
+				lines = append(lines, t.list(ctx, v, indent+"  "))
+			}
+			first = false
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// 記法1つ分の出力は textAtomStart / textAtomEnd で囲み、切り詰めのときに途中で切らないようにする
+// (Unicode の私用領域の文字を使い、入力に含まれていたものは取り除く)
+const (
+	textAtomStart = '\uE000'
+	textAtomEnd   = '\uE001'
+)
+
+func textAtom(s string) string {
+	if s == "" {
+		return ""
+	}
+	return string(textAtomStart) + s + string(textAtomEnd)
+}
+
+func stripTextMarkers(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == textAtomStart || r == textAtomEnd {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+var reTextTag = regexp.MustCompile(`<[^>]*>`)
+
+// plainText: HTML 断片からタグを落とし、文字参照を戻す
+func plainText(s string) string {
+	return html.UnescapeString(reTextTag.ReplaceAllString(stripTextMarkers(s), ""))
+}
+
+// truncateText: 記法の途中で切らないように max 文字以内へ切り詰め、目印を取り除く
+func truncateText(s string, max int, ellipsis string) string {
+	if max <= 0 || utf8.RuneCountInString(stripTextMarkers(s)) <= max {
+		return stripTextMarkers(s)
+	}
+	limit := max - utf8.RuneCountInString(ellipsis)
+	if limit < 0 {
+		limit = 0
+	}
+	var b strings.Builder
+	n := 0
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == textAtomStart {
+			end := strings.IndexRune(s[i:], textAtomEnd)
+			if end < 0 {
+				end = len(s) - i
+			}
+			atom := s[i+size : i+end]
+			count := utf8.RuneCountInString(atom)
+			if n+count > limit {
+				break
+			}
+			b.WriteString(atom)
+			n += count
+			i += end + utf8.RuneLen(textAtomEnd)
+			continue
+		}
+		if n+1 > limit {
+			break
+		}
+		b.WriteRune(r)
+		n++
+		i += size
+	}
+	return strings.TrimRightFunc(b.String(), unicode.IsSpace) + ellipsis
+}
@@ -0,0 +1,325 @@
+package xatena
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// [url:title] のタイトル取得
+// TitleResolver を設定すると、レンダリングの前に文書中の :title の URL を集めて並行に取得しておき、
+// インライン記法の整形ではその結果を使う。同じ URL は1度だけ取得する
+
+// TitleResolver は URL のページタイトルを取得する
+type TitleResolver interface {
+	ResolveTitle(ctx context.Context, uri string) (string, error)
+}
+
+// TitleResolverFunc は関数を TitleResolver として使う
+type TitleResolverFunc func(ctx context.Context, uri string) (string, error)
+
+func (f TitleResolverFunc) ResolveTitle(ctx context.Context, uri string) (string, error) {
+	return f(ctx, uri)
+}
+
+// DefaultTitleWorkers は Xatena.TitleWorkers が 0 のときに並行に取得する数
+const DefaultTitleWorkers = 4
+
+// WithTitleResolver: [url:title] のタイトルを r でレンダリングの前に並行に取得する
+// workers は同時に取得する数の上限 (0 なら DefaultTitleWorkers)
+func WithTitleResolver(r TitleResolver, workers int) Option {
+	return func(o *options) {
+		o.titleResolver = r
+		o.titleWorkers = workers
+	}
+}
+
+// TitleHandler: r を InlineFormatter.SetTitleHandler に渡せる形にする。取得に失敗したら uri を返す
+func TitleHandler(r TitleResolver) func(ctx context.Context, uri string) string {
+	return func(ctx context.Context, uri string) string {
+		title, err := r.ResolveTitle(ctx, uri)
+		if err != nil || title == "" {
+			return uri
+		}
+		return title
+	}
+}
+
+// resolvedTitles は1回のレンダリングで取得したタイトル
+type resolvedTitles struct {
+	resolver TitleResolver
+	titles   map[string]string
+}
+
+type resolvedTitlesKey struct{}
+
+// prefetchedTitles: withResolvedTitles で ctx に設定したタイトルを返す。TitleResolver が設定されていなければ nil
+func prefetchedTitles(ctx context.Context) *resolvedTitles {
+	r, _ := ctx.Value(resolvedTitlesKey{}).(*resolvedTitles)
+	return r
+}
+
+// withResolvedTitles: doc 中の :title の URL を TitleResolver で並行に取得し、結果を ctx に設定する
+func (x *Xatena) withResolvedTitles(ctx context.Context, doc *Document) context.Context {
+	if x.TitleResolver == nil {
+		return ctx
+	}
+	urls := x.titleURLs(ctx, doc)
+	if max := x.Limits.MaxTitleFetches; max > 0 && len(urls) > max {
+		// 上限を超える分はレンダリング中に LimitError になるので取得しない
+		urls = urls[:max]
+	}
+	workers := x.TitleWorkers
+	if workers <= 0 {
+		workers = DefaultTitleWorkers
+	}
+	countTitleFetches(ctx, urls)
+	titles := resolveTitles(ctx, x.TitleResolver, urls, workers)
+	return context.WithValue(ctx, resolvedTitlesKey{}, &resolvedTitles{resolver: x.TitleResolver, titles: titles})
+}
+
+// resolveTitles: urls のタイトルを最大 workers 個ずつ並行に取得する
+func resolveTitles(ctx context.Context, r TitleResolver, urls []string, workers int) map[string]string {
+	handler := TitleHandler(r)
+	titles := make(map[string]string, len(urls))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	queue := make(chan string)
+	for i := 0; i < workers && i < len(urls); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for uri := range queue {
+				title := handler(ctx, uri)
+				mu.Lock()
+				titles[uri] = title
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, uri := range urls {
+		if ctx.Err() != nil {
+			break
+		}
+		queue <- uri
+	}
+	close(queue)
+	wg.Wait()
+	return titles
+}
+
+// titleURLs: doc 中でタイトルを取得する [url:title] の URL を、重複を除いて出現順に返す
+// Inline がパースに対応していなければ何も返さない (タイトルは整形中に取得する)
+func (x *Xatena) titleURLs(ctx context.Context, doc *Document) []string {
+	p, ok := x.Inline.(inlineParser)
+	if !ok {
+		return nil
+	}
+	var urls []string
+	seen := map[string]bool{}
+	var collect func(nodes []InlineNode)
+	collect = func(nodes []InlineNode) {
+		for _, n := range nodes {
+			switch v := n.(type) {
+			case *Link:
+				if v.FetchTitle && v.Title == "" && !seen[v.URL] {
+					seen[v.URL] = true
+					urls = append(urls, v.URL)
+				}
+			case *FootnoteRef:
+				collect(p.Parse(ctx, v.Note))
+			}
+		}
+	}
+	parse := func(inlines []InlineNode, text string) {
+		if inlines == nil {
+			inlines = p.Parse(ctx, text)
+		}
+		collect(inlines)
+	}
+	for _, n := range doc.Content {
+		Walk(n, func(n Node) bool {
+			switch v := n.(type) {
+			case *Text:
+				parse(v.Inlines, v.Text)
+			case *Section:
+				parse(v.TitleInlines, v.Title)
+			case *List:
+				for _, l := range v.Items {
+					listTitleURLs(l, parse)
+				}
+			case *Table:
+				for _, row := range v.Rows {
+					for _, cell := range row {
+						parse(cell.Inlines, cell.Content)
+					}
+				}
+			case *DefinitionList:
+				for _, item := range v.Items {
+					parse(item.TermInlines, item.Term)
+					for j, d := range item.Descs {
+						var inlines []InlineNode
+						if j < len(item.DescInlines) {
+							inlines = item.DescInlines[j]
+						}
+						parse(inlines, d)
+					}
+				}
+			}
+			return true
+		})
+	}
+	return urls
+}
+
+func listTitleURLs(l *ListStruct, parse func(inlines []InlineNode, text string)) {
+	for _, item := range l.Items {
+		for _, c := range item.Content {
+			switch v := c.(type) {
+			case string:
+				parse(item.Inlines, v)
+			case *ListStruct:
+				listTitleURLs(v, parse)
+			}
+		}
+	}
+}
+
+// TitleCache はタイトルのキャッシュ
+type TitleCache interface {
+	Get(uri string) (title string, ok bool)
+	Set(uri, title string)
+}
+
+// CachedTitleResolver は取得に成功したタイトルを Cache に保存し、次からはそちらを返す
+type CachedTitleResolver struct {
+	Resolver TitleResolver
+	Cache    TitleCache
+}
+
+func (c *CachedTitleResolver) ResolveTitle(ctx context.Context, uri string) (string, error) {
+	if title, ok := c.Cache.Get(uri); ok {
+		return title, nil
+	}
+	title, err := c.Resolver.ResolveTitle(ctx, uri)
+	if err != nil {
+		return "", err
+	}
+	c.Cache.Set(uri, title)
+	return title, nil
+}
+
+// LRUTitleCache はメモリ上のキャッシュ。件数が上限を超えると最も長く使われていないものから捨てる
+// 複数の goroutine から同時に使ってよい
+type LRUTitleCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // 先頭ほど最近使ったもの。要素は *lruEntry
+	entries  map[string]*list.Element
+}
+
+type lruEntry struct {
+	uri, title string
+}
+
+func NewLRUTitleCache(capacity int) *LRUTitleCache {
+	return &LRUTitleCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  map[string]*list.Element{},
+	}
+}
+
+func (c *LRUTitleCache) Get(uri string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[uri]
+	if !ok {
+		return "", false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*lruEntry).title, true
+}
+
+func (c *LRUTitleCache) Set(uri, title string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[uri]; ok {
+		e.Value.(*lruEntry).title = title
+		c.order.MoveToFront(e)
+		return
+	}
+	c.entries[uri] = c.order.PushFront(&lruEntry{uri: uri, title: title})
+	for c.capacity > 0 && c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).uri)
+	}
+}
+
+// Len: キャッシュしている件数
+func (c *LRUTitleCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// DiskTitleCache はディレクトリにタイトルを1件1ファイルで保存するキャッシュ
+// ファイル名は URL の SHA-256。更新時刻から TTL を過ぎたものは無いものとして扱う (0 なら期限なし)
+// 書き込みに失敗してもエラーにはせず、キャッシュしないだけにする
+type DiskTitleCache struct {
+	Dir string
+	TTL time.Duration
+}
+
+func NewDiskTitleCache(dir string, ttl time.Duration) *DiskTitleCache {
+	return &DiskTitleCache{Dir: dir, TTL: ttl}
+}
+
+func (c *DiskTitleCache) path(uri string) string {
+	sum := sha256.Sum256([]byte(uri))
+	return filepath.Join(c.Dir, hex.EncodeToString(sum[:]))
+}
+
+func (c *DiskTitleCache) Get(uri string) (string, bool) {
+	path := c.path(uri)
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", false
+	}
+	if c.TTL > 0 && time.Since(info.ModTime()) > c.TTL {
+		return "", false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
+
+func (c *DiskTitleCache) Set(uri, title string) {
+	if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+		return
+	}
+	// 読み込み中のプロセスが書きかけのファイルを見ないよう、一時ファイルに書いてから置き換える
+	tmp, err := os.CreateTemp(c.Dir, ".tmp-*")
+	if err != nil {
+		return
+	}
+	_, err = tmp.WriteString(title)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	if err := os.Rename(tmp.Name(), c.path(uri)); err != nil {
+		os.Remove(tmp.Name())
+	}
+}
@@ -2,7 +2,9 @@ package xatena
 
 import (
 	"context"
+	"fmt"
 	htmltpl "html/template"
+	"io"
 	"strings"
 
 	"github.com/cho45/xatena-go/internal/syntax"
@@ -15,60 +17,98 @@ type Xatena struct {
 	Inline           syntax.Inline
 	Templates        map[string]*htmltpl.Template // テンプレート名→テンプレート
 	HatenaCompatible bool                         // Hatena互換モードを使用するかどうか
-	blockParsers     []syntax.BlockParser         // BlockParser のキャッシュ
+	SafeMode         *SanitizePolicy              // nil 以外なら安全モード: インライン出力を許可リストでサニタイズする
+	SourcePos        bool                         // ブロック要素に data-sourcepos="開始行-終了行" 属性を出力する
+	Limits           Limits                       // 入力の大きさや記法の数の上限 (0 の項目は制限しない)
+	TitleResolver    TitleResolver                // nil 以外なら [url:title] のタイトルをレンダリングの前にまとめて取得する
+	TitleWorkers     int                          // TitleResolver で同時に取得する数 (0 なら DefaultTitleWorkers)
+	Highlighter      Highlighter                  // nil 以外なら super pre のコードを色分けする
+	SuperPreHandlers map[string]SuperPreHandler   // 言語名 (小文字)→super pre の出力 (登録のない言語は <pre class="code">)
+	blockParsers     []namedBlockParser           // 試す順に並んだ BlockParser (blockparser.go の API で変更する)
+	dispatch         *blockDispatch               // blockParsers から作った行ごとの振り分け
+	extensions       []string                     // Use で登録した拡張の名前
 }
 
 func NewXatenaWithFields(inline syntax.Inline, hatenaCompatible bool) *Xatena {
-	sectionTemplate := syntax.SectionTemplate
-	if hatenaCompatible {
-		sectionTemplate = syntax.HatenaCompatibleSectionTemplate
+	return NewXatena(WithInline(inline), WithHatenaCompatible(hatenaCompatible))
+}
+
+func NewXatenaWithInline(inline syntax.Inline) *Xatena {
+	return NewXatena(WithInline(inline))
+}
+
+// NewXatena: 組み込みの記法をすべて有効にした Xatena を作る (MustNewXatena と同じ)
+// WithoutExtensions で組み込みの記法を外し、WithExtensions で独自の記法を追加できる
+// オプションが不正だと (拡張の名前の重複など) panic するので、オプションを実行時に組み立てるなら NewXatenaE を使う
+func NewXatena(opts ...Option) *Xatena {
+	return MustNewXatena(opts...)
+}
+
+// MustNewXatena: NewXatenaE がエラーを返したら panic する
+func MustNewXatena(opts ...Option) *Xatena {
+	x, err := NewXatenaE(opts...)
+	if err != nil {
+		panic("xatena: " + err.Error())
+	}
+	return x
+}
+
+// NewXatenaE: NewXatena と同じだが、拡張の登録に失敗したり、WithoutInlineRules を
+// *InlineFormatter 以外のインラインと組み合わせたりしたらエラーを返す
+func NewXatenaE(opts ...Option) (*Xatena, error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	inline := o.inline
+	if inline == nil {
+		inline = NewInlineFormatter()
+	}
+	if len(o.disabledInline) > 0 {
+		f, ok := inline.(*InlineFormatter)
+		if !ok {
+			return nil, fmt.Errorf("inline %T does not support disabling rules", inline)
+		}
+		inline = f.WithoutRules(o.disabledInline...)
 	}
 	x := &Xatena{
 		Inline: inline,
 		Templates: map[string]*htmltpl.Template{
-			"blockquote":     syntax.BlockquoteTemplate,
-			"definitionlist": syntax.DefinitionListTemplate,
-			"list":           syntax.ListTemplate,
-			"section":        sectionTemplate,
-			"table":          syntax.TableTemplate,
-			"seemore":        syntax.SeeMoreTemplate,
-			"pre":            syntax.PreTemplate,
-			"stopp":          syntax.StopPTemplate,
-			"superpre":       syntax.SuperPreTemplate,
-			"comment":        syntax.CommentTemplate,
+			"footnote": FootnoteTemplate,
 		},
-		HatenaCompatible: hatenaCompatible,
-	}
-	x.blockParsers = []syntax.BlockParser{
-		&syntax.SeeMoreParser{},
-		&syntax.SuperPreParser{},
-		&syntax.StopPParser{},
-		&syntax.BlockquoteParser{},
-		&syntax.PreParser{},
-		&syntax.ListParser{},
-		&syntax.DefinitionListParser{},
-		&syntax.TableParser{},
-		&syntax.SectionParser{},
-		&syntax.CommentParser{},
+		HatenaCompatible: o.hatenaCompatible,
+		Limits:           o.limits,
+		TitleResolver:    o.titleResolver,
+		TitleWorkers:     o.titleWorkers,
+		Highlighter:      o.highlighter,
+		SuperPreHandlers: o.superPreHandlers,
 	}
-	return x
-}
-
-func NewXatenaWithInline(inline syntax.Inline) *Xatena {
-	return NewXatenaWithFields(inline, false)
-}
-
-func NewXatena() *Xatena {
-	return NewXatenaWithInline(NewInlineFormatter())
+	var exts []Extension
+	for _, ext := range BuiltinExtensions() {
+		if !o.disabled[ext.Name()] {
+			exts = append(exts, ext)
+		}
+	}
+	if err := x.Use(append(exts, o.extensions...)...); err != nil {
+		return nil, err
+	}
+	return x, nil
 }
 
-// GetBlockParsers: Xatenaインスタンスを受け取る形に変更
+// GetBlockParsers: 試す順に並んだ BlockParser を返す
 func (x *Xatena) GetBlockParsers() []syntax.BlockParser {
-	return x.blockParsers
+	parsers := make([]syntax.BlockParser, len(x.blockParsers))
+	for i, p := range x.blockParsers {
+		parsers[i] = p.parser
+	}
+	return parsers
 }
 
 // normalizeNewlines: \r\n, \r を \n に統一
 func normalizeNewlines(s string) string {
+	if strings.IndexByte(s, '\r') < 0 {
+		return s
+	}
 	var b strings.Builder
 	b.Grow(len(s))
 	i := 0
@@ -90,20 +130,40 @@ func normalizeNewlines(s string) string {
 }
 
 // parseXatena: Xatenaインスタンスとcontext.Contextを受け取る
-func (x *Xatena) parseXatena(ctx context.Context, input string) *syntax.RootNode {
-	input = normalizeNewlines(input)
-	parsers := x.GetBlockParsers()
-	scanner := syntax.NewLineScanner(input)
-	root := &syntax.RootNode{
+// Limits の上限を超えると *LimitError を、ctx が終了すると ctx.Err() を返す
+func (x *Xatena) parseXatena(ctx context.Context, input string) (*Document, error) {
+	if max := x.Limits.MaxInputBytes; max > 0 && len(input) > max {
+		return nil, &LimitError{Limit: LimitInputBytes, Max: max}
+	}
+	normalized := normalizeNewlines(input)
+	dispatch := x.dispatch
+	if dispatch == nil {
+		dispatch = newBlockDispatch(x.blockParsers)
+	}
+	var candidates []int
+	scanner := syntax.NewLineScanner(normalized)
+	if len(normalized) != len(input) {
+		// TextNode.Offset などは正規化する前の入力でのオフセットにする
+		scanner.SetLineOffsets(syntax.SourceLineOffsets(input))
+	}
+	root := &Document{
 		Content: make([]syntax.Node, 0, 4),
 	}
 	stack := []syntax.HasContent{root}
-	for !scanner.EOF() {
+	opened := map[syntax.HasContent]int{} // ブロックを開いた行 (閉じ忘れの報告用)
+	for n := 0; !scanner.EOF(); n++ {
+		if n%256 == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
 		line := scanner.Peek()
 		parent := stack[len(stack)-1]
+		start := scanner.Pos() + 1
 		matched := false
-		for _, parser := range parsers {
-			if parser.CanHandle(line) {
+		candidates = dispatch.candidates(line, candidates[:0])
+		for _, i := range candidates {
+			if parser := dispatch.parsers[i]; parser.CanHandle(line) {
 				if parser.Parse(scanner, parent, &stack) {
 					matched = true
 					break
@@ -111,35 +171,162 @@ func (x *Xatena) parseXatena(ctx context.Context, input string) *syntax.RootNode
 			}
 		}
 		if !matched {
-			parent.AddChild(&syntax.TextNode{Text: scanner.Next()})
+			text := scanner.Next()
+			pos := scanner.Pos()
+			parent.AddChild(&syntax.TextNode{
+				Position: syntax.Position{StartLine: pos, EndLine: pos},
+				Text:     text,
+				Offset:   scanner.LineOffset(pos),
+			})
+		}
+		if top := stack[len(stack)-1]; top != parent {
+			if _, ok := opened[top]; !ok {
+				opened[top] = start
+			}
 		}
+		if max := x.Limits.MaxNestingDepth; max > 0 && len(stack)-1 > max {
+			return nil, &LimitError{Limit: LimitNestingDepth, Max: max, Line: start}
+		}
+	}
+	for _, n := range stack[1:] {
+		switch n.(type) {
+		case *syntax.BlockquoteNode:
+			scanner.ReportAt(opened[n], syntax.SeverityError, "unclosed blockquote: missing <<")
+		case *syntax.PreNode:
+			scanner.ReportAt(opened[n], syntax.SeverityError, "unclosed pre block: missing |<")
+		case *syntax.StopPNode:
+			scanner.ReportAt(opened[n], syntax.SeverityError, "unclosed stopp block: missing closing tag line ending with ><")
+		}
+	}
+	syntax.FinalizePositions(root)
+	root.Diagnostics = scanner.Diagnostics()
+	if err := x.Limits.checkListDepth(root); err != nil {
+		return nil, err
 	}
-	return root
+	return root, nil
 }
 
 // ToHTML: Xatenaインスタンスとcontext.Contextを渡す
+// 脚注 ((...)) があれば末尾に "footnote" テンプレートで脚注ブロックを出力する
+// 呼び出しごとに renderSession を作るので、1つの *Xatena を複数の goroutine から同時に使ってよい
+// Limits の上限を超えたり ctx が終了した場合はそこまでの出力を返す。エラーを受け取るには Render を使う
 func (x *Xatena) ToHTML(ctx context.Context, input string) string {
-	node := x.parseXatena(ctx, input)
-	return node.ToHTML(ctx, x, syntax.CallerOptions{})
+	var sb strings.Builder
+	x.Render(ctx, &sb, input)
+	return sb.String()
+}
+
+// Render: ToHTML と同じ HTML を文字列を組み立てずに w へ直接書き出す
+// 大きな文書で割り当てを減らしたいときに使う。w がバッファリングされていなければ bufio.Writer で包むとよい
+// Limits の上限を超えると *LimitError を、ctx が終了すると ctx.Err() を返す
+func (x *Xatena) Render(ctx context.Context, w io.Writer, input string) error {
+	doc, err := x.parseXatena(ctx, input)
+	if err != nil {
+		return err
+	}
+	return x.WriteDocument(ctx, w, doc)
+}
+
+// sessionInline は文書ごとに状態を分離した複製を作れる Inline 実装が満たすインターフェース
+type sessionInline interface {
+	NewSession() syntax.Inline
+}
+
+// renderSession は ToHTML 1回分の状態を保持する XatenaContext 実装
+// 脚注など文書ごとに変わる状態は共有の Xatena ではなくここに置く
+type renderSession struct {
+	*Xatena
+	inline      syntax.Inline
+	diagnostics []Diagnostic
+}
+
+func (x *Xatena) newRenderSession() *renderSession {
+	inline := x.Inline
+	if si, ok := inline.(sessionInline); ok {
+		inline = si.NewSession()
+	}
+	return &renderSession{Xatena: x, inline: inline}
+}
+
+// GetInline: ノードが使う Inline。安全モードならサニタイズを挟む
+func (s *renderSession) GetInline() syntax.Inline {
+	if s.SafeMode != nil {
+		si := sanitizingInline{Inline: s.inline, policy: s.SafeMode}
+		if _, ok := s.inline.(syntax.InlineRenderer); ok {
+			return sanitizingRenderer{si}
+		}
+		return si
+	}
+	return s.inline
 }
 
 func (x *Xatena) GetInline() syntax.Inline {
 	return x.Inline
 }
 
+// WriteTemplate: テンプレートの出力を w に直接書き出す
+// テンプレートのエラーは ExecuteTemplate と同じくエラー表示用の div として出力し、w への書き込みエラーだけを返す
+func (x *Xatena) WriteTemplate(w io.Writer, name string, params map[string]interface{}) error {
+	_, err := x.writeTemplate(w, name, params)
+	return err
+}
+
+// writeTemplate: テンプレートを実行し、テンプレート自体のエラーと w への書き込みエラーを分けて返す
+func (x *Xatena) writeTemplate(w io.Writer, name string, params map[string]interface{}) (tmplErr error, writeErr error) {
+	tmpl, ok := x.Templates[name]
+	if !ok {
+		tmplErr = fmt.Errorf("template not found: %s", name)
+		_, writeErr = io.WriteString(w, `<div class="xatena-template-error">`+htmltpl.HTMLEscapeString(tmplErr.Error())+`</div>`)
+		return tmplErr, writeErr
+	}
+	tw := &trackingWriter{w: w}
+	if err := tmpl.Execute(tw, params); err != nil {
+		if tw.err != nil {
+			return nil, tw.err
+		}
+		_, writeErr = io.WriteString(w, `<div class="xatena-template-error">template error: `+htmltpl.HTMLEscapeString(err.Error())+`</div>`)
+		return err, writeErr
+	}
+	return nil, nil
+}
+
+// trackingWriter はテンプレート実行中の書き込みエラーとテンプレート自体のエラーを区別するために使う
+type trackingWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (t *trackingWriter) Write(p []byte) (int, error) {
+	n, err := t.w.Write(p)
+	if err != nil && t.err == nil {
+		t.err = err
+	}
+	return n, err
+}
+
 func (x *Xatena) ExecuteTemplate(name string, params map[string]interface{}) string {
+	html, _ := x.executeTemplate(name, params)
+	return html
+}
+
+// executeTemplate: ExecuteTemplate と同じ文字列と、テンプレートのエラーを返す
+func (x *Xatena) executeTemplate(name string, params map[string]interface{}) (string, error) {
 	tmpl, ok := x.Templates[name]
 	if !ok {
-		return `<div class="xatena-template-error">template not found: ` + htmltpl.HTMLEscapeString(name) + `</div>`
+		return `<div class="xatena-template-error">template not found: ` + htmltpl.HTMLEscapeString(name) + `</div>`, fmt.Errorf("template not found: %s", name)
 	}
 	var sb strings.Builder
 	err := tmpl.Execute(&sb, params)
 	if err != nil {
-		return `<div class="xatena-template-error">template error: ` + htmltpl.HTMLEscapeString(err.Error()) + `</div>`
+		return `<div class="xatena-template-error">template error: ` + htmltpl.HTMLEscapeString(err.Error()) + `</div>`, err
 	}
-	return sb.String()
+	return sb.String(), nil
 }
 
 func (x *Xatena) PreferHatenaCompatible() bool {
 	return x.HatenaCompatible
 }
+
+func (x *Xatena) PreferSourcePos() bool {
+	return x.SourcePos
+}
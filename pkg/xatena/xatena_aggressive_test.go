@@ -0,0 +1,100 @@
+package xatena
+
+import (
+	"context"
+	"testing"
+)
+
+func TestAggressiveInlineFormatter(t *testing.T) {
+	ctx := context.Background()
+	f := NewAggressiveInlineFormatter(AggressiveURLs{})
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"[google:はてな 記法]", `<a href="https://www.google.com/search?q=%E3%81%AF%E3%81%A6%E3%81%AA+%E8%A8%98%E6%B3%95&amp;ie=utf-8&amp;oe=utf-8">google:はてな 記法</a>`},
+		{"[google:images:cat]", `<a href="https://www.google.com/search?q=cat&amp;tbm=isch">google:images:cat</a>`},
+		{"[google:news:go]", `<a href="https://news.google.com/search?q=go">google:news:go</a>`},
+		{"[amazon:Perl & Go]", `<a href="https://www.amazon.co.jp/s?k=Perl+%26+Go">amazon:Perl &amp; Go</a>`},
+		{"[wikipedia:東京]", `<a href="https://ja.wikipedia.org/wiki/%E6%9D%B1%E4%BA%AC">wikipedia:東京</a>`},
+		{"[wikipedia:en:Go (programming language)]", `<a href="https://en.wikipedia.org/wiki/Go_%28programming_language%29">wikipedia:en:Go (programming language)</a>`},
+		{"[isbn:978-0-306-40615-7]", `<a href="https://www.amazon.co.jp/dp/0306406152">isbn:978-0-306-40615-7</a>`},
+		{"[isbn:4-7741-2496-6]", `<a href="https://www.amazon.co.jp/dp/4774124966">isbn:4-7741-2496-6</a>`},
+		{"[asin:b000002ukl]", `<a href="https://www.amazon.co.jp/dp/B000002UKL">asin:b000002ukl</a>`},
+		{"[keyword:はてな]", `<a href="https://d.hatena.ne.jp/keyword/%E3%81%AF%E3%81%A6%E3%81%AA">はてな</a>`},
+		{"by id:cho45.", `by <a href="https://profile.hatena.ne.jp/cho45/">id:cho45</a>.`},
+		{"(id:cho45:20060102)", `(<a href="https://d.hatena.ne.jp/cho45/20060102">id:cho45:20060102</a>)`},
+		{"paid:cho45 id:x", `paid:cho45 id:x`},
+		// 組み込みの記法はそのまま
+		{"[http://example.com/] ((note))", `<a href="http://example.com/">http://example.com/</a> <a href="#fn1" title="note">*1</a>`},
+		{"[]google:foo[] [[google:a]]", `google:foo [<a href="https://www.google.com/search?q=a&amp;ie=utf-8&amp;oe=utf-8">google:a</a>]`},
+	}
+	for _, tt := range tests {
+		if got := f.NewSession().Format(ctx, tt.input); got != tt.want {
+			t.Errorf("Format(%q)\n got %s\nwant %s", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestAggressiveURLTemplates(t *testing.T) {
+	ctx := context.Background()
+	f := NewAggressiveInlineFormatter(AggressiveURLs{
+		Google:        "https://duckduckgo.com/?q={word}",
+		Wikipedia:     "https://wiki.example/{lang}/{word}",
+		WikipediaLang: "en",
+		ID:            "https://blog.example/{word}",
+	})
+	got := f.Format(ctx, "[google:go] [wikipedia:Go] id:cho45 [amazon:go]")
+	want := `<a href="https://duckduckgo.com/?q=go">google:go</a> <a href="https://wiki.example/en/Go">wikipedia:Go</a> <a href="https://blog.example/cho45">id:cho45</a> <a href="https://www.amazon.co.jp/s?k=go">amazon:go</a>`
+	if got != want {
+		t.Errorf("got %s\nwant %s", got, want)
+	}
+}
+
+func TestAggressiveTitleCache(t *testing.T) {
+	ctx := context.Background()
+	fetched := 0
+	f := NewAggressiveInlineFormatter(AggressiveURLs{}, func(f *InlineFormatter) {
+		f.SetTitleHandler(func(ctx context.Context, uri string) string {
+			fetched++
+			if uri == "http://example.com/missing" {
+				return uri
+			}
+			return "Example"
+		})
+	})
+	x := NewXatena(WithInline(f))
+	input := "[http://example.com/:title]\n[http://example.com/missing:title]\n"
+	first := x.ToHTML(ctx, input)
+	second := x.ToHTML(ctx, input)
+	if first != second {
+		t.Errorf("outputs differ:\n%s\n%s", first, second)
+	}
+	// 取得に失敗したものは保存しないので2回目も取得する
+	if fetched != 3 {
+		t.Errorf("fetched %d times, want 3", fetched)
+	}
+	if md := x.ToMarkdown(ctx, "[http://example.com/:title]\n"); md != "[Example](http://example.com/)\n" || fetched != 3 {
+		t.Errorf("markdown = %q, fetched %d", md, fetched)
+	}
+}
+
+func TestAggressiveWithoutRules(t *testing.T) {
+	ctx := context.Background()
+	x := NewXatena(WithInline(NewAggressiveInlineFormatter(AggressiveURLs{})), WithoutInlineRules(InlineID, InlineGoogle))
+	EqualHTML(t, x.ToHTML(ctx, "id:cho45 [google:go] [keyword:go]"), `<p>id:cho45 [google:go] <a href="https://d.hatena.ne.jp/keyword/go">go</a></p>`)
+}
+
+func TestISBN10(t *testing.T) {
+	for in, want := range map[string]string{
+		"9780306406157": "0306406152",
+		"9784873115658": "4873115655",
+		"9780000000064": "000000006X",
+		"9791234567896": "9791234567896",
+		"4774124966":    "4774124966",
+	} {
+		if got := isbn10(in); got != want {
+			t.Errorf("isbn10(%s) = %s, want %s", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package xatena
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/cho45/xatena-go/pkg/qrcode"
+)
+
+// exampleBarcode は [http://example.com/:barcode] の既定の出力
+var exampleBarcode = func() string {
+	code, err := qrcode.Encode([]byte("http://example.com/"), qrcode.Medium)
+	if err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf(`<img class="barcode" src="%s" width="150" height="150" title="http://example.com/" alt="http://example.com/"/>`, code.DataURI(150))
+}()
+
+var reBarcodeSrc = regexp.MustCompile(`src="data:image/svg\+xml;base64,([^"]+)"`)
+
+func TestBarcodeOptions(t *testing.T) {
+	ctx := context.Background()
+	uri := "http://example.com/?a=1&b=<2>"
+	input := "[" + uri + ":barcode]"
+
+	f := NewInlineFormatter(func(f *InlineFormatter) {
+		f.SetBarcodeOptions(BarcodeOptions{Size: 200, Level: qrcode.High})
+	})
+	got := f.Format(ctx, input)
+	m := reBarcodeSrc.FindStringSubmatch(got)
+	if m == nil {
+		t.Fatalf("no data URI: %s", got)
+	}
+	svg, err := base64.StdEncoding.DecodeString(m[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := qrcode.Encode([]byte(uri), qrcode.High)
+	if string(svg) != code.SVG(200, "", "") {
+		t.Errorf("svg = %s", svg)
+	}
+	if !strings.Contains(got, `title="http://example.com/?a=1&amp;b=&lt;2&gt;"`) || !strings.Contains(got, `width="200"`) {
+		t.Errorf("got %s", got)
+	}
+
+	// 埋め込みの <svg> は複製したセッションにも引き継ぐ
+	f.SetBarcodeOptions(BarcodeOptions{Level: qrcode.Low, Inline: true})
+	x := NewXatena(WithInline(f), WithoutInlineRules(InlineHTML))
+	low, _ := qrcode.Encode([]byte(uri), qrcode.Low)
+	if got := x.ToHTML(ctx, input); got != "<p>"+low.SVG(DefaultBarcodeSize, "barcode", uri)+"</p>" {
+		t.Errorf("inline svg = %s", got)
+	}
+}
+
+func TestBarcodeMarkdown(t *testing.T) {
+	ctx := context.Background()
+	code, _ := qrcode.Encode([]byte("http://example.com/"), qrcode.Medium)
+	want := "![http://example.com/](" + code.DataURI(DefaultBarcodeSize) + ")\n"
+	if got := NewXatena().ToMarkdown(ctx, "[http://example.com/:barcode]\n"); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestBarcodeTooLong(t *testing.T) {
+	uri := "http://example.com/" + strings.Repeat("a", 3000)
+	want := `<a href="` + uri + `">` + uri + `</a>`
+	if got := NewInlineFormatter().Format(context.Background(), "["+uri+":barcode]"); got != want {
+		t.Errorf("got %s", got)
+	}
+}
@@ -0,0 +1,192 @@
+// 公開 API だけで独自のブロック記法を書けることを確かめるため、外部パッケージとしてテストする
+package xatena_test
+
+import (
+	"context"
+	htmltpl "html/template"
+	"io"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/cho45/xatena-go/pkg/xatena"
+)
+
+// :::note ... ::: で囲んだ囲み記事
+type calloutNode struct {
+	xatena.Position
+	Kind    string
+	Content []xatena.Node
+}
+
+func (n *calloutNode) AddChild(c xatena.Node)    { n.Content = append(n.Content, c) }
+func (n *calloutNode) GetContent() []xatena.Node { return n.Content }
+
+func (n *calloutNode) ToHTML(ctx context.Context, x xatena.XatenaContext, options xatena.CallerOptions) string {
+	var sb strings.Builder
+	n.WriteHTML(ctx, &sb, x, options)
+	return sb.String()
+}
+
+func (n *calloutNode) WriteHTML(ctx context.Context, w io.Writer, x xatena.XatenaContext, options xatena.CallerOptions) error {
+	return x.WriteTemplate(w, "callout", map[string]interface{}{
+		"Kind":      n.Kind,
+		"Content":   htmltpl.HTML(xatena.ContentToHTML(n, ctx, x, options)),
+		"SourcePos": xatena.SourcePos(x, n.Position),
+	})
+}
+
+var calloutTemplate = htmltpl.Must(htmltpl.New("callout").Parse(
+	`<div class="callout callout-{{.Kind}}"{{with .SourcePos}} data-sourcepos="{{.}}"{{end}}>{{.Content}}</div>`))
+
+type calloutParser struct{}
+
+var (
+	reCalloutStart = regexp.MustCompile(`^:::(\w+)$`)
+	reCalloutEnd   = regexp.MustCompile(`^:::$`)
+)
+
+func (p *calloutParser) CanHandle(line string) bool { return strings.HasPrefix(line, ":::") }
+
+func (p *calloutParser) Parse(scanner *xatena.LineScanner, parent xatena.HasContent, stack *[]xatena.HasContent) bool {
+	if scanner.Scan(reCalloutStart) {
+		n := &calloutNode{Kind: scanner.Matched()[1]}
+		n.SetPosition(xatena.Position{StartLine: scanner.Pos(), EndLine: scanner.Pos()})
+		parent.AddChild(n)
+		*stack = append(*stack, n)
+		return true
+	}
+	if scanner.Scan(reCalloutEnd) {
+		for i := len(*stack) - 1; i > 0; i-- {
+			if n, ok := (*stack)[i].(*calloutNode); ok {
+				n.EndLine = scanner.Pos()
+				*stack = (*stack)[:i]
+				return true
+			}
+		}
+		scanner.Report(xatena.SeverityWarning, "stray ::: without matching :::kind")
+		return true
+	}
+	return false
+}
+
+func newCalloutXatena(t *testing.T) *xatena.Xatena {
+	x := xatena.NewXatena()
+	x.Templates["callout"] = calloutTemplate
+	// ":::" は定義リストの "::" にもマッチするので、定義リストより前に置く
+	if err := x.InsertBlockParserBefore(xatena.BlockDefinitionList, "callout", &calloutParser{}); err != nil {
+		t.Fatal(err)
+	}
+	return x
+}
+
+func TestCustomBlockParser(t *testing.T) {
+	x := newCalloutXatena(t)
+	got := x.ToHTML(context.Background(), ":::note\nfoo [http://example.com/]\n- item\n:::\nafter")
+	want := `<div class="callout callout-note"><p>foo <a href="http://example.com/">http://example.com/</a></p>`
+	if !strings.Contains(got, want) || !strings.Contains(got, "<li>item</li>") || !strings.Contains(got, "<p>after</p>") {
+		t.Errorf("unexpected output: %s", got)
+	}
+
+	x.SourcePos = true
+	got = x.ToHTML(context.Background(), "foo\n:::tip\nbar\n:::")
+	if !strings.Contains(got, `<div class="callout callout-tip" data-sourcepos="2-4">`) {
+		t.Errorf("data-sourcepos missing: %s", got)
+	}
+
+	_, diags := x.ToHTMLWithDiagnostics(context.Background(), "foo\n:::\n")
+	if len(diags) != 1 || diags[0].Line != 2 {
+		t.Errorf("expected stray closer diagnostic, got %v", diags)
+	}
+}
+
+func TestBlockParserOrdering(t *testing.T) {
+	x := xatena.NewXatena()
+	names := x.BlockParserNames()
+	if names[0] != xatena.BlockSeeMore || names[len(names)-1] != xatena.BlockComment {
+		t.Fatalf("unexpected builtin order: %v", names)
+	}
+
+	if err := x.InsertBlockParserAfter(xatena.BlockList, "callout", &calloutParser{}); err != nil {
+		t.Fatal(err)
+	}
+	got := x.BlockParserNames()
+	i := indexOf(got, "callout")
+	if i < 0 || got[i-1] != xatena.BlockList || got[i+1] != xatena.BlockDefinitionList {
+		t.Errorf("callout not inserted after list: %v", got)
+	}
+
+	if err := x.AddBlockParser("callout", &calloutParser{}); err == nil {
+		t.Error("expected error for duplicate name")
+	}
+	if err := x.InsertBlockParserBefore("nosuch", "x", &calloutParser{}); err == nil {
+		t.Error("expected error for unknown name")
+	}
+	if err := x.ReplaceBlockParser("nosuch", &calloutParser{}); err == nil {
+		t.Error("expected error for unknown name")
+	}
+	if err := x.RemoveBlockParser("nosuch"); err == nil {
+		t.Error("expected error for unknown name")
+	}
+	if !reflect.DeepEqual(x.BlockParserNames(), got) {
+		t.Errorf("failed operations changed the parser list: %v", x.BlockParserNames())
+	}
+}
+
+func TestRemoveBlockParser(t *testing.T) {
+	x := xatena.NewXatena()
+	if err := x.RemoveBlockParser(xatena.BlockTable); err != nil {
+		t.Fatal(err)
+	}
+	if indexOf(x.BlockParserNames(), xatena.BlockTable) >= 0 {
+		t.Error("table parser still registered")
+	}
+	got := x.ToHTML(context.Background(), "|a|b|")
+	if strings.Contains(got, "<table") || !strings.Contains(got, "<p>|a|b|</p>") {
+		t.Errorf("table should be plain text: %s", got)
+	}
+	// 他のインスタンスには影響しない
+	if got := xatena.NewXatena().ToHTML(context.Background(), "|a|b|"); !strings.Contains(got, "<table") {
+		t.Errorf("other instance affected: %s", got)
+	}
+}
+
+// spoilerParser は組み込みの引用記法を置き換え、>spoiler> だけを別の見た目にする
+type spoilerParser struct {
+	xatena.BlockParser // 置き換え前の BlockquoteParser
+}
+
+func (p *spoilerParser) Parse(scanner *xatena.LineScanner, parent xatena.HasContent, stack *[]xatena.HasContent) bool {
+	if !p.BlockParser.Parse(scanner, parent, stack) {
+		return false
+	}
+	if bq, ok := (*stack)[len(*stack)-1].(*xatena.Blockquote); ok && bq.Cite == "spoiler" {
+		bq.Cite = ""
+	}
+	return true
+}
+
+func TestReplaceBlockParser(t *testing.T) {
+	x := xatena.NewXatena()
+	builtin := x.GetBlockParsers()[indexOf(x.BlockParserNames(), xatena.BlockBlockquote)]
+	if err := x.ReplaceBlockParser(xatena.BlockBlockquote, &spoilerParser{builtin}); err != nil {
+		t.Fatal(err)
+	}
+	if got := x.BlockParserNames(); got[indexOf(got, xatena.BlockBlockquote)] != xatena.BlockBlockquote {
+		t.Errorf("name changed: %v", got)
+	}
+	got := x.ToHTML(context.Background(), ">spoiler>\nsecret\n<<")
+	if strings.Contains(got, "<cite>") || !strings.Contains(got, "<p>secret</p>") {
+		t.Errorf("unexpected output: %s", got)
+	}
+}
+
+func indexOf(names []string, name string) int {
+	for i, n := range names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
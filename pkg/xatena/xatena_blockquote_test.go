@@ -104,6 +104,39 @@ hoge3
 </blockquote>
 <p>hoge3</p>
 
+=== close stopp inside quote
+--- input
+>>
+><div>
+foo
+<<
+bar
+<<
+baz
+--- expected
+<blockquote>
+<div>
+foo
+<p>bar</p>
+</blockquote>
+<p>baz</p>
+
+=== close pre inside quote
+--- input
+>>
+>|
+foo
+<<
+bar
+<<
+baz
+--- expected
+<blockquote>
+<pre>foo</pre>
+<p>bar</p>
+</blockquote>
+<p>baz</p>
+
 `
 
 func TestFormat_Blockquote_ENDStyle(t *testing.T) {
@@ -101,7 +101,7 @@ func TestCommentNodeHasContent(t *testing.T) {
 	
 	// Parse a comment to get CommentNode
 	input := "<!--\ntest content\n-->"
-	root := x.parseXatena(context.Background(), input)
+	root, _ := x.parseXatena(context.Background(), input)
 	
 	if len(root.Content) == 0 {
 		t.Fatal("expected at least one node in parsed content")
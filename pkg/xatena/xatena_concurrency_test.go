@@ -0,0 +1,72 @@
+package xatena
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestToHTMLConcurrent: 1つの Xatena を複数 goroutine で共有しても脚注が混ざらないこと
+// go test -race で実行するとデータ競合も検出できる
+func TestToHTMLConcurrent(t *testing.T) {
+	x := NewXatena()
+	const workers = 16
+	const iterations = 50
+
+	var wg sync.WaitGroup
+	errs := make(chan string, workers*iterations)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				id := fmt.Sprintf("w%di%d", w, i)
+				input := fmt.Sprintf("* head((%s-a))\n\n- item((%s-b))\n\ntext((%s-c))", id, id, id)
+				got := x.ToHTML(context.Background(), input)
+				for n, suffix := range []string{"a", "b", "c"} {
+					want := fmt.Sprintf(`<a href="#fn%d" title="%s-%s">*%d</a>`, n+1, id, suffix, n+1)
+					if !strings.Contains(got, want) {
+						errs <- fmt.Sprintf("%s: missing %q in %q", id, want, got)
+					}
+				}
+				if strings.Contains(got, "fn4") {
+					errs <- fmt.Sprintf("%s: footnotes leaked from another document: %q", id, got)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for e := range errs {
+		t.Error(e)
+	}
+}
+
+// TestInlineFormatterAddRuleDuringRender: レンダリング中のルール追加が既存セッションを壊さないこと
+func TestInlineFormatterAddRuleDuringRender(t *testing.T) {
+	f := NewInlineFormatter()
+	x := NewXatenaWithInline(f)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				x.ToHTML(context.Background(), "foo((bar)) http://example.com/")
+			}
+		}()
+	}
+	for i := 0; i < 5; i++ {
+		f.AddRuleAt(0, InlineRule{
+			Pattern: regexp.MustCompile(`\[custom:(.+?)\]`),
+			Handler: func(ctx context.Context, f *InlineFormatter, m []string) string { return "<custom/>" },
+		})
+	}
+	wg.Wait()
+	if got := x.ToHTML(context.Background(), "[custom:x]"); !strings.Contains(got, "<custom/>") {
+		t.Errorf("expected custom rule to apply after AddRuleAt, got %q", got)
+	}
+}
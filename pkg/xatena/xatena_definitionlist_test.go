@@ -124,7 +124,7 @@ func TestDefinitionListNodeHasContent(t *testing.T) {
 	
 	// Parse a definition list to get DefinitionListNode
 	input := ":term:description"
-	root := x.parseXatena(context.Background(), input)
+	root, _ := x.parseXatena(context.Background(), input)
 	
 	if len(root.Content) == 0 {
 		t.Fatal("expected at least one node in parsed content")
@@ -0,0 +1,161 @@
+package xatena
+
+import (
+	"context"
+	htmltpl "html/template"
+	"strings"
+	"testing"
+)
+
+func TestToHTMLWithDiagnostics(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		expected []Diagnostic
+	}{
+		{
+			name:     "clean document",
+			input:    "* section\n\nfoo [http://example.com/]\n\n>>\nquote\n<<\n",
+			expected: nil,
+		},
+		{
+			name:  "unclosed super pre",
+			input: "foo\n>|perl|\nprint 1;\n",
+			expected: []Diagnostic{
+				{Line: 2, Severity: SeverityError, Message: "unclosed super pre block: missing ||<"},
+			},
+		},
+		{
+			name:  "unclosed blockquote",
+			input: "* section\n>>\nquote\n",
+			expected: []Diagnostic{
+				{Line: 2, Severity: SeverityError, Message: "unclosed blockquote: missing <<"},
+			},
+		},
+		{
+			name:  "unclosed pre",
+			input: ">|\nfoo\n",
+			expected: []Diagnostic{
+				{Line: 1, Severity: SeverityError, Message: "unclosed pre block: missing |<"},
+			},
+		},
+		{
+			name:  "unclosed stopp",
+			input: "foo\n><div>\nbar\n",
+			expected: []Diagnostic{
+				{Line: 2, Severity: SeverityError, Message: "unclosed stopp block: missing closing tag line ending with ><"},
+			},
+		},
+		{
+			name:  "unclosed comment",
+			input: "foo\n<!-- comment\nbar\n",
+			expected: []Diagnostic{
+				{Line: 2, Severity: SeverityError, Message: "unclosed comment: missing -->"},
+			},
+		},
+		{
+			name:  "stray blockquote closer",
+			input: "foo\n<<\nbar\n",
+			expected: []Diagnostic{
+				{Line: 2, Severity: SeverityWarning, Message: "stray << without matching >>"},
+			},
+		},
+		{
+			name:  "stray super pre closer",
+			input: "foo\n||<\nbar\n",
+			expected: []Diagnostic{
+				{Line: 2, Severity: SeverityWarning, Message: "stray ||< without matching >|lang|"},
+			},
+		},
+		{
+			name:  "invalid super pre header",
+			input: "foo\n>|go{1,a}|\nbar\n||<\n",
+			expected: []Diagnostic{
+				{Line: 2, Severity: SeverityWarning, Message: "invalid super pre header: go{1,a}"},
+			},
+		},
+		{
+			name:  "stray stopp closer",
+			input: "foo\n</div><\nbar\n",
+			expected: []Diagnostic{
+				{Line: 2, Severity: SeverityWarning, Message: "stray >< without matching ><tag>"},
+			},
+		},
+		{
+			name:  "unknown inline notation",
+			input: "see [google:hatena] and [http://example.com/]\n",
+			expected: []Diagnostic{
+				{Line: 1, Severity: SeverityWarning, Message: "unknown inline notation [google:hatena]"},
+			},
+		},
+		{
+			name:  "inline notation in nested block",
+			input: "foo\n\n* title [bar:baz]\n",
+			expected: []Diagnostic{
+				{Line: 3, Severity: SeverityWarning, Message: "unknown inline notation [bar:baz]"},
+			},
+		},
+		{
+			name:  "sorted by line",
+			input: "[keyword:foo]\n<<\n>>\n",
+			expected: []Diagnostic{
+				{Line: 1, Severity: SeverityWarning, Message: "unknown inline notation [keyword:foo]"},
+				{Line: 2, Severity: SeverityWarning, Message: "stray << without matching >>"},
+				{Line: 3, Severity: SeverityError, Message: "unclosed blockquote: missing <<"},
+			},
+		},
+	}
+	ctx := context.Background()
+	x := NewXatena()
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			html, diags := x.ToHTMLWithDiagnostics(ctx, c.input)
+			if want := x.ToHTML(ctx, c.input); html != want {
+				t.Errorf("html differs from ToHTML:\n got=%q\nwant=%q", html, want)
+			}
+			if len(diags) != len(c.expected) {
+				t.Fatalf("expected %d diagnostics, got %v", len(c.expected), diags)
+			}
+			for i := range diags {
+				if diags[i] != c.expected[i] {
+					t.Errorf("diagnostic[%d] = %v, want %v", i, diags[i], c.expected[i])
+				}
+			}
+		})
+	}
+}
+
+// TestStrayClosersDoNotPanic: 対応のない閉じ記号でルートを pop しないこと
+func TestStrayClosersDoNotPanic(t *testing.T) {
+	for _, input := range []string{"<<", "* sec\n<<\nfoo", "|<", "a||<\nb", "</div><", "<<\n<<\n>>\nfoo\n<<\n<<"} {
+		got := Format(input)
+		if strings.Contains(input, "foo") && !strings.Contains(got, "foo") {
+			t.Errorf("input %q: text after stray closer was lost: %q", input, got)
+		}
+	}
+}
+
+func TestDiagnosticsTemplateError(t *testing.T) {
+	x := NewXatena()
+	x.Templates["section"] = htmltpl.Must(htmltpl.New("section").Parse(`<h{{.Level}}>{{index .Level 1}}</h{{.Level}}>`))
+	html, diags := x.ToHTMLWithDiagnostics(context.Background(), "* title\n")
+	if !strings.Contains(html, "xatena-template-error") {
+		t.Errorf("expected template error in html, got %q", html)
+	}
+	if len(diags) != 1 || diags[0].Severity != SeverityError || !strings.HasPrefix(diags[0].Message, "template section: ") {
+		t.Errorf("expected one template error diagnostic, got %v", diags)
+	}
+}
+
+func TestParseDiagnostics(t *testing.T) {
+	doc, err := Parse(context.Background(), ">>\nquote\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Diagnostics) != 1 || doc.Diagnostics[0].Line != 1 {
+		t.Errorf("expected unclosed blockquote diagnostic on line 1, got %v", doc.Diagnostics)
+	}
+	if s := doc.Diagnostics[0].String(); s != "1: error: unclosed blockquote: missing <<" {
+		t.Errorf("unexpected String(): %q", s)
+	}
+}
@@ -0,0 +1,75 @@
+package xatena
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// TestParseRenderEqualsToHTML: Parse + RenderDocument の出力が ToHTML と完全に一致すること
+func TestParseRenderEqualsToHTML(t *testing.T) {
+	ctx := context.Background()
+	for _, compat := range []bool{false, true} {
+		x := NewXatenaWithFields(NewInlineFormatter(), compat)
+		for _, input := range allTestInputs() {
+			doc, err := x.Parse(ctx, input)
+			if err != nil {
+				t.Fatalf("Parse(%q) returned error: %v", input, err)
+			}
+			got := x.RenderDocument(ctx, doc)
+			want := x.ToHTML(ctx, input)
+			if got != want {
+				t.Errorf("compat=%v input=%q\nRenderDocument=%q\nToHTML        =%q", compat, input, got, want)
+			}
+		}
+	}
+}
+
+func TestParseTableOfContents(t *testing.T) {
+	input := "* first\nfoo\n** first.1\nbar\n* second\n- item\n"
+	doc, err := Parse(context.Background(), input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var toc []string
+	Walk(doc, func(n Node) bool {
+		if s, ok := n.(*Section); ok {
+			toc = append(toc, strings.Repeat("  ", s.Level-1)+s.Title)
+		}
+		return true
+	})
+	want := []string{"first", "  first.1", "second"}
+	if strings.Join(toc, "\n") != strings.Join(want, "\n") {
+		t.Errorf("toc = %q, want %q", toc, want)
+	}
+}
+
+func TestParseModifyAndRender(t *testing.T) {
+	ctx := context.Background()
+	doc, err := Parse(ctx, "* title\n>|go|\nfmt.Println()\n||<\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Walk(doc, func(n Node) bool {
+		switch v := n.(type) {
+		case *Section:
+			v.Title = "changed"
+		case *SuperPre:
+			v.Lang = "golang"
+		}
+		return true
+	})
+	got := Render(ctx, doc)
+	EqualHTML(t, got, `<div class="section">
+<h3>changed</h3>
+<pre class="code lang-golang">fmt.Println()</pre>
+</div>`)
+}
+
+func TestParseCanceledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Parse(ctx, "foo"); err == nil {
+		t.Error("expected error for canceled context")
+	}
+}
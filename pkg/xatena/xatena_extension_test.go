@@ -0,0 +1,129 @@
+package xatena_test
+
+import (
+	"context"
+	"fmt"
+	htmltpl "html/template"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/cho45/xatena-go/pkg/xatena"
+)
+
+// calloutExtension は囲み記事のブロック記法と [kbd:...] のインライン記法をまとめて登録する
+type calloutExtension struct{}
+
+func (calloutExtension) Name() string { return "callout" }
+
+func (calloutExtension) Extend(x *xatena.Xatena) error {
+	if err := x.InsertBlockParserBefore(xatena.BlockDefinitionList, "callout", &calloutParser{}); err != nil {
+		return err
+	}
+	x.SetDefaultTemplate("callout", calloutTemplate)
+	return x.AddInlineRule(xatena.InlineRule{
+		Pattern: regexp.MustCompile(`\[kbd:([^\]]+)\]`),
+		Handler: func(ctx context.Context, f *xatena.InlineFormatter, m []string) string {
+			return "<kbd>" + htmltpl.HTMLEscapeString(m[1]) + "</kbd>"
+		},
+	})
+}
+
+func TestWithExtensions(t *testing.T) {
+	x := xatena.NewXatena(xatena.WithExtensions(calloutExtension{}))
+	got := x.ToHTML(context.Background(), ":::note\npress [kbd:Ctrl+C]\n:::")
+	want := `<div class="callout callout-note"><p>press <kbd>Ctrl+C</kbd></p>`
+	if !strings.Contains(got, want) {
+		t.Errorf("got %s", got)
+	}
+	names := x.Extensions()
+	if names[len(names)-1] != "callout" {
+		t.Errorf("extension not recorded: %v", names)
+	}
+	// 拡張のルールは他のインスタンスに漏れない
+	if got := xatena.NewXatena().ToHTML(context.Background(), "[kbd:x]"); strings.Contains(got, "<kbd>") {
+		t.Errorf("inline rule leaked: %s", got)
+	}
+}
+
+func TestWithoutExtensions(t *testing.T) {
+	// コメント欄向け: 見出しとテーブルを使わせない
+	comments := xatena.NewXatena(xatena.WithoutExtensions(xatena.BlockSection, xatena.BlockTable))
+	got := comments.ToHTML(context.Background(), "* not a heading\n|not|a table|\n- list")
+	for _, unwanted := range []string{"<h3", "<table", `class="section"`} {
+		if strings.Contains(got, unwanted) {
+			t.Errorf("%s should be disabled: %s", unwanted, got)
+		}
+	}
+	if !strings.Contains(got, "<p>* not a heading<br />\n|not|a table|</p>") || !strings.Contains(got, "<li>list</li>") {
+		t.Errorf("unexpected output: %s", got)
+	}
+	for _, name := range comments.Extensions() {
+		if name == xatena.BlockSection || name == xatena.BlockTable {
+			t.Errorf("%s still registered", name)
+		}
+	}
+	if _, ok := comments.Templates["table"]; ok {
+		t.Error("template of disabled extension registered")
+	}
+}
+
+func TestBuiltinExtensionsMatchParsers(t *testing.T) {
+	var names []string
+	for _, ext := range xatena.BuiltinExtensions() {
+		names = append(names, ext.Name())
+	}
+	x := xatena.NewXatena()
+	if !reflect.DeepEqual(names, x.BlockParserNames()) || !reflect.DeepEqual(names, x.Extensions()) {
+		t.Errorf("builtin extensions %v, parsers %v, extensions %v", names, x.BlockParserNames(), x.Extensions())
+	}
+}
+
+func TestUseDuplicate(t *testing.T) {
+	x := xatena.NewXatena()
+	if err := x.Use(calloutExtension{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := x.Use(calloutExtension{}); err == nil {
+		t.Error("expected error for duplicate extension")
+	}
+	if _, err := xatena.NewXatenaE(xatena.WithExtensions(calloutExtension{}, calloutExtension{})); err == nil {
+		t.Error("expected NewXatenaE to return error for duplicate extension")
+	}
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("expected NewXatena to panic on duplicate extension")
+		}
+	}()
+	xatena.NewXatena(xatena.WithExtensions(calloutExtension{}, calloutExtension{}))
+}
+
+func TestSetDefaultTemplateKeepsOverride(t *testing.T) {
+	x := xatena.NewXatena()
+	x.Templates["callout"] = htmltpl.Must(htmltpl.New("callout").Parse(`<aside>{{.Content}}</aside>`))
+	if err := x.Use(calloutExtension{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := x.ToHTML(context.Background(), ":::note\nfoo\n:::"); !strings.Contains(got, "<aside><p>foo</p></aside>") {
+		t.Errorf("override lost: %s", got)
+	}
+}
+
+type failingExtension struct{}
+
+func (failingExtension) Name() string                { return "failing" }
+func (failingExtension) Extend(*xatena.Xatena) error { return fmt.Errorf("boom") }
+
+func TestUseError(t *testing.T) {
+	x := xatena.NewXatena()
+	err := x.Use(failingExtension{})
+	if err == nil || !strings.Contains(err.Error(), "failing") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	for _, name := range x.Extensions() {
+		if name == "failing" {
+			t.Error("failed extension recorded")
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package xatena
+
+import (
+	"context"
+	htmltpl "html/template"
+	"strings"
+	"testing"
+)
+
+const footnoteTestData = `
+=== test
+--- input
+foo((bar))baz
+--- expected
+<p>foo<a href="#fn1" title="bar">*1</a>baz</p>
+<div class="footnote">
+<p class="footnote"><a href="#fn1" id="fn1">*1</a>: bar</p>
+</div>
+
+=== test
+--- input
+* title((note1))
+
+foo((note2))
+--- expected
+<div class="section">
+<h3>title<a href="#fn1" title="note1">*1</a></h3>
+<p>foo<a href="#fn2" title="note2">*2</a></p>
+</div>
+<div class="footnote">
+<p class="footnote"><a href="#fn1" id="fn1">*1</a>: note1</p>
+<p class="footnote"><a href="#fn2" id="fn2">*2</a>: note2</p>
+</div>
+
+=== test
+--- input
+foo(((bar)))baz
+--- expected
+<p>foo((bar))baz</p>
+`
+
+func TestFormat_Footnote_ENDStyle(t *testing.T) {
+	blocks := parseTestBlocks(footnoteTestData)
+	for _, b := range blocks {
+		input := b.Sections["input"]
+		expected := b.Sections["expected"]
+		t.Run(b.Name, func(t *testing.T) {
+			got := Format(input)
+			EqualHTML(t, got, expected)
+		})
+	}
+}
+
+func TestFootnoteHatenaCompatible(t *testing.T) {
+	x := NewXatenaWithFields(NewInlineFormatter(), true)
+	got := x.ToHTML(context.Background(), "foo((bar))")
+	expected := `<p>foo<a href="#fn1" title="bar">*1</a></p>
+<div class="footnote">
+<p class="footnote"><a href="#fn1" id="fn1">*1</a>: bar</p>
+</div>`
+	EqualHTML(t, got, expected)
+}
+
+// TestFootnoteNumberingPerDocument: 同じインスタンスで複数回 ToHTML しても番号は 1 から振られる
+func TestFootnoteNumberingPerDocument(t *testing.T) {
+	x := NewXatena()
+	x.ToHTML(context.Background(), "a((one))b((two))")
+	got := x.ToHTML(context.Background(), "c((three))")
+	if !strings.Contains(got, `<a href="#fn1" title="three">*1</a>`) {
+		t.Errorf("expected footnote numbering to restart, got %q", got)
+	}
+	if strings.Contains(got, "fn2") {
+		t.Errorf("footnotes of previous document leaked: %q", got)
+	}
+}
+
+func TestFootnoteCustomTemplate(t *testing.T) {
+	x := NewXatena()
+	x.Templates["footnote"] = htmltpl.Must(htmltpl.New("footnote").Parse(
+		`<ol class="notes">{{range .Footnotes}}<li id="fn{{.Number}}">{{.Note}}</li>{{end}}</ol>`))
+	got := x.ToHTML(context.Background(), "foo((bar))")
+	if !strings.Contains(got, `<ol class="notes"><li id="fn1">bar</li></ol>`) {
+		t.Errorf("custom footnote template not used: %q", got)
+	}
+}
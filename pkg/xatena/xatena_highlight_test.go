@@ -0,0 +1,31 @@
+package xatena
+
+import (
+	"context"
+	"testing"
+)
+
+func TestBuiltinHighlighter(t *testing.T) {
+	ctx := context.Background()
+	x := NewXatena(WithHighlighter(BuiltinHighlighter))
+	got := x.ToHTML(ctx, ">|go|\nreturn a < b\n||<\n>|cobol|\nIF a < b\n||<\n>||\nreturn\n||<")
+	want := `<pre class="code lang-go"><span class="synStatement">return</span> a &lt; b</pre>` + "\n" +
+		`<pre class="code lang-cobol">IF a &lt; b</pre>` + "\n" +
+		`<pre class="code">return</pre>`
+	EqualHTML(t, got, want)
+}
+
+func TestHighlighterFunc(t *testing.T) {
+	var calls [][2]string
+	x := NewXatena()
+	x.Highlighter = HighlighterFunc(func(lang, code string) (string, bool) {
+		calls = append(calls, [2]string{lang, code})
+		return "<b>" + code + "</b>", lang == "x"
+	})
+	got := x.ToHTML(context.Background(), ">|x|\n1\n2\n||<\n>|y|\n<y>\n||<")
+	EqualHTML(t, got, `<pre class="code lang-x"><b>1
+2</b></pre><pre class="code lang-y">&lt;y&gt;</pre>`)
+	if len(calls) != 2 || calls[0] != [2]string{"x", "1\n2"} {
+		t.Errorf("calls = %q", calls)
+	}
+}
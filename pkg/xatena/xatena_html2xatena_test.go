@@ -0,0 +1,27 @@
+package xatena
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cho45/xatena-go/pkg/html2xatena"
+)
+
+// TestHTML2XatenaRoundTrip: ToHTML(html2xatena(ToHTML(x))) が ToHTML(x) と同じ構造になる
+func TestHTML2XatenaRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	x := NewXatena()
+	for _, input := range allTestInputs() {
+		want := x.ToHTML(ctx, input)
+		src, err := html2xatena.Convert(want)
+		if err != nil {
+			t.Fatalf("Convert(%q): %v", want, err)
+		}
+		got := x.ToHTML(ctx, src)
+		g, _ := parseHTMLNode(got)
+		w, _ := parseHTMLNode(want)
+		if !equalHTMLNode(g, w) {
+			t.Errorf("round trip failed\ninput:\n%s\nconverted:\n%s\n got: %s\nwant: %s", input, src, got, want)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package xatena
+
+import (
+	"context"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestInlineFormatter_Parse(t *testing.T) {
+	f := NewInlineFormatter()
+	input := "a []<b>[] [http://example.com/:title=Example] [http://example.com/:title] http://example.com/x ((note)) <i>x</i><!-- c --> [tex:x^2] [mailto:a@example.com] [http://example.com/:barcode]"
+	at := func(n InlineNode, start, end int) InlineNode {
+		n.SetInlinePos(InlinePos{Start: start, End: end})
+		return n
+	}
+	want := []InlineNode{
+		at(&InlineText{Text: "a "}, 0, 2),
+		at(&InlineText{Text: "<b>"}, 2, 9),
+		at(&InlineText{Text: " "}, 9, 10),
+		at(&Link{URL: "http://example.com/", Title: "Example"}, 10, 45),
+		at(&InlineText{Text: " "}, 45, 46),
+		at(&Link{URL: "http://example.com/", FetchTitle: true}, 46, 73),
+		at(&InlineText{Text: " "}, 73, 74),
+		at(&Link{URL: "http://example.com/x", Auto: true}, 74, 94),
+		at(&InlineText{Text: " "}, 94, 95),
+		at(&FootnoteRef{Note: "note"}, 95, 103),
+		at(&InlineText{Text: " "}, 103, 104),
+		at(&RawHTML{HTML: "<i>"}, 104, 107),
+		at(&InlineText{Text: "x"}, 107, 108),
+		at(&RawHTML{HTML: "</i>"}, 108, 112),
+		at(&HTMLComment{}, 112, 122),
+		at(&InlineText{Text: " "}, 122, 123),
+		at(&Tex{Expr: "x^2"}, 123, 132),
+		at(&InlineText{Text: " "}, 132, 133),
+		at(&MailTo{Address: "a@example.com"}, 133, 155),
+		at(&InlineText{Text: " "}, 155, 156),
+		at(&Barcode{URL: "http://example.com/"}, 156, 185),
+	}
+	got := f.Parse(context.Background(), input)
+	if !reflect.DeepEqual(got, want) {
+		for i := range got {
+			t.Logf("%d: %#v", i, got[i])
+		}
+		t.Fatalf("Parse mismatch")
+	}
+	if f.Render(context.Background(), got) != NewInlineFormatter().Format(context.Background(), input) {
+		t.Errorf("Render(Parse(s)) differs from Format(s)")
+	}
+}
+
+func TestInlineFormatter_ParseCustomRule(t *testing.T) {
+	f := NewInlineFormatter()
+	f.AddRuleAt(0, InlineRule{
+		Pattern: regexp.MustCompile(`\[kbd:([^\]]+)\]`),
+		Handler: func(ctx context.Context, f *InlineFormatter, m []string) string { return "<kbd>" + m[1] + "</kbd>" },
+	})
+	got := f.Parse(context.Background(), "[kbd:C]")
+	want := []InlineNode{&RawHTML{InlinePos: InlinePos{Start: 0, End: 7}, HTML: "<kbd>C</kbd>"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v", got)
+	}
+}
+
+func TestParseInlinesRendersSameHTML(t *testing.T) {
+	ctx := context.Background()
+	// 複数行にまたがる記法は段落全体をパースしたときだけ ToHTML と一致する
+	inputs := append(allTestInputs(),
+		"[]foo\n[tex:x][]",
+		"<a href=\"http://example.com/\">a\nb</a>",
+		"><div>\n[]a\n[http://example.com/][]\n</div><",
+		">>\n[]x\n\ny[]\n<<",
+		"|[]a|b[]|",
+	)
+	for _, compatible := range []bool{false, true} {
+		x := NewXatena(WithHatenaCompatible(compatible))
+		for _, input := range inputs {
+			want := x.ToHTML(ctx, input)
+			doc, _ := x.Parse(ctx, input)
+			if err := x.ParseInlines(ctx, doc); err != nil {
+				t.Fatal(err)
+			}
+			if got := x.RenderDocument(ctx, doc); got != want {
+				t.Errorf("compatible=%v input %q:\ngot  %q\nwant %q", compatible, input, got, want)
+			}
+		}
+	}
+}
+
+func TestParseInlinesParagraphUnit(t *testing.T) {
+	ctx := context.Background()
+	x := NewXatena()
+	doc, _ := x.Parse(ctx, "[]foo\n[tex:x][]\n- a")
+	if err := x.ParseInlines(ctx, doc); err != nil {
+		t.Fatal(err)
+	}
+	first, second := doc.Content[0].(*Text), doc.Content[1].(*Text)
+	want := []InlineNode{&InlineText{InlinePos: InlinePos{Start: 0, End: 15}, Text: "foo\n[tex:x]"}}
+	if !reflect.DeepEqual(first.Inlines, want) || second.Inlines != nil {
+		t.Errorf("first = %#v, second = %#v", first.Inlines, second.Inlines)
+	}
+}
+
+func TestParseInlinesAttachesNodes(t *testing.T) {
+	ctx := context.Background()
+	x := NewXatena()
+	doc, _ := x.Parse(ctx, "* [http://example.com/a]\n- <b>item</b>\n|http://example.com/b|\n:term:[mailto:a@example.com]\ntext ((note))\n")
+	if err := x.ParseInlines(ctx, doc); err != nil {
+		t.Fatal(err)
+	}
+
+	// リンクの抽出
+	var links []string
+	var collect func(nodes []InlineNode)
+	collect = func(nodes []InlineNode) {
+		for _, n := range nodes {
+			switch v := n.(type) {
+			case *Link:
+				links = append(links, v.URL)
+			case *MailTo:
+				links = append(links, "mailto:"+v.Address)
+			}
+		}
+	}
+	for _, n := range doc.Content {
+		Walk(n, func(n Node) bool {
+			switch v := n.(type) {
+			case *Section:
+				collect(v.TitleInlines)
+			case *Text:
+				collect(v.Inlines)
+			case *Table:
+				for _, row := range v.Rows {
+					for _, c := range row {
+						collect(c.Inlines)
+					}
+				}
+			case *DefinitionList:
+				for _, item := range v.Items {
+					collect(item.TermInlines)
+					for _, d := range item.DescInlines {
+						collect(d)
+					}
+				}
+			}
+			return true
+		})
+	}
+	want := []string{"http://example.com/a", "http://example.com/b", "mailto:a@example.com"}
+	if !reflect.DeepEqual(links, want) {
+		t.Errorf("links = %v, want %v", links, want)
+	}
+
+	// 生の HTML を取り除くと出力に反映される
+	list := doc.Content[0].(*Section).Content[0].(*List)
+	item := list.Items[0].Items[0]
+	var kept []InlineNode
+	for _, n := range item.Inlines {
+		if _, ok := n.(*RawHTML); !ok {
+			kept = append(kept, n)
+		}
+	}
+	item.Inlines = kept
+	if html := x.RenderDocument(ctx, doc); !strings.Contains(html, "<li>item</li>") {
+		t.Errorf("unexpected list item: %s", html)
+	}
+}
+
+func TestParseInlinesUnsupported(t *testing.T) {
+	x := NewXatena(WithInline(upperInline{}))
+	doc, _ := x.Parse(context.Background(), "a")
+	if err := x.ParseInlines(context.Background(), doc); err == nil {
+		t.Errorf("expected error")
+	}
+}
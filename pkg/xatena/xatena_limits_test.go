@@ -0,0 +1,233 @@
+package xatena
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLimitsParse(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name   string
+		limits Limits
+		input  string
+		limit  string
+		line   int
+	}{
+		{"input bytes", Limits{MaxInputBytes: 10}, "12345678901", LimitInputBytes, 0},
+		{"blockquote", Limits{MaxNestingDepth: 2}, ">>\n>>\n>>\nquote\n<<\n<<\n<<\n", LimitNestingDepth, 3},
+		{"section in blockquote", Limits{MaxNestingDepth: 2}, ">>\n* a\n** b\n<<\n", LimitNestingDepth, 3},
+		{"list", Limits{MaxListDepth: 3}, "- a\n-- b\n--- c\n---- d\n", LimitListDepth, 4},
+		{"list in blockquote", Limits{MaxListDepth: 1}, ">>\n- a\n-- b\n<<\n", LimitListDepth, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			x := NewXatena(WithLimits(tt.limits))
+			_, err := x.Parse(ctx, tt.input)
+			var le *LimitError
+			if !errors.As(err, &le) {
+				t.Fatalf("err = %v, want LimitError", err)
+			}
+			if le.Limit != tt.limit || le.Line != tt.line {
+				t.Errorf("err = %+v, want limit %q line %d", le, tt.limit, tt.line)
+			}
+			if err := x.Render(ctx, io.Discard, tt.input); !errors.As(err, &le) {
+				t.Errorf("Render err = %v, want LimitError", err)
+			}
+		})
+	}
+}
+
+func TestLimitsWithinBounds(t *testing.T) {
+	ctx := context.Background()
+	x := NewXatena(WithLimits(DefaultLimits()))
+	x.Inline.(*InlineFormatter).SetTitleHandler(func(ctx context.Context, uri string) string { return "title" })
+	for _, input := range allTestInputs() {
+		var sb strings.Builder
+		if err := x.Render(ctx, &sb, input); err != nil {
+			t.Errorf("input %q: %v", input, err)
+			continue
+		}
+		if got, want := sb.String(), x.ToHTML(ctx, input); got != want {
+			t.Errorf("input %q: Render and ToHTML differ", input)
+		}
+	}
+}
+
+func TestLimitsFootnotes(t *testing.T) {
+	ctx := context.Background()
+	x := NewXatena(WithLimits(Limits{MaxFootnotes: 2}))
+	if err := x.Render(ctx, io.Discard, "a((1))b((2))\n"); err != nil {
+		t.Fatalf("err = %v", err)
+	}
+	var sb strings.Builder
+	err := x.Render(ctx, &sb, "a((1))b((2))c((3))\n\n* after\n")
+	var le *LimitError
+	if !errors.As(err, &le) || le.Limit != LimitFootnotes {
+		t.Fatalf("err = %v, want footnotes LimitError", err)
+	}
+	if strings.Contains(sb.String(), "after") {
+		t.Errorf("rendering did not stop: %q", sb.String())
+	}
+	// 本文に書いた脚注への参照は切らない
+	for _, want := range []string{`<a href="#fn1"`, `id="fn1"`, `id="fn2"`} {
+		if !strings.Contains(sb.String(), want) {
+			t.Errorf("missing %s in %q", want, sb.String())
+		}
+	}
+	if strings.Contains(sb.String(), `id="fn3"`) {
+		t.Errorf("footnote over the limit was written: %q", sb.String())
+	}
+	// 上限は文書ごとに数える
+	if err := x.Render(ctx, io.Discard, "a((1))b((2))\n"); err != nil {
+		t.Errorf("second render: %v", err)
+	}
+	if md := x.ToMarkdown(ctx, "a((1))b((2))c((3))\n"); md != "" {
+		t.Errorf("ToMarkdown = %q, want empty", md)
+	}
+}
+
+// TestLimitsFootnotesKeepRestOfLine: 上限を超えた脚注を落としても、その後ろの文字列は残す
+func TestLimitsFootnotesKeepRestOfLine(t *testing.T) {
+	x := NewXatena(WithLimits(Limits{MaxFootnotes: 1}))
+	ctx, done := x.beginRender(context.Background(), &Document{})
+	f := NewInlineFormatter()
+	got := f.Render(ctx, f.Parse(ctx, "a((1))b((2))c [http://example.com/]"))
+	want := `a<a href="#fn1" title="1">*1</a>bc <a href="http://example.com/">http://example.com/</a>`
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	var le *LimitError
+	if err := done(nil); !errors.As(err, &le) || le.Limit != LimitFootnotes {
+		t.Errorf("err = %v, want footnotes LimitError", err)
+	}
+}
+
+// TestLimitsFootnotesTargets: 上限で止めた ToHTML の出力でも、脚注の参照先がある
+func TestLimitsFootnotesTargets(t *testing.T) {
+	x := NewXatena(WithLimits(Limits{MaxFootnotes: 2}))
+	got := x.ToHTML(context.Background(), "a((1))b((2))c((3))d\n\nnext para")
+	for _, ref := range []string{"fn1", "fn2"} {
+		if strings.Contains(got, `href="#`+ref+`"`) && !strings.Contains(got, `id="`+ref+`"`) {
+			t.Errorf("#%s has no target: %q", ref, got)
+		}
+	}
+	if !strings.Contains(got, `<div class="footnote">`) || !strings.Contains(got, `id="fn1"`) {
+		t.Errorf("footnotes not written: %q", got)
+	}
+}
+
+func TestLimitsTitleFetches(t *testing.T) {
+	ctx := context.Background()
+	fetched := 0
+	x := NewXatena(WithLimits(Limits{MaxTitleFetches: 2}))
+	x.Inline.(*InlineFormatter).SetTitleHandler(func(ctx context.Context, uri string) string {
+		fetched++
+		return "title"
+	})
+	input := "[http://example.com/1:title]\n[http://example.com/2:title]\n[http://example.com/3:title=given]\n[http://example.com/4:title]\n[http://example.com/5:title]\n"
+	err := x.Render(ctx, io.Discard, input)
+	var le *LimitError
+	if !errors.As(err, &le) || le.Limit != LimitTitleFetches || le.Max != 2 {
+		t.Fatalf("err = %v, want title fetches LimitError", err)
+	}
+	if fetched != 2 {
+		t.Errorf("fetched %d titles, want 2", fetched)
+	}
+	if text := x.ToText(ctx, input, TextOptions{}); text != "" {
+		t.Errorf("ToText = %q, want empty", text)
+	}
+}
+
+// 上限は取得した URL の数で数える。同じ URL の繰り返しやキャッシュにあるタイトルは数えない
+func TestLimitsTitleFetchesRepeatedURL(t *testing.T) {
+	ctx := context.Background()
+	fetched := map[string]int{}
+	x := NewXatena(WithLimits(Limits{MaxTitleFetches: 3}))
+	f := x.Inline.(*InlineFormatter)
+	f.SetTitleHandler(func(ctx context.Context, uri string) string {
+		fetched[uri]++
+		return "Title " + uri
+	})
+	input := strings.Repeat("[http://a.com/:title]\n", 5)
+	var b strings.Builder
+	if err := x.Render(ctx, &b, input); err != nil {
+		t.Fatalf("err = %v", err)
+	}
+	if n := strings.Count(b.String(), ">Title http://a.com/</a>"); n != 5 {
+		t.Errorf("%d links titled, want 5: %s", n, b.String())
+	}
+	if fetched["http://a.com/"] != 1 {
+		t.Errorf("fetched %v, want once", fetched)
+	}
+
+	newCache := func() TitleCache {
+		cache := NewLRUTitleCache(10)
+		cache.Set("http://b.com/", "B")
+		cache.Set("http://c.com/", "C")
+		return cache
+	}
+	input = "[http://b.com/:title][http://c.com/:title][http://d.com/:title][http://e.com/:title][http://f.com/:title]\n"
+	f.SetTitleCache(newCache())
+	if err := x.Render(ctx, io.Discard, input); err != nil {
+		t.Errorf("cached titles counted: %v", err)
+	}
+	f.SetTitleCache(newCache())
+	var le *LimitError
+	err := x.Render(ctx, io.Discard, input+"[http://g.com/:title]\n")
+	if !errors.As(err, &le) || le.Limit != LimitTitleFetches {
+		t.Errorf("err = %v, want title fetches LimitError", err)
+	}
+}
+
+func TestLimitsDiagnostics(t *testing.T) {
+	ctx := context.Background()
+	x := NewXatena(WithLimits(Limits{MaxNestingDepth: 1, MaxFootnotes: 1}))
+	_, diags := x.ToHTMLWithDiagnostics(ctx, "text\n>>\n>>\n")
+	if len(diags) != 1 || diags[0].String() != "3: error: nesting depth limit exceeded (max 1)" {
+		t.Errorf("diagnostics = %v", diags)
+	}
+	_, diags = x.ToHTMLWithDiagnostics(ctx, "a((1))((2))\n")
+	if len(diags) != 1 || diags[0].String() != "error: footnotes limit exceeded (max 1)" {
+		t.Errorf("diagnostics = %v", diags)
+	}
+}
+
+func TestRenderCanceled(t *testing.T) {
+	x := NewXatena()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := x.Render(ctx, io.Discard, "* a\nb\n"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Render err = %v", err)
+	}
+	if _, err := x.Parse(ctx, "a\n"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Parse err = %v", err)
+	}
+	doc, _ := x.Parse(context.Background(), "a\n\n* b\n")
+	if err := x.WriteDocument(ctx, io.Discard, doc); !errors.Is(err, context.Canceled) {
+		t.Errorf("WriteDocument err = %v", err)
+	}
+}
+
+func TestRenderCanceledWhileRendering(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fetched := 0
+	x := NewXatena()
+	x.Inline.(*InlineFormatter).SetTitleHandler(func(ctx context.Context, uri string) string {
+		fetched++
+		cancel() // タイトルの取得中に呼び出し元がキャンセルした
+		return "title"
+	})
+	var sb strings.Builder
+	err := x.Render(ctx, &sb, "[http://example.com/1:title]\n\n* next\n[http://example.com/2:title]\n")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v", err)
+	}
+	if fetched != 1 || strings.Contains(sb.String(), "next") {
+		t.Errorf("rendering did not stop: fetched %d, output %q", fetched, sb.String())
+	}
+}
@@ -171,7 +171,7 @@ func TestListNodeHasContent(t *testing.T) {
 	
 	// Parse a list to get ListNode
 	input := "- item1\n- item2"
-	root := x.parseXatena(context.Background(), input)
+	root, _ := x.parseXatena(context.Background(), input)
 	
 	if len(root.Content) == 0 {
 		t.Fatal("expected at least one node in parsed content")
@@ -0,0 +1,266 @@
+package xatena
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// 期待値に --- や === を含むので区切りは @@@ / %%% を使う
+const markdownTestData = `
+@@@ paragraph
+%%% input
+foo *bar* _baz_
+line2
+
+# not a heading
+1. not a list
+%%% expected
+foo \*bar\* \_baz\_\
+line2
+
+\# not a heading\
+1\. not a list
+
+@@@ section
+%%% input
+* title
+body
+** sub [http://example.com/]
+%%% expected
+# title
+
+body
+
+## sub <http://example.com/>
+
+@@@ nested list
+%%% input
+- a
+-- b
+--- c
+- d
++ one
+++ one-a
+%%% expected
+- a
+  - b
+    - c
+- d
+
+<!-- -->
+
+1. one
+   1. one-a
+
+@@@ table with header
+%%% input
+|*name|*value|
+|a|x|y|
+|b|[http://example.com/:title=ex]|
+%%% expected
+| name | value |  |
+| --- | --- | --- |
+| a | x | y |
+| b | [ex](http://example.com/) |  |
+
+@@@ table without header
+%%% input
+|a|b|
+%%% expected
+<table>
+  <tr>
+    <td>a</td>
+    <td>b</td>
+  </tr>
+</table>
+
+@@@ super pre
+%%% input
+>|perl|
+print "*not emphasis*";
+` + "````" + `
+||<
+%%% expected
+` + "`````" + `perl
+print "*not emphasis*";
+` + "````" + `
+` + "`````" + `
+
+@@@ blockquote with cite
+%%% input
+>http://example.com/:title=Example>
+quoted
+- item
+<<
+%%% expected
+> quoted
+>
+> - item
+>
+> — [Example](http://example.com/)
+
+@@@ footnote
+%%% input
+foo((note [http://example.com/]))bar((two))
+%%% expected
+foo[^1]bar[^2]
+
+[^1]: note <http://example.com/>
+[^2]: two
+
+@@@ seemore and stopp
+%%% input
+visible
+====
+hidden
+><div>
+raw
+
+html
+</div><
+%%% expected
+visible
+
+<div class="seemore">
+
+hidden
+
+<div>
+raw
+html
+</div>
+
+</div>
+
+@@@ definition list and pre
+%%% input
+:term:desc
+>|
+a [http://example.com/]
+
+b
+|<
+%%% expected
+<dl>
+  <dt>term</dt>
+  <dd>desc</dd>
+</dl>
+
+<pre>a <a href="http://example.com/">http://example.com/</a>
+
+b
+</pre>
+`
+
+func TestToMarkdown(t *testing.T) {
+	x := NewXatena()
+	for _, b := range parseTestBlocksWithDelim(markdownTestData, "@@@", "%%%") {
+		t.Run(b.Name, func(t *testing.T) {
+			got := x.ToMarkdown(context.Background(), b.Sections["input"])
+			if strings.TrimSpace(got) != b.Sections["expected"] {
+				t.Errorf("input:\n%s\n got:\n%s\nwant:\n%s", b.Sections["input"], got, b.Sections["expected"])
+			}
+		})
+	}
+}
+
+func TestRenderMarkdown(t *testing.T) {
+	doc, err := Parse(context.Background(), "* a\nb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := RenderMarkdown(context.Background(), doc); got != "# a\n\nb\n" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestMarkdownTitleHandler(t *testing.T) {
+	f := NewInlineFormatter(func(f *InlineFormatter) {
+		f.SetTitleHandler(func(ctx context.Context, uri string) string { return "Fetched [title]" })
+	})
+	got := NewXatenaWithInline(f).ToMarkdown(context.Background(), "[http://example.com/:title]")
+	if want := "[Fetched \\[title\\]](http://example.com/)\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// TestMarkdownAllInputs: 既存のフィクスチャがすべて変換できること
+func TestMarkdownAllInputs(t *testing.T) {
+	x := NewXatena()
+	for _, input := range allTestInputs() {
+		x.ToMarkdown(context.Background(), input)
+	}
+}
+
+// TestMarkdownUsesInlineFormatterRules: 独自のルール・無効にしたルール・aggressive なルールを HTML と同じく使う
+func TestMarkdownUsesInlineFormatterRules(t *testing.T) {
+	ctx := context.Background()
+	f := NewInlineFormatter()
+	f.AddRuleAt(0, InlineRule{
+		Name:    "kbd",
+		Pattern: regexp.MustCompile(`\[kbd:([^\]]+)\]`),
+		Handler: func(ctx context.Context, f *InlineFormatter, m []string) string { return "<kbd>" + m[1] + "</kbd>" },
+	})
+	if got, want := NewXatena(WithInline(f)).ToMarkdown(ctx, "[kbd:C] *x*"), "<kbd>C</kbd> \\*x\\*\n"; got != want {
+		t.Errorf("custom rule: got %q, want %q", got, want)
+	}
+
+	x := NewXatena(WithoutInlineRules(InlineTex, InlineFootnote))
+	if got, want := x.ToMarkdown(ctx, "[tex:x] ((n))"), "\\[tex:x\\] ((n))\n"; got != want {
+		t.Errorf("without rules: got %q, want %q", got, want)
+	}
+
+	x = NewXatena(WithInline(NewAggressiveInlineFormatter(DefaultAggressiveURLs())))
+	got := x.ToMarkdown(ctx, "[keyword:Go]")
+	html := x.ToHTML(ctx, "[keyword:Go]")
+	if !strings.HasPrefix(got, "[Go](") || !strings.Contains(html, ">Go</a>") {
+		t.Errorf("aggressive: got %q (html %q)", got, html)
+	}
+}
+
+// TestMarkdownParagraphUnit: 行をまたぐ記法も HTML と同じく段落全体で解釈する
+func TestMarkdownParagraphUnit(t *testing.T) {
+	ctx := context.Background()
+	x := NewXatena()
+	if got, want := x.ToMarkdown(ctx, "[]foo\n[tex:x][]"), "foo\\\n\\[tex:x\\]\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	doc, _ := x.Parse(ctx, "a [http://example.com/]\n  b")
+	x.ParseInlines(ctx, doc)
+	doc.Content[0].(*Text).Inlines = []InlineNode{&InlineText{Text: "replaced"}}
+	if got := RenderMarkdown(ctx, doc); got != "replaced\n" {
+		t.Errorf("got %q", got)
+	}
+}
+
+var reMarkdownUnsafeLink = regexp.MustCompile(`(?i)\]\(\s*(javascript|vbscript|data):`)
+
+// コードブロックの中身は HTML として解釈されない
+var reMarkdownFence = regexp.MustCompile("(?ms)^```.*?^```$")
+
+// TestMarkdownSafeMode: 安全モードでは Markdown に埋め込む生の HTML とリンクも ToHTML と同じくサニタイズする
+func TestMarkdownSafeMode(t *testing.T) {
+	ctx := context.Background()
+	x := NewXatena()
+	x.SafeMode = DefaultSanitizePolicy()
+	got := x.ToMarkdown(ctx, "hi <script>alert(1)</script> <img src=x onerror=alert(1)> <b>ok</b>")
+	if want := "hi  <img src=\"x\"> <b>ok</b>\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	payloads := append(xssPayloads,
+		"[http://example.com/:title=<img src=x onerror=alert(1)>]",
+		"[tex:<script>alert(1)</script>]",
+	)
+	for _, payload := range payloads {
+		md := x.ToMarkdown(ctx, payload)
+		// CommonMark は生の HTML をそのまま出力するので、HTML として危険な要素が残っていないこと
+		if problem := findXSS(reMarkdownFence.ReplaceAllString(md, "")); problem != "" {
+			t.Errorf("payload %q: %s\noutput: %s", payload, problem, md)
+		}
+		if reMarkdownUnsafeLink.MatchString(md) {
+			t.Errorf("payload %q: unsafe link destination\noutput: %s", payload, md)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package xatena
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWithoutInlineRules(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name  string
+		rules []string
+		input string
+		want  string
+	}{
+		{"html", []string{InlineHTML}, `<b>bold</b> & <a href="#top">a</a>`, `<p>&lt;b&gt;bold&lt;/b&gt; &amp; &lt;a href=&#34;#top&#34;&gt;a&lt;/a&gt;</p>`},
+		{"html escape", []string{InlineHTML}, `[]<i>[]`, `<p>&lt;i&gt;</p>`},
+		{"barcode", []string{InlineBarcode}, `[http://example.com/:barcode]`, `<p>[http://example.com/:barcode]</p>`},
+		{"barcode keeps link", []string{InlineBarcode}, `[http://example.com/:title=Example]`, `<p><a href="http://example.com/">Example</a></p>`},
+		{"footnote", []string{InlineFootnote}, `a((note))`, `<p>a((note))</p>`},
+		{"link", []string{InlineLink, InlineAutoLink}, `[http://example.com/] http://example.com/`, `<p>[http://example.com/] http://example.com/</p>`},
+		{"tex", []string{InlineTex}, `[tex:x^2]`, `<p>[tex:x^2]</p>`},
+		{"mailto", []string{InlineMailto}, `[mailto:a@example.com]`, `<p>[mailto:a@example.com]</p>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			x := NewXatena(WithoutInlineRules(tt.rules...))
+			EqualHTML(t, x.ToHTML(ctx, tt.input), tt.want)
+		})
+	}
+}
+
+func TestWithoutInlineRulesKeepsBase(t *testing.T) {
+	base := NewInlineFormatter()
+	x := NewXatena(WithInline(base), WithoutInlineRules(InlineHTML))
+	if got := x.ToHTML(context.Background(), "<b>x</b>"); got != "<p>&lt;b&gt;x&lt;/b&gt;</p>" {
+		t.Errorf("got %q", got)
+	}
+	if got := base.Format(context.Background(), "<b>x</b>"); got != "<b>x</b>" {
+		t.Errorf("base formatter changed: %q", got)
+	}
+	for _, name := range x.Inline.(*InlineFormatter).RuleNames() {
+		if name == InlineHTML {
+			t.Errorf("html rule still active")
+		}
+	}
+}
+
+func TestWithoutInlineRulesAll(t *testing.T) {
+	f := NewInlineFormatter().WithoutRules(InlineEscape, InlineFootnote, InlineHTML, InlineHTMLComment, InlineBarcode, InlineLink, InlineMailto, InlineTex, InlineAutoLink)
+	if names := f.RuleNames(); len(names) != 0 {
+		t.Errorf("RuleNames = %v", names)
+	}
+	if got := f.Format(context.Background(), "<b>[]x[] http://example.com/</b>"); got != "&lt;b&gt;[]x[] http://example.com/&lt;/b&gt;" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestWithoutInlineRulesPanicsOnCustomInline(t *testing.T) {
+	if _, err := NewXatenaE(WithInline(upperInline{}), WithoutInlineRules(InlineHTML)); err == nil {
+		t.Errorf("expected NewXatenaE to return error")
+	}
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("expected panic")
+		}
+	}()
+	NewXatena(WithInline(upperInline{}), WithoutInlineRules(InlineHTML))
+}
+
+func TestCommentProfile(t *testing.T) {
+	ctx := context.Background()
+	x := NewXatena(WithProfile(CommentProfile()))
+	input := strings.Join([]string{
+		"* title",
+		"|a|b|",
+		"====",
+		"<b>x</b>((note))[http://example.com/:barcode]",
+		"- item",
+	}, "\n")
+	want := `<p>* title<br />
+|a|b|<br />
+====<br />
+&lt;b&gt;x&lt;/b&gt;((note))[http://example.com/:barcode]</p>
+<ul>
+<li>item</li>
+</ul>`
+	EqualHTML(t, x.ToHTML(ctx, input), want)
+
+	md := x.ToMarkdown(ctx, "<b>x</b>((note))")
+	if md != "&lt;b&gt;x&lt;/b&gt;((note))\n" {
+		t.Errorf("markdown got %q", md)
+	}
+	text := x.ToText(ctx, "<b>x</b>((note))", TextOptions{})
+	if text != "<b>x</b>((note))" {
+		t.Errorf("text got %q", text)
+	}
+}
+
+func TestBioProfile(t *testing.T) {
+	x := NewXatena(WithProfile(BioProfile()))
+	if names := x.BlockParserNames(); len(names) != 0 {
+		t.Errorf("BlockParserNames = %v", names)
+	}
+	input := ">>\nquote [tex:x] [http://example.com/:title=site]\n<<\n- item"
+	want := `<p>&gt;&gt;<br />
+quote [tex:x] <a href="http://example.com/">site</a><br />
+&lt;&lt;<br />
+- item</p>`
+	EqualHTML(t, x.ToHTML(context.Background(), input), want)
+}
+
+func TestArticleProfile(t *testing.T) {
+	x := NewXatena(WithProfile(ArticleProfile()))
+	if got, want := x.BlockParserNames(), NewXatena().BlockParserNames(); !reflect.DeepEqual(got, want) {
+		t.Errorf("BlockParserNames = %v, want %v", got, want)
+	}
+	if got := x.ToHTML(context.Background(), "<b>x</b>"); got != "<p><b>x</b></p>" {
+		t.Errorf("got %q", got)
+	}
+}
+
+type upperInline struct{}
+
+func (upperInline) Format(ctx context.Context, s string) string { return strings.ToUpper(s) }
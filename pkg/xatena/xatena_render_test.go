@@ -0,0 +1,100 @@
+package xatena
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"io"
+	"strings"
+	"testing"
+)
+
+// TestRenderEqualsToHTML: Render の出力が ToHTML とバイト単位で一致すること
+func TestRenderEqualsToHTML(t *testing.T) {
+	ctx := context.Background()
+	for _, compat := range []bool{false, true} {
+		x := NewXatenaWithFields(NewInlineFormatter(), compat)
+		for _, input := range allTestInputs() {
+			var sb strings.Builder
+			if err := x.Render(ctx, &sb, input); err != nil {
+				t.Fatalf("Render(%q) returned error: %v", input, err)
+			}
+			if want := x.ToHTML(ctx, input); sb.String() != want {
+				t.Errorf("compat=%v input=%q\nRender=%q\nToHTML=%q", compat, input, sb.String(), want)
+			}
+		}
+	}
+}
+
+type failingWriter struct {
+	n int // 書き込みを受け付ける回数
+}
+
+var errWriteFailed = errors.New("write failed")
+
+func (f *failingWriter) Write(p []byte) (int, error) {
+	if f.n <= 0 {
+		return 0, errWriteFailed
+	}
+	f.n--
+	return len(p), nil
+}
+
+func TestRenderWriteError(t *testing.T) {
+	x := NewXatena()
+	input := "* section\n\nfoo((note))\n\n- a\n- b\n\n|a|b|\n"
+	for n := 0; n < 5; n++ {
+		err := x.Render(context.Background(), &failingWriter{n: n}, input)
+		if !errors.Is(err, errWriteFailed) {
+			t.Errorf("n=%d: expected write error, got %v", n, err)
+		}
+	}
+}
+
+// errorNode は書き出しに失敗する独自ノード
+type errorNode struct{}
+
+func (errorNode) ToHTML(ctx context.Context, xatena XatenaContext, options CallerOptions) string {
+	return ""
+}
+
+func (errorNode) WriteHTML(ctx context.Context, w io.Writer, xatena XatenaContext, options CallerOptions) error {
+	return errWriteFailed
+}
+
+// TestRenderNestedError: ブロックの中の子ノードのエラーも捨てずに返す
+func TestRenderNestedError(t *testing.T) {
+	ctx := context.Background()
+	x := NewXatena()
+	for _, input := range []string{"* a\nx", "* a\n>>\nx\n<<", "=====\nx", ">|\nx\n|<", "><div>\nx\n</div><"} {
+		doc, _ := x.Parse(ctx, input)
+		var inner HasContent
+		Walk(doc, func(n Node) bool {
+			switch v := n.(type) {
+			case *Section, *Blockquote, *SeeMore, *Pre, *StopP:
+				inner = v.(HasContent)
+			}
+			return true
+		})
+		inner.AddChild(errorNode{})
+		if err := x.WriteDocument(ctx, io.Discard, doc); !errors.Is(err, errWriteFailed) {
+			t.Errorf("input %q: expected error, got %v", input, err)
+		}
+	}
+}
+
+// TestRenderContentTemplate: Content を使わない・複数回使うテンプレートでも子要素を書き出せる
+func TestRenderContentTemplate(t *testing.T) {
+	ctx := context.Background()
+	x := NewXatena()
+	x.Templates["seemore"] = template.Must(template.New("seemore").Parse(`<div>{{.Content}}|{{.Content}}</div>`))
+	x.Templates["blockquote"] = template.Must(template.New("blockquote").Parse(`<blockquote></blockquote>`))
+	got := x.ToHTML(ctx, "=====\na\n- b\n-- c")
+	content := NewXatena().ToHTML(ctx, "a\n- b\n-- c")
+	if want := "<div>" + content + "|" + content + "</div>"; strings.TrimSpace(got) != want {
+		t.Errorf("got %q", got)
+	}
+	if got := x.ToHTML(ctx, ">>\na\n<<"); strings.TrimSpace(got) != "<blockquote></blockquote>" {
+		t.Errorf("got %q", got)
+	}
+}
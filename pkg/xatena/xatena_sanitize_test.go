@@ -0,0 +1,214 @@
+package xatena
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+// xssPayloads は既知の XSS ペイロードを各記法に埋め込んだコーパス
+var xssPayloads = []string{
+	`<script>alert(1)</script>`,
+	`<SCRIPT SRC=http://xss.example/xss.js></SCRIPT>`,
+	`<img src=x onerror=alert(1)>`,
+	`<img src="x" ONERROR="alert(1)">`,
+	`<img src="javascript:alert(1)">`,
+	`<img src="data:image/svg+xml;base64,PHN2ZyBvbmxvYWQ9YWxlcnQoMSk+">`,
+	`<svg onload=alert(1)>`,
+	`<body onload=alert(1)>`,
+	`<a href="javascript:alert(1)">x</a>`,
+	`<a href="JaVaScRiPt:alert(1)">x</a>`,
+	`<a href=" javascript:alert(1)">x</a>`,
+	"<a href=\"java\tscript:alert(1)\">x</a>",
+	`<a href="jav&#x09;ascript:alert(1)">x</a>`,
+	`<a href="&#106;avascript:alert(1)">x</a>`,
+	`<a href="vbscript:msgbox(1)">x</a>`,
+	`<a href="data:text/html;base64,PHNjcmlwdD5hbGVydCgxKTwvc2NyaXB0Pg==">x</a>`,
+	`<a href="http://example.com/" onclick="alert(1)">x</a>`,
+	`<iframe src="javascript:alert(1)"></iframe>`,
+	`<object data="javascript:alert(1)"></object>`,
+	`<embed src="javascript:alert(1)">`,
+	`<form action="javascript:alert(1)"><button>x</button></form>`,
+	`<base href="javascript:/">`,
+	`<meta http-equiv="refresh" content="0;url=javascript:alert(1)">`,
+	`<link rel="stylesheet" href="http://xss.example/x.css">`,
+	`<style>body{background:url(javascript:alert(1))}</style>`,
+	`<div style="background:url(javascript:alert(1))">x</div>`,
+	`<scr<script>ipt>alert(1)</script>`,
+	`<<script>script>alert(1)<</script>/script>`,
+	`<math><mtext><table><mglyph><style><img src=x onerror=alert(1)>`,
+	`<!--<img src=x onerror=alert(1)>-->`,
+	`[http://example.com/"onmouseover="alert(1)]`,
+	`[http://example.com/"onmouseover="alert(1):title=x]`,
+	`http://example.com/"onmouseover="alert(1)`,
+	`[mailto:a@example.com?x="onclick="alert(1)]`,
+	`foo((<img src=x onerror=alert(1)>))`,
+	`* <script>alert(1)</script>`,
+	`|<img src=x onerror=alert(1)>|`,
+	`- <a href="javascript:alert(1)">x</a>`,
+	`:<b onclick="alert(1)">term</b>:desc`,
+	"><div onclick=\"alert(1)\"><\nfoo\n></div><",
+	">http://example.com/\"onclick=\"alert(1)>\nquote\n<<",
+	">http://example.com/\"onclick=\"alert(1):title=x>\nquote\n<<",
+	">javascript:alert(1)>\nquote\n<<",
+	">|\n<script>alert(1)</script>\n|<",
+	">|html|\n<script>alert(1)</script>\n||<",
+}
+
+var xssForbiddenElements = map[string]bool{
+	"script": true, "style": true, "iframe": true, "object": true, "embed": true,
+	"form": true, "base": true, "meta": true, "link": true, "svg": true, "math": true,
+}
+
+func TestSafeModeXSSCorpus(t *testing.T) {
+	x := NewXatena()
+	x.SafeMode = DefaultSanitizePolicy()
+	for _, payload := range xssPayloads {
+		got := x.ToHTML(context.Background(), payload)
+		if problem := findXSS(got); problem != "" {
+			t.Errorf("payload %q: %s\noutput: %s", payload, problem, got)
+		}
+	}
+}
+
+// findXSS: 出力をパースし、危険な要素・属性・URL が残っていれば説明を返す
+func findXSS(s string) string {
+	doc, err := html.Parse(strings.NewReader(s))
+	if err != nil {
+		return "parse error: " + err.Error()
+	}
+	var walk func(n *html.Node) string
+	walk = func(n *html.Node) string {
+		if n.Type == html.ElementNode {
+			if xssForbiddenElements[n.Data] {
+				return "forbidden element <" + n.Data + ">"
+			}
+			for _, a := range n.Attr {
+				if strings.HasPrefix(a.Key, "on") || a.Key == "style" {
+					return "forbidden attribute " + a.Key
+				}
+				if sanitizeURLAttrs[a.Key] {
+					v := strings.ToLower(strings.Join(strings.Fields(a.Val), ""))
+					for _, scheme := range []string{"javascript:", "vbscript:", "data:"} {
+						if strings.HasPrefix(v, scheme) {
+							return "forbidden URL " + a.Key + "=" + a.Val
+						}
+					}
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if p := walk(c); p != "" {
+				return p
+			}
+		}
+		return ""
+	}
+	return walk(doc)
+}
+
+func TestSafeModeKeepsAllowedMarkup(t *testing.T) {
+	x := NewXatena()
+	x.SafeMode = DefaultSanitizePolicy()
+	cases := []struct {
+		input  string
+		expect string
+	}{
+		{`<strong>bold</strong>`, `<p><strong>bold</strong></p>`},
+		{`<a href="http://example.com/" onclick="x">link</a>`, `<p><a href="http://example.com/">link</a></p>`},
+		{`<a href="/relative">rel</a>`, `<p><a href="/relative">rel</a></p>`},
+		{`<a href="javascript:alert(1)">x</a>`, `<p><a>x</a></p>`},
+		{`<script>alert(1)</script>after`, `<p>after</p>`},
+		{`<blink>text</blink>`, `<p>text</p>`},
+		{`[http://example.com/]`, `<p><a href="http://example.com/">http://example.com/</a></p>`},
+		{`[mailto:foo@example.com]`, `<p><a href="mailto:foo@example.com">foo@example.com</a></p>`},
+	}
+	for _, c := range cases {
+		got := x.ToHTML(context.Background(), c.input)
+		if got != c.expect {
+			t.Errorf("input=%q\nexpect=%q\ngot   =%q", c.input, c.expect, got)
+		}
+	}
+}
+
+func TestSafeModeBarcode(t *testing.T) {
+	ctx := context.Background()
+	x := NewXatena()
+	x.SafeMode = DefaultSanitizePolicy()
+	got := x.ToHTML(ctx, `[http://example.com/:barcode] <img src="data:image/png;base64,AAAA" onerror="x">`)
+	if want := "<p>" + exampleBarcode + ` <img></p>`; got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+
+	// <svg> で埋め込む形式もそのまま出力する
+	f := NewInlineFormatter(func(f *InlineFormatter) {
+		f.SetBarcodeOptions(BarcodeOptions{Inline: true})
+	})
+	x = NewXatena(WithInline(f))
+	x.SafeMode = DefaultSanitizePolicy()
+	got = x.ToHTML(ctx, `<script>[http://example.com/:barcode]</script>`)
+	if !strings.HasPrefix(got, `<p><svg `) || !strings.Contains(got, `<title>http://example.com/</title>`) || strings.Contains(got, "script") {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestSafeModeTex(t *testing.T) {
+	ctx := context.Background()
+	x := NewXatena()
+	x.SafeMode = DefaultSanitizePolicy()
+	got := x.ToHTML(ctx, `[tex:x^2] <math><mi onclick="x">y</mi></math> [tex:\unknown<script>]`)
+	want := `<p><math xmlns="http://www.w3.org/1998/Math/MathML" alttext="x^2"><msup><mi>x</mi><mn>2</mn></msup></math> y <code class="tex">\unknown&lt;script&gt;</code></p>`
+	if got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+// TestGeneratedURIsAreEscaped: 安全モードでなくても生成した href は常にエスケープされる
+func TestGeneratedURIsAreEscaped(t *testing.T) {
+	x := NewXatena()
+	cases := []struct {
+		input  string
+		expect string
+	}{
+		{`[http://example.com/"onmouseover="alert(1)]`, `href="http://example.com/&#34;onmouseover=&#34;alert(1)"`},
+		{`http://example.com/?a=1&b=2`, `href="http://example.com/?a=1&amp;b=2"`},
+		{`[mailto:a@example.com?subject="x"]`, `href="mailto:a@example.com?subject=&#34;x&#34;"`},
+		{">http://example.com/\"x:title=foo>\nquote\n<<", `<cite><a href="http://example.com/&#34;x">foo</a></cite>`},
+	}
+	for _, c := range cases {
+		got := x.ToHTML(context.Background(), c.input)
+		if !strings.Contains(got, c.expect) {
+			t.Errorf("input=%q\nexpect to contain %q\ngot %q", c.input, c.expect, got)
+		}
+	}
+}
+
+// TestSafeModeEditedInlines: ParseInlines で書き換えた要素も安全モードで出力に反映し、サニタイズする
+func TestSafeModeEditedInlines(t *testing.T) {
+	ctx := context.Background()
+	x := NewXatena()
+	x.SafeMode = DefaultSanitizePolicy()
+	doc, _ := x.Parse(ctx, "hello world\n\n* title")
+	if err := x.ParseInlines(ctx, doc); err != nil {
+		t.Fatal(err)
+	}
+	doc.Content[0].(*Text).Inlines = []InlineNode{
+		&InlineText{Text: "EDITED "},
+		&RawHTML{HTML: `<img src=x onerror="alert(1)">`},
+	}
+	doc.Content[len(doc.Content)-1].(*Section).TitleInlines = []InlineNode{&RawHTML{HTML: "<script>alert(1)</script>"}, &InlineText{Text: "T"}}
+	got := x.RenderDocument(ctx, doc)
+	for _, want := range []string{
+		`<p>EDITED <img src="x"></p>`,
+		`<h3>T</h3>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %s in %s", want, got)
+		}
+	}
+	if problem := findXSS(got); problem != "" {
+		t.Errorf("%s\noutput: %s", problem, got)
+	}
+}
@@ -0,0 +1,239 @@
+package xatena
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+const sourcePosInput = `* section
+foo
+bar
+
+- a
+-- b
+- c
+
+|x|y|
+|1|2|
+
+>>
+quote
+<<
+
+>|perl|
+print 1;
+||<
+:term:desc
+=====
+more`
+
+func TestParsePositions(t *testing.T) {
+	doc, err := Parse(context.Background(), sourcePosInput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]Position{}
+	Walk(doc, func(n Node) bool {
+		p, ok := n.(Positioned)
+		if !ok {
+			return true
+		}
+		switch v := n.(type) {
+		case *Text:
+			if v.Text != "" {
+				got["text:"+v.Text] = p.GetPosition()
+			}
+		case *Section:
+			got["section"] = p.GetPosition()
+		case *List:
+			got["list"] = p.GetPosition()
+		case *Table:
+			got["table"] = p.GetPosition()
+		case *Blockquote:
+			got["blockquote"] = p.GetPosition()
+		case *SuperPre:
+			got["superpre"] = p.GetPosition()
+		case *DefinitionList:
+			got["dl"] = p.GetPosition()
+		case *SeeMore:
+			got["seemore"] = p.GetPosition()
+		}
+		return true
+	})
+	expected := map[string]Position{
+		"section":    {StartLine: 1, EndLine: 21},
+		"text:foo":   {StartLine: 2, EndLine: 2},
+		"text:bar":   {StartLine: 3, EndLine: 3},
+		"list":       {StartLine: 5, EndLine: 7},
+		"table":      {StartLine: 9, EndLine: 10},
+		"blockquote": {StartLine: 12, EndLine: 14},
+		"text:quote": {StartLine: 13, EndLine: 13},
+		"superpre":   {StartLine: 16, EndLine: 18},
+		"dl":         {StartLine: 19, EndLine: 19},
+		"seemore":    {StartLine: 20, EndLine: 21},
+		"text:more":  {StartLine: 21, EndLine: 21},
+	}
+	for k, want := range expected {
+		if got[k] != want {
+			t.Errorf("%s: got %+v, want %+v", k, got[k], want)
+		}
+	}
+}
+
+func TestParsePositions_ListItems(t *testing.T) {
+	doc, _ := Parse(context.Background(), "- a\n-- b\n-- c\n- d")
+	list := doc.Content[0].(*List)
+	outer := list.Items[0]
+	if outer.GetPosition() != (Position{StartLine: 1, EndLine: 4}) {
+		t.Errorf("ul: got %+v", outer.GetPosition())
+	}
+	if p := outer.Items[0].GetPosition(); p != (Position{StartLine: 1, EndLine: 3}) {
+		t.Errorf("first li: got %+v", p)
+	}
+	if p := outer.Items[1].GetPosition(); p != (Position{StartLine: 4, EndLine: 4}) {
+		t.Errorf("second li: got %+v", p)
+	}
+	inner := outer.Items[0].Content[1].(*ListStruct)
+	if p := inner.GetPosition(); p != (Position{StartLine: 2, EndLine: 3}) {
+		t.Errorf("nested ul: got %+v", p)
+	}
+}
+
+func TestParsePositions_TextOffset(t *testing.T) {
+	input := "ab\r\ncd\n\nあいう"
+	doc, _ := Parse(context.Background(), input)
+	// オフセットは改行を正規化する前の入力に対するもの
+	for _, n := range doc.Content {
+		tn := n.(*Text)
+		if !strings.HasPrefix(input[tn.Offset:], tn.Text) {
+			t.Errorf("line %d: offset %d does not point at %q", tn.StartLine, tn.Offset, tn.Text)
+		}
+	}
+}
+
+func TestSourcePosAttribute(t *testing.T) {
+	x := NewXatena()
+	x.SourcePos = true
+	got := x.ToHTML(context.Background(), sourcePosInput)
+	for _, want := range []string{
+		`<div class="section" data-sourcepos="1-21">`,
+		`<ul data-sourcepos="5-7">`,
+		`<li data-sourcepos="5-6">`,
+		`<ul data-sourcepos="6-6">`,
+		`<table data-sourcepos="9-10">`,
+		`<blockquote data-sourcepos="12-14">`,
+		`<pre class="code lang-perl" data-sourcepos="16-18">`,
+		`<dl data-sourcepos="19-19">`,
+		`<div class="seemore" data-sourcepos="20-21">`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %s in %s", want, got)
+		}
+	}
+
+	x = NewXatenaWithFields(NewInlineFormatter(), true)
+	x.SourcePos = true
+	got = x.ToHTML(context.Background(), "* title\nfoo")
+	if !strings.Contains(got, `<h3 data-sourcepos="1-2">title</h3>`) {
+		t.Errorf("hatena compatible section: %s", got)
+	}
+}
+
+func TestSourcePosParagraph(t *testing.T) {
+	x := NewXatena()
+	x.SourcePos = true
+	got := x.ToHTML(context.Background(), "* title\na\nb\n\n\nc\n- x\nd")
+	for _, want := range []string{
+		`<p data-sourcepos="2-3">a<br />`,
+		`<p data-sourcepos="6-6">c</p>`,
+		`<p data-sourcepos="8-8">d</p>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %s in %s", want, got)
+		}
+	}
+
+	x = NewXatena(WithHatenaCompatible(true))
+	x.SourcePos = true
+	got = x.ToHTML(context.Background(), "a\nb")
+	if !strings.Contains(got, `<p data-sourcepos="1-1">a</p><p data-sourcepos="2-2">b</p>`) {
+		t.Errorf("hatena compatible paragraph: %s", got)
+	}
+
+	// 整形で段落の区切りが増減して元の行と対応しなければ段落全体の範囲にする
+	f := NewInlineFormatter()
+	f.AddRuleAt(0, InlineRule{
+		Pattern: regexp.MustCompile(`\[split\]`),
+		Handler: func(ctx context.Context, f *InlineFormatter, m []string) string { return "x\n\ny" },
+	})
+	x = NewXatena(WithInline(f))
+	x.SourcePos = true
+	got = x.ToHTML(context.Background(), "a[split]\nb")
+	if strings.Count(got, `<p data-sourcepos="1-2">`) != 2 {
+		t.Errorf("paragraph split by inline: %s", got)
+	}
+}
+
+// TestInlinePositions: インライン要素の位置から入力全体でのオフセットを求められる
+func TestInlinePositions(t *testing.T) {
+	ctx := context.Background()
+	input := "x\r\nfoo [http://example.com/]\r\nbar ((note))"
+	x := NewXatena()
+	doc, _ := x.Parse(ctx, input)
+	if err := x.ParseInlines(ctx, doc); err != nil {
+		t.Fatal(err)
+	}
+	var lines []*Text
+	for _, n := range doc.Content {
+		lines = append(lines, n.(*Text))
+	}
+	text := paragraphText(lines)
+	var got []string
+	for _, n := range lines[0].Inlines {
+		if _, ok := n.(*InlineText); ok {
+			continue
+		}
+		p := n.GetInlinePos()
+		// 段落のテキストでの位置を、その行の Offset からの位置に直す
+		line := strings.Count(text[:p.Start], "\n")
+		col := p.Start - (strings.LastIndex(text[:p.Start], "\n") + 1)
+		start := lines[line].Offset + col
+		got = append(got, input[start:start+p.End-p.Start])
+	}
+	want := []string{"[http://example.com/]", "((note))"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// TestSourcePosDisabled: オプションが無効なら出力は変わらない
+func TestSourcePosDisabled(t *testing.T) {
+	for _, input := range allTestInputs() {
+		got := Format(input)
+		if strings.Contains(got, "data-sourcepos") {
+			t.Fatalf("unexpected data-sourcepos for %q: %s", input, got)
+		}
+	}
+}
+
+func TestInlineSpans(t *testing.T) {
+	f := NewInlineFormatter()
+	s := "foo [http://example.com/] bar ((note)) baz"
+	spans := f.Spans(s)
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %+v", spans)
+	}
+	for _, sp := range spans {
+		if s[sp.Start:sp.End] != sp.Text {
+			t.Errorf("span %+v does not match source %q", sp, s[sp.Start:sp.End])
+		}
+	}
+	if spans[0].Text != "[http://example.com/]" || spans[1].Text != "((note))" {
+		t.Errorf("unexpected spans: %+v", spans)
+	}
+	if sp := f.Spans("\n<b>x</b>"); len(sp) == 0 || sp[0].Start != 1 {
+		t.Errorf("offset should be relative to untrimmed input: %+v", sp)
+	}
+}
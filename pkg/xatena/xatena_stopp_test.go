@@ -1,6 +1,9 @@
 package xatena
 
 import (
+	"context"
+	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -25,7 +28,6 @@ foobar
 <p>foobar</p>
 </ins>
 
-
 `
 
 func TestFormat_StopP_ENDStyle(t *testing.T) {
@@ -39,3 +41,28 @@ func TestFormat_StopP_ENDStyle(t *testing.T) {
 		})
 	}
 }
+
+// >< は一番内側の開いているブロックを閉じる (引用の中なら引用を閉じる)
+func TestStopPClosesInnermostBlock(t *testing.T) {
+	doc, err := Parse(context.Background(), "><div>\n>>\nfoo\n</div><\nbar\n<<\nbaz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var outline func(nodes []Node) string
+	outline = func(nodes []Node) string {
+		var parts []string
+		for _, n := range nodes {
+			switch v := n.(type) {
+			case *Text:
+				parts = append(parts, fmt.Sprintf("%q", v.Text))
+			case HasContent:
+				parts = append(parts, fmt.Sprintf("%s(%s)", strings.TrimPrefix(fmt.Sprintf("%T", n), "*syntax."), outline(v.GetContent())))
+			}
+		}
+		return strings.Join(parts, " ")
+	}
+	want := `StopPNode("<div>" BlockquoteNode("foo" "</div>") "bar") "baz"`
+	if got := outline(doc.Content); got != want {
+		t.Errorf("tree = %s, want %s", got, want)
+	}
+}
@@ -2,6 +2,8 @@ package xatena
 
 import (
 	"context"
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/cho45/xatena-go/internal/syntax"
@@ -82,6 +84,38 @@ test
 </pre>
 <p>test</p>
 
+=== test
+--- input
+>|go:main.go{1,3-4}|
+a
+<b>
+c
+d
+||<
+--- expected
+<figure class="code-block"><figcaption>main.go</figcaption><pre class="code lang-go"><span class="line emphasized" data-line="1">a</span>
+<span class="line" data-line="2">&lt;b&gt;</span>
+<span class="line emphasized" data-line="3">c</span>
+<span class="line emphasized" data-line="4">d</span></pre></figure>
+
+=== test
+--- input
+>|@10|
+a
+b
+||<
+--- expected
+<figure class="code-block"><pre class="code"><span class="line" data-line="10">a</span>
+<span class="line" data-line="11">b</span></pre></figure>
+
+=== test
+--- input
+>|:Makefile|
+all:
+||<
+--- expected
+<figure class="code-block"><figcaption>Makefile</figcaption><pre class="code"><span class="line" data-line="1">all:</span></pre></figure>
+
 
 `
 
@@ -103,7 +137,7 @@ func TestSuperPreNodeHasContent(t *testing.T) {
 	
 	// Parse a superpre to get SuperPreNode
 	input := ">||\ncode\n||<"
-	root := x.parseXatena(context.Background(), input)
+	root, _ := x.parseXatena(context.Background(), input)
 	
 	if len(root.Content) == 0 {
 		t.Fatal("expected at least one node in parsed content")
@@ -132,3 +166,50 @@ func TestSuperPreNodeHasContent(t *testing.T) {
 		t.Errorf("expected nil content from SuperPreNode.GetContent(), got %v", content)
 	}
 }
+
+func TestSuperPreHeader(t *testing.T) {
+	cases := []struct {
+		header string
+		want   SuperPre
+	}{
+		{"go", SuperPre{Lang: "go"}},
+		{"", SuperPre{}},
+		{"c++", SuperPre{Lang: "c++"}},
+		{"go:cmd/main.go", SuperPre{Lang: "go", Filename: "cmd/main.go"}},
+		{"go:main.go@10{1, 4-6,9-8}", SuperPre{Lang: "go", Filename: "main.go", FirstLine: 10,
+			Emphasis: []LineRange{{From: 1, To: 1}, {From: 4, To: 6}, {From: 8, To: 9}}}},
+		{"perl{2}", SuperPre{Lang: "perl", Emphasis: []LineRange{{From: 2, To: 2}}}},
+		// 拡張した形として読めないものは全体を言語にする
+		{"go{x}", SuperPre{Lang: "go{x}"}},
+		{"go@0", SuperPre{Lang: "go@0"}},
+	}
+	x := NewXatena()
+	for _, c := range cases {
+		t.Run(c.header, func(t *testing.T) {
+			root, _ := x.parseXatena(context.Background(), ">|"+c.header+"|\ncode\n||<")
+			got, ok := root.Content[0].(*SuperPre)
+			if !ok {
+				t.Fatalf("got %T, want *SuperPre", root.Content[0])
+			}
+			if got.Lang != c.want.Lang || got.Filename != c.want.Filename || got.FirstLine != c.want.FirstLine ||
+				fmt.Sprint(got.Emphasis) != fmt.Sprint(c.want.Emphasis) {
+				t.Errorf("got %+v, want %+v", *got, c.want)
+			}
+		})
+	}
+}
+
+func TestSuperPreAttributesWithHighlighter(t *testing.T) {
+	x := NewXatena()
+	// 行をまたぐタグは行ごとに閉じて開き直す
+	x.Highlighter = HighlighterFunc(func(lang, code string) (string, bool) {
+		return `<b class="x">` + code + "</b><br/>", true
+	})
+	got := strings.TrimSpace(x.ToHTML(context.Background(), ">|x{2}|\n1\n2\n3\n||<"))
+	want := `<figure class="code-block"><pre class="code lang-x"><span class="line" data-line="1"><b class="x">1</b></span>
+<span class="line emphasized" data-line="2"><b class="x">2</b></span>
+<span class="line" data-line="3"><b class="x">3</b><br/></span></pre></figure>`
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
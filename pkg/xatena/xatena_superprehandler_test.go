@@ -0,0 +1,105 @@
+package xatena
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestBuiltinSuperPreHandlers(t *testing.T) {
+	ctx := context.Background()
+	x := NewXatena(WithSuperPreHandlers(BuiltinSuperPreHandlers()))
+	cases := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"csv", ">|csv|\nname,note\na,\"x, <y>\"\nb\n||<", `<table class="lang-csv">
+  <tr>
+    <th>name</th>
+    <th>note</th>
+  </tr>
+  <tr>
+    <td>a</td>
+    <td>x, &lt;y&gt;</td>
+  </tr>
+  <tr>
+    <td>b</td>
+  </tr>
+</table>`},
+		{"tsv", ">|TSV|\na\tb\n||<", `<table class="lang-tsv">
+  <tr>
+    <th>a</th>
+    <th>b</th>
+  </tr>
+</table>`},
+		{"math", ">|math|\nx^2\n||<", `<div class="math"><math xmlns="http://www.w3.org/1998/Math/MathML" display="block" alttext="x^2"><msup><mi>x</mi><mn>2</mn></msup></math></div>`},
+		{"mermaid", ">|mermaid|\ngraph TD\nA-->B\n||<", `<div class="diagram mermaid">graph TD
+A--&gt;B</div>`},
+		{"dot", ">|dot|\ndigraph { a -> b }\n||<", `<div class="diagram dot">digraph { a -&gt; b }</div>`},
+		// 変換できない内容や登録のない言語は <pre class="code"> のまま
+		{"invalid math", ">|math|\n\\unknown\n||<", `<pre class="code lang-math">\unknown</pre>`},
+		{"html not registered", ">|html|\n<b>b</b>\n||<", `<pre class="code lang-html">&lt;b&gt;b&lt;/b&gt;</pre>`},
+		{"no lang", ">||\na,b\n||<", `<pre class="code">a,b</pre>`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := strings.TrimSpace(x.ToHTML(ctx, c.input)); got != c.want {
+				t.Errorf("got\n%s\nwant\n%s", got, c.want)
+			}
+		})
+	}
+}
+
+func TestRawHTMLHandler(t *testing.T) {
+	ctx := context.Background()
+	input := ">|html|\n<video src=\"a.mp4\"></video>\n||<"
+	x := NewXatena()
+	x.SetSuperPreHandler("HTML", RawHTMLHandler)
+	if got, want := strings.TrimSpace(x.ToHTML(ctx, input)), `<video src="a.mp4"></video>`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	// 安全モードではエスケープした <pre> にする
+	x.SafeMode = DefaultSanitizePolicy()
+	if got, want := strings.TrimSpace(x.ToHTML(ctx, input)), `<pre class="code lang-html">&lt;video src=&#34;a.mp4&#34;&gt;&lt;/video&gt;</pre>`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	x.SetSuperPreHandler("html", nil)
+	if len(x.SuperPreHandlers) != 0 {
+		t.Errorf("SuperPreHandlers = %v", x.SuperPreHandlers)
+	}
+}
+
+func TestSuperPreHandlerFunc(t *testing.T) {
+	ctx := context.Background()
+	var got *SuperPre
+	x := NewXatena(WithSuperPreHandlers(map[string]SuperPreHandler{
+		"Chart": SuperPreHandlerFunc(func(ctx context.Context, x *Xatena, block *SuperPre) (string, error) {
+			got = block
+			if block.RawText == "bad" {
+				return "", errors.New("bad")
+			}
+			return `<canvas data-src="` + block.Filename + `"></canvas>`, nil
+		}),
+	}))
+	x.SourcePos = true
+	if out := strings.TrimSpace(x.ToHTML(ctx, ">|chart:a.json|\n{}\n||<")); out != `<canvas data-src="a.json"></canvas>` {
+		t.Errorf("got %s", out)
+	}
+	if got == nil || got.RawText != "{}" || got.StartLine != 1 {
+		t.Errorf("block = %+v", got)
+	}
+	if out := strings.TrimSpace(x.ToHTML(ctx, ">|chart|\nbad\n||<")); out != `<pre class="code lang-chart" data-sourcepos="1-3">bad</pre>` {
+		t.Errorf("got %s", out)
+	}
+}
+
+func TestSuperPreHandlerSourcePos(t *testing.T) {
+	x := NewXatena(WithSuperPreHandlers(BuiltinSuperPreHandlers()))
+	x.SourcePos = true
+	got := x.ToHTML(context.Background(), "a\n\n>|mermaid|\nA\n||<")
+	if !strings.Contains(got, `<div class="diagram mermaid" data-sourcepos="3-5">A</div>`) {
+		t.Errorf("got %s", got)
+	}
+}
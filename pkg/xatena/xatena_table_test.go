@@ -97,7 +97,7 @@ func TestTableNodeHasContent(t *testing.T) {
 	
 	// Parse a table to get TableNode
 	input := "|cell1|cell2|\n|data1|data2|"
-	root := x.parseXatena(context.Background(), input)
+	root, _ := x.parseXatena(context.Background(), input)
 	
 	if len(root.Content) == 0 {
 		t.Fatal("expected at least one node in parsed content")
@@ -0,0 +1,49 @@
+package xatena
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTexMathML(t *testing.T) {
+	ctx := context.Background()
+	x := NewXatena()
+	got := x.ToHTML(ctx, `[tex:\frac{1}{2}] and [tex:\sum_{i=1}^n x_i]`)
+	want := `<p><math xmlns="http://www.w3.org/1998/Math/MathML" alttext="\frac{1}{2}"><mfrac><mn>1</mn><mn>2</mn></mfrac></math> and ` +
+		`<math xmlns="http://www.w3.org/1998/Math/MathML" alttext="\sum_{i=1}^n x_i"><mrow><munderover><mo>∑</mo><mrow><mi>i</mi><mo>=</mo><mn>1</mn></mrow><mi>n</mi></munderover><msub><mi>x</mi><mi>i</mi></msub></mrow></math></p>`
+	if got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestTexUnsupportedFallback(t *testing.T) {
+	ctx := context.Background()
+	got := NewInlineFormatter().Format(ctx, `[tex:\unknown{x} < y]`)
+	if want := `<code class="tex">\unknown{x} &lt; y</code>`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestTexRenderer(t *testing.T) {
+	ctx := context.Background()
+	f := NewInlineFormatter(func(f *InlineFormatter) {
+		f.SetTexRenderer(TexRendererFunc(func(ctx context.Context, expr string) (string, error) {
+			if strings.Contains(expr, "bad") {
+				return "", errors.New("bad")
+			}
+			return `<span class="katex">` + expr + `</span>`, nil
+		}))
+	})
+	// セッションや WithoutRules の複製にも引き継ぐ
+	x := NewXatena(WithInline(f), WithoutInlineRules(InlineFootnote))
+	got := x.ToHTML(ctx, "[tex:x^2] [tex:bad]")
+	if want := `<p><span class="katex">x^2</span> <code class="tex">bad</code></p>`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	f.SetTexRenderer(nil)
+	if got := f.Format(ctx, "[tex:x]"); !strings.HasPrefix(got, "<math") {
+		t.Errorf("nil renderer should fall back to MathML: %s", got)
+	}
+}
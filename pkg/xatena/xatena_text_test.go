@@ -0,0 +1,207 @@
+package xatena
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+const textTestData = `
+=== paragraph and inline
+--- input
+foo <b>bold</b> &amp; [http://example.com/:title=Example Site]
+bar((footnote)) [mailto:a@example.com]<!-- hidden -->
+--- expected
+foo bold & Example Site
+bar a@example.com
+
+=== section
+--- input
+* title [http://example.com/]
+body
+
+** sub
+text
+--- expected
+title http://example.com/
+
+body
+
+sub
+
+text
+
+=== list
+--- input
+- a
+-- b
+- c
++ one
++ two
+++ two-a
+--- expected
+- a
+  - b
+- c
+1. one
+2. two
+  1. two-a
+
+=== table and definition list
+--- input
+|*name|*value|
+|a|[]<b>x</b>[]|
+:term:desc
+::more
+--- expected
+name | value
+a | x
+
+term: desc more
+
+=== pre and super pre
+--- input
+>|
+  a <i>b</i>
+|<
+>|perl|
+print "<b>";
+||<
+--- expected
+  a b
+
+print "<b>";
+
+=== blockquote and comment
+--- input
+>http://example.com/:title=Quote>
+quoted
+<<
+<!--
+secret
+-->
+after
+--- expected
+quoted
+
+— Quote
+
+after
+`
+
+func TestToText(t *testing.T) {
+	x := NewXatena()
+	for _, b := range parseTestBlocks(textTestData) {
+		t.Run(b.Name, func(t *testing.T) {
+			got := x.ToText(context.Background(), b.Sections["input"], TextOptions{})
+			if got != b.Sections["expected"] {
+				t.Errorf("input:\n%s\n got:\n%q\nwant:\n%q", b.Sections["input"], got, b.Sections["expected"])
+			}
+		})
+	}
+}
+
+func TestToText_StopAtSeeMore(t *testing.T) {
+	input := "* title\nvisible\n====\nhidden\n* next\nalso hidden"
+	got := NewXatena().ToText(context.Background(), input, TextOptions{StopAtSeeMore: true})
+	if got != "title\n\nvisible" {
+		t.Errorf("got %q", got)
+	}
+	got = NewXatena().ToText(context.Background(), input, TextOptions{})
+	if got != "title\n\nvisible\n\nhidden\n\nnext\n\nalso hidden" {
+		t.Errorf("without StopAtSeeMore: got %q", got)
+	}
+}
+
+func TestToText_Truncate(t *testing.T) {
+	x := NewXatena()
+	ctx := context.Background()
+	cases := []struct {
+		name     string
+		input    string
+		opts     TextOptions
+		expected string
+	}{
+		{"no truncation needed", "short", TextOptions{MaxChars: 10, Ellipsis: "…"}, "short"},
+		{"plain text", "あいうえおかきくけこ", TextOptions{MaxChars: 5, Ellipsis: "…"}, "あいうえ…"},
+		{"does not cut a link title", "see [http://example.com/:title=Example Site] now", TextOptions{MaxChars: 12}, "see"},
+		{"does not cut a url", "go http://example.com/long/path", TextOptions{MaxChars: 20, Ellipsis: "..."}, "go..."},
+		{"keeps whole construct when it fits", "[http://example.com/:title=Ex] tail text", TextOptions{MaxChars: 6}, "Ex tai"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := x.ToText(ctx, c.input, c.opts)
+			if got != c.expected {
+				t.Errorf("got %q, want %q", got, c.expected)
+			}
+			if n := utf8.RuneCountInString(got); n > c.opts.MaxChars {
+				t.Errorf("length %d exceeds %d", n, c.opts.MaxChars)
+			}
+		})
+	}
+}
+
+func TestToText_TitleHandler(t *testing.T) {
+	f := NewInlineFormatter(func(f *InlineFormatter) {
+		f.SetTitleHandler(func(ctx context.Context, uri string) string { return "Fetched" })
+	})
+	got := NewXatenaWithInline(f).ToText(context.Background(), "[http://example.com/:title]", TextOptions{})
+	if got != "Fetched" {
+		t.Errorf("got %q", got)
+	}
+}
+
+// TestToText_NoMarkup: 既存のフィクスチャのどれからもタグや目印の文字が残らない
+func TestToText_NoMarkup(t *testing.T) {
+	x := NewXatena()
+	for _, input := range allTestInputs() {
+		got := x.ToText(context.Background(), input, TextOptions{MaxChars: 40})
+		if strings.ContainsAny(got, string([]rune{textAtomStart, textAtomEnd})) {
+			t.Errorf("marker left in %q", got)
+		}
+		if strings.Contains(got, "<p>") || strings.Contains(got, "<div") {
+			t.Errorf("markup left for %q: %q", input, got)
+		}
+	}
+}
+
+// TestToText_UsesInlineFormatterRules: 独自のルールや無効にしたルールも HTML と同じに解釈する
+func TestToText_UsesInlineFormatterRules(t *testing.T) {
+	ctx := context.Background()
+	f := NewInlineFormatter()
+	f.AddRuleAt(0, InlineRule{
+		Name:    "kbd",
+		Pattern: regexp.MustCompile(`\[kbd:([^\]]+)\]`),
+		Handler: func(ctx context.Context, f *InlineFormatter, m []string) string { return "<kbd>" + m[1] + "</kbd>" },
+	})
+	if got, want := NewXatena(WithInline(f)).ToText(ctx, "[kbd:C] x", TextOptions{}), "C x"; got != want {
+		t.Errorf("custom rule: got %q, want %q", got, want)
+	}
+
+	x := NewXatena(WithoutInlineRules(InlineTex, InlineFootnote))
+	if got, want := x.ToText(ctx, "[tex:x] ((n))", TextOptions{}), "[tex:x] ((n))"; got != want {
+		t.Errorf("without rules: got %q, want %q", got, want)
+	}
+
+	x = NewXatena(WithInline(NewAggressiveInlineFormatter(DefaultAggressiveURLs())))
+	if got, want := x.ToText(ctx, "[keyword:Go] [http://example.com/]", TextOptions{}), "Go http://example.com/"; got != want {
+		t.Errorf("aggressive: got %q, want %q", got, want)
+	}
+}
+
+// TestToText_ParagraphUnit: 行をまたぐ記法も HTML と同じく段落全体で解釈し、パース済みのインライン要素を使う
+func TestToText_ParagraphUnit(t *testing.T) {
+	ctx := context.Background()
+	x := NewXatena()
+	if got, want := x.ToText(ctx, "[]foo\n[tex:x][]", TextOptions{}), "foo\n[tex:x]"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	doc, _ := x.Parse(ctx, "a [http://example.com/]\n  b")
+	x.ParseInlines(ctx, doc)
+	doc.Content[0].(*Text).Inlines = []InlineNode{&InlineText{Text: "replaced"}}
+	if got := RenderText(ctx, doc, TextOptions{}); got != "replaced" {
+		t.Errorf("got %q", got)
+	}
+}
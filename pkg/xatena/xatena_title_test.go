@@ -0,0 +1,233 @@
+package xatena
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// titleServer は /page/N に "Page N" というタイトルのページを返す httptest サーバ
+// 同時に処理しているリクエスト数の最大値と、パスごとのリクエスト数を記録する
+type titleServer struct {
+	*httptest.Server
+	delay    time.Duration
+	inflight atomic.Int32
+	peak     atomic.Int32
+	mu       sync.Mutex
+	hits     map[string]int
+}
+
+func newTitleServer(t *testing.T, delay time.Duration) *titleServer {
+	s := &titleServer{delay: delay, hits: map[string]int{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := s.inflight.Add(1)
+		defer s.inflight.Add(-1)
+		for {
+			peak := s.peak.Load()
+			if n <= peak || s.peak.CompareAndSwap(peak, n) {
+				break
+			}
+		}
+		s.mu.Lock()
+		s.hits[r.URL.Path]++
+		s.mu.Unlock()
+		time.Sleep(s.delay)
+		if !strings.HasPrefix(r.URL.Path, "/page/") {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, "<html><head><title>Page %s</title></head></html>", strings.TrimPrefix(r.URL.Path, "/page/"))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *titleServer) requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, c := range s.hits {
+		n += c
+	}
+	return n
+}
+
+var reTestTitle = regexp.MustCompile(`<title>(.*?)</title>`)
+
+func httpTitleResolver() TitleResolver {
+	return TitleResolverFunc(func(ctx context.Context, uri string) (string, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+		if err != nil {
+			return "", err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("status %d", resp.StatusCode)
+		}
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return "", err
+		}
+		m := reTestTitle.FindSubmatch(body)
+		if m == nil {
+			return "", errors.New("no title")
+		}
+		return string(m[1]), nil
+	})
+}
+
+func titleLinks(base string, pages ...string) string {
+	var b strings.Builder
+	for _, p := range pages {
+		fmt.Fprintf(&b, "[%s/page/%s:title]\n", base, p)
+	}
+	return b.String()
+}
+
+func TestTitleResolverConcurrent(t *testing.T) {
+	s := newTitleServer(t, 30*time.Millisecond)
+	x := NewXatena(WithTitleResolver(httpTitleResolver(), 3))
+	input := titleLinks(s.URL, "1", "2", "3", "4", "5", "6", "7", "8") + "\n* " + titleLinks(s.URL, "1") + "\n- " + titleLinks(s.URL, "9")
+	got := x.ToHTML(context.Background(), input)
+	for i := 1; i <= 9; i++ {
+		want := fmt.Sprintf(`<a href="%s/page/%d">Page %d</a>`, s.URL, i, i)
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %s in %s", want, got)
+		}
+	}
+	if n := s.requests(); n != 9 {
+		t.Errorf("requests = %d, want 9 (one per URL)", n)
+	}
+	if peak := s.peak.Load(); peak < 2 || peak > 3 {
+		t.Errorf("peak concurrency = %d, want 2..3", peak)
+	}
+}
+
+func TestTitleResolverFailure(t *testing.T) {
+	s := newTitleServer(t, 0)
+	x := NewXatena(WithTitleResolver(httpTitleResolver(), 0))
+	input := fmt.Sprintf("[%s/missing:title] [%s/page/1:title=given]\n", s.URL, s.URL)
+	want := fmt.Sprintf(`<p><a href="%s/missing">%s/missing</a> <a href="%s/page/1">given</a></p>`, s.URL, s.URL, s.URL)
+	if got := x.ToHTML(context.Background(), input); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if n := s.requests(); n != 1 {
+		t.Errorf("requests = %d, want 1", n)
+	}
+}
+
+func TestTitleResolverMarkdownAndFootnote(t *testing.T) {
+	s := newTitleServer(t, 0)
+	x := NewXatena(WithTitleResolver(httpTitleResolver(), 2))
+	input := fmt.Sprintf("see((note [%s/page/2:title]))\n", s.URL)
+	if got := x.ToHTML(context.Background(), input); !strings.Contains(got, ">Page 2</a>") {
+		t.Errorf("footnote title not resolved: %s", got)
+	}
+	if got := x.ToMarkdown(context.Background(), titleLinks(s.URL, "3")); !strings.Contains(got, "[Page 3]") {
+		t.Errorf("markdown title not resolved: %s", got)
+	}
+}
+
+func TestTitleResolverLimit(t *testing.T) {
+	s := newTitleServer(t, 0)
+	x := NewXatena(WithTitleResolver(httpTitleResolver(), 4), WithLimits(Limits{MaxTitleFetches: 2}))
+	err := x.Render(context.Background(), io.Discard, titleLinks(s.URL, "1", "2", "3", "4"))
+	var le *LimitError
+	if !errors.As(err, &le) || le.Limit != LimitTitleFetches {
+		t.Fatalf("err = %v, want title fetches LimitError", err)
+	}
+	if n := s.requests(); n != 2 {
+		t.Errorf("requests = %d, want 2", n)
+	}
+}
+
+func TestTitleResolverLimitRepeatedURL(t *testing.T) {
+	s := newTitleServer(t, 0)
+	x := NewXatena(WithTitleResolver(httpTitleResolver(), 4), WithLimits(Limits{MaxTitleFetches: 3}))
+	var b strings.Builder
+	if err := x.Render(context.Background(), &b, titleLinks(s.URL, "1", "1", "1", "1", "1")); err != nil {
+		t.Fatalf("err = %v", err)
+	}
+	if n := strings.Count(b.String(), ">Page 1</a>"); n != 5 {
+		t.Errorf("%d links titled, want 5: %s", n, b.String())
+	}
+	if n := s.requests(); n != 1 {
+		t.Errorf("requests = %d, want 1", n)
+	}
+}
+
+func TestCachedTitleResolver(t *testing.T) {
+	s := newTitleServer(t, 0)
+	cache := NewLRUTitleCache(10)
+	x := NewXatena(WithTitleResolver(&CachedTitleResolver{Resolver: httpTitleResolver(), Cache: cache}, 2))
+	input := titleLinks(s.URL, "1", "2") + fmt.Sprintf("[%s/missing:title]\n", s.URL)
+	first := x.ToHTML(context.Background(), input)
+	second := x.ToHTML(context.Background(), input)
+	if first != second {
+		t.Errorf("outputs differ:\n%s\n%s", first, second)
+	}
+	// 失敗した取得はキャッシュしない
+	if n := s.requests(); n != 4 {
+		t.Errorf("requests = %d, want 4", n)
+	}
+	if cache.Len() != 2 {
+		t.Errorf("cache len = %d, want 2", cache.Len())
+	}
+}
+
+func TestLRUTitleCache(t *testing.T) {
+	c := NewLRUTitleCache(2)
+	c.Set("a", "A")
+	c.Set("b", "B")
+	c.Get("a")
+	c.Set("c", "C") // 最も長く使われていない b を捨てる
+	if _, ok := c.Get("b"); ok {
+		t.Errorf("b should be evicted")
+	}
+	for uri, want := range map[string]string{"a": "A", "c": "C"} {
+		if got, ok := c.Get(uri); !ok || got != want {
+			t.Errorf("Get(%q) = %q, %v", uri, got, ok)
+		}
+	}
+	c.Set("a", "A2")
+	if got, _ := c.Get("a"); got != "A2" || c.Len() != 2 {
+		t.Errorf("Get(a) = %q, len %d", got, c.Len())
+	}
+}
+
+func TestDiskTitleCache(t *testing.T) {
+	dir := t.TempDir() + "/titles"
+	c := NewDiskTitleCache(dir, time.Hour)
+	if _, ok := c.Get("http://example.com/"); ok {
+		t.Fatalf("empty cache hit")
+	}
+	c.Set("http://example.com/", "Example 日本語")
+	// 別のインスタンス (次のプロセス) からも読める
+	if got, ok := NewDiskTitleCache(dir, time.Hour).Get("http://example.com/"); !ok || got != "Example 日本語" {
+		t.Errorf("Get = %q, %v", got, ok)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(c.path("http://example.com/"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Get("http://example.com/"); ok {
+		t.Errorf("expired entry hit")
+	}
+	if _, ok := NewDiskTitleCache(dir, 0).Get("http://example.com/"); !ok {
+		t.Errorf("entry without TTL should hit")
+	}
+}